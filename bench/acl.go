@@ -0,0 +1,154 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"strconv"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// credentials of the digest ACLs the ACL depth benchmark sets
+const (
+	aclUser     = "zkbench"
+	aclPassword = "zkbench"
+)
+
+// aclMode describes how the chain of znodes leading to the measured leaf is
+// protected. Since children do not inherit the ACL of their parent, a leaf
+// left open below restricted parents stays readable without credentials.
+type aclMode struct {
+	name   string
+	parent bool // whether the znodes above the leaf are restricted
+	leaf   bool // whether the leaf is restricted
+}
+
+var aclModes = []aclMode{
+	{"OPEN", false, false},
+	{"ACL", true, true},
+	{"ACL_PARENT", true, false},
+}
+
+// runACLDepth measures reads and writes of a leaf at each of ACLDepths levels
+// below the namespace, with the chain of znodes open, protected by a digest
+// ACL, or protected everywhere but at the leaf. The overhead is the average
+// latency relative to the open chain of the same depth. As a check of ACL
+// semantics, every mode also reports whether a session without credentials
+// is denied reading the leaf, which should hold for ACL only.
+func (self *Benchmark) runACLDepth() {
+	af, err := self.openOutput("acl.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer af.Close()
+	if info, err := af.Stat(); err == nil && info.Size() == 0 {
+		af.WriteString("depth,mode,op,operations,errors,average_latency,99th_latency,overhead,unauth_read_denied\n")
+	}
+	if self.Backend == "etcd" {
+		log.Printf("[Bench]: skip ACL depth since the etcd backend does not support ACLs\n")
+		return
+	}
+	if self.root_client == nil {
+		return
+	}
+	client, err := NewClient(-1, "acl", self.Servers[0], ensemble(self.Endpoints, 0), self.Namespace)
+	if err != nil {
+		log.Printf("[Bench]: failed to create ACL session: %v\n", err)
+		return
+	}
+	defer client.closeConn()
+	if err := client.Conn.AddAuth("digest", []byte(aclUser+":"+aclPassword)); err != nil {
+		client.Log("error in adding ACL credentials: %v", err)
+		return
+	}
+	restricted := zk.DigestACL(zk.PermAll, aclUser, aclPassword)
+	val := randBytes(mrand.NewSource(time.Now().UnixNano()), self.ValueSizeBytes)
+	ops := []struct {
+		name    string
+		handler ReqHandler
+	}{
+		{"READ", func(ctx context.Context, c *Client, r *Request) error {
+			_, _, err := c.Read(ctx, r.key)
+			return err
+		}},
+		{"WRITE", func(ctx context.Context, c *Client, r *Request) error {
+			return c.Write(ctx, r.key, r.value)
+		}},
+	}
+
+	for _, depth := range self.ACLDepths {
+		open := make([]time.Duration, len(ops))
+		for _, mode := range aclModes {
+			// the chain below the namespace, created top-down
+			nodes := make([]string, depth)
+			p := fmt.Sprintf("%s/acl.%s.%d", self.Namespace, mode.name, depth)
+			for i := range nodes {
+				if i > 0 {
+					p += "/" + strconv.Itoa(i)
+				}
+				nodes[i] = p
+			}
+			conn := client.currentConn()
+			if conn == nil {
+				client.Log("no connection to create the chain of depth %d in mode %s", depth, mode.name)
+				return
+			}
+			for i, node := range nodes {
+				acl := zkCreateACL
+				if (i < depth-1 && mode.parent) || (i == depth-1 && mode.leaf) {
+					acl = restricted
+				}
+				if _, err := conn.Create(node, val, zkCreateFlags, acl); err != nil && err != zk.ErrNodeExists {
+					client.Log("error in creating %s: %v", node, err)
+				}
+			}
+			leaf := nodes[depth-1]
+			err := zk.ErrNoServer
+			if root := self.root_client.currentConn(); root != nil {
+				_, _, err = root.Get(leaf)
+			}
+			denied := err == zk.ErrNoAuth
+
+			for i, op := range ops {
+				optype := fmt.Sprintf("ACL.%s.%s.%d", mode.name, op.name, depth)
+				client.Stat = nil
+				generator := func(iter int64) *Request { return &Request{leaf, val} }
+				self.processRequests(client, READ, 0, 1, optype, self.NRequests, 1, false, true, generator, op.handler)
+				stat := client.Stat
+				if stat == nil || stat.Ops == 0 {
+					continue
+				}
+				stat.ComputePercentiles()
+				overhead := 1.0
+				if mode.name == "OPEN" {
+					open[i] = stat.AvgLatency
+				} else if open[i] > 0 {
+					overhead = float64(stat.AvgLatency) / float64(open[i])
+				}
+				af.WriteString(fmt.Sprintf("%d,%s,%s,%d,%d,%d,%d,%f,%t\n", depth, mode.name, op.name, stat.Ops, stat.Errors,
+					stat.AvgLatency.Nanoseconds(), stat.NinetyNinethLatency, overhead, denied))
+				log.Printf("[Bench]: %s: avg latency %s, overhead %f\n", optype, stat.AvgLatency, overhead)
+			}
+			// the in-memory store does not enforce ACLs
+			if denied != mode.leaf && self.Backend != "memory" {
+				log.Printf("[Bench]: unexpected ACL check at depth %d in mode %s: unauthenticated read denied %t\n",
+					depth, mode.name, denied)
+			}
+
+			// only the authenticated session may remove the restricted chain
+			conn = client.currentConn()
+			if conn == nil {
+				client.Log("no connection to delete the chain of depth %d in mode %s", depth, mode.name)
+				return
+			}
+			for i := depth - 1; i >= 0; i-- {
+				if err := conn.Delete(nodes[i], -1); err != nil {
+					client.Log("error in deleting %s: %v", nodes[i], err)
+				}
+			}
+		}
+	}
+}
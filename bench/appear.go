@@ -0,0 +1,92 @@
+package bench
+
+import (
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const appearKey = "appear"
+
+// runAppearance measures the service discovery pattern of waiting for a
+// znode to appear and then reading it. For every sample, a watcher sets an
+// exists watch on a znode that does not exist yet, a creator on another
+// session creates it, and once notified the watcher reads it. The latency
+// is the time from issuing the create until the read completed; the notify
+// latency is the part until the watch fired.
+func (self *Benchmark) runAppearance() {
+	ctx := self.context()
+	af, err := self.openOutput("appear.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer af.Close()
+	if info, err := af.Stat(); err == nil && info.Size() == 0 {
+		af.WriteString("samples,errors,average_create_latency,average_notify_latency,average_latency,99th_latency,max_latency,missed_notifications\n")
+	}
+	if self.Backend == "etcd" {
+		log.Printf("[Bench]: skip appearance latency since the etcd backend does not support watches\n")
+		return
+	}
+	if len(self.clients) == 0 {
+		return
+	}
+	watcher := self.clients[0]
+	s := len(self.Servers) - 1 // the creator prefers another server than the watcher
+	creator, err := NewClient(-1, "creator", self.Servers[s], ensemble(self.Endpoints, s), watcher.Namespace)
+	if err != nil {
+		log.Printf("[Bench]: failed to create creator session: %v\n", err)
+		return
+	}
+	defer creator.Conn.Close()
+	val := randBytes(mrand.NewSource(time.Now().UnixNano()), self.ValueSizeBytes)
+
+	var stat, create BenchStat
+	var notify time.Duration
+	missed := 0
+	log.Printf("[Bench]: start appearance latency for %d samples\n", self.AppearSamples)
+	for i := 0; i < self.AppearSamples; i++ {
+		key := fmt.Sprintf("%s.%d", appearKey, i)
+		exists, _, ch, err := watcher.ExistsW(ctx, key)
+		if err != nil || exists {
+			if err == nil {
+				err = zk.ErrNodeExists
+			}
+			watcher.Log("error in watching %s to appear: %v", key, err)
+			stat.add(watcher.ServerAddr(), time.Now(), 0, err)
+			continue
+		}
+		begin := time.Now()
+		err = creator.Create(ctx, key, val)
+		create.add(creator.ServerAddr(), begin, time.Since(begin), err)
+		if err != nil {
+			creator.Log("error in creating %s: %v", key, err)
+			stat.add(watcher.ServerAddr(), begin, time.Since(begin), err)
+			continue
+		}
+		select {
+		case <-ch:
+			notify += time.Since(begin)
+			_, _, err = watcher.Read(ctx, key)
+		case <-time.After(5 * time.Second):
+			missed++
+			err = fmt.Errorf("no notification")
+		}
+		stat.add(watcher.ServerAddr(), begin, time.Since(begin), err)
+		if err := creator.Delete(ctx, key); err != nil {
+			creator.Log("error in deleting %s: %v", key, err)
+		}
+	}
+	stat.finish()
+	create.finish()
+	var avgNotify time.Duration
+	if n := stat.Ops - stat.Errors; n > 0 {
+		avgNotify = notify / time.Duration(n)
+	}
+	af.WriteString(fmt.Sprintf("%d,%d,%d,%d,%d,%d,%d,%d\n", stat.Ops, stat.Errors, create.AvgLatency.Nanoseconds(),
+		avgNotify.Nanoseconds(), stat.AvgLatency.Nanoseconds(), stat.NinetyNinethLatency, stat.MaxLatency.Nanoseconds(), missed))
+	log.Printf("[Bench]: done appearance latency: avg %s, notify %s\n", stat.AvgLatency, avgNotify)
+}
@@ -0,0 +1,49 @@
+package bench
+
+import (
+	"fmt"
+)
+
+// Violation is a measured bench run that missed an SLA assertion.
+type Violation struct {
+	Type      BenchType
+	Run       int
+	Assertion string // config key of the assertion
+	Value     float64
+	Limit     float64
+}
+
+func (self Violation) String() string {
+	return fmt.Sprintf("%s run %d: %.6g violates %s = %g", self.Type.String(), self.Run, self.Value, self.Assertion, self.Limit)
+}
+
+// CheckAssertions returns the violations of the SLA assertions of config
+// by the READ, WRITE and MIXED runs of results, all clients merged. The
+// p99 latency is compared in ms and the throughput in req/s.
+func CheckAssertions(results []RunResult, config *BenchConfig) []Violation {
+	var violations []Violation
+	for _, result := range results {
+		if result.Type&(READ|WRITE|MIXED) == 0 {
+			continue
+		}
+		add := func(assertion string, value, limit float64) {
+			violations = append(violations, Violation{result.Type, result.Run, assertion, value, limit})
+		}
+		if config.AssertP99 > 0 && result.Stat.NinetyNinethLatency > config.AssertP99.Nanoseconds() {
+			add("assert_p99_ms", float64(result.Stat.NinetyNinethLatency)/1e6, float64(config.AssertP99)/1e6)
+		}
+		if config.AssertMaxErrorRate >= 0 {
+			rate := 0.0
+			if result.Stat.Ops > 0 {
+				rate = float64(result.Stat.Errors) / float64(result.Stat.Ops)
+			}
+			if rate > config.AssertMaxErrorRate {
+				add("assert_max_error_rate", rate, config.AssertMaxErrorRate)
+			}
+		}
+		if config.AssertMinThroughput > 0 && result.Throughput < config.AssertMinThroughput {
+			add("assert_min_throughput", result.Throughput, config.AssertMinThroughput)
+		}
+	}
+	return violations
+}
@@ -0,0 +1,31 @@
+package bench
+
+import (
+	mrand "math/rand"
+	"time"
+)
+
+// Backoff is an exponential reconnect backoff with full jitter. After the
+// n-th consecutive failed attempt a client waits a random delay in
+// [0, min(Max, Base*2^(n-1))], so clients that lost the same server spread
+// their reconnects out instead of hitting the remaining servers in lockstep.
+// A zero Base disables the backoff.
+type Backoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+// Delay returns the jittered delay before the n-th consecutive reconnect.
+func (self Backoff) Delay(n int32) time.Duration {
+	if self.Base <= 0 || n <= 0 {
+		return 0
+	}
+	ceil := self.Max
+	if shift := n - 1; shift < 32 && self.Base<<uint(shift) < ceil && self.Base<<uint(shift) > 0 {
+		ceil = self.Base << uint(shift)
+	}
+	if ceil <= 0 {
+		return 0
+	}
+	return time.Duration(mrand.Int63n(int64(ceil) + 1))
+}
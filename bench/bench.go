@@ -1,14 +1,18 @@
 package bench
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	mrand "math/rand"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/samuel/go-zookeeper/zk"
@@ -35,13 +39,53 @@ type Request struct {
 	value []byte
 }
 
-type ReqHandler func(c *Client, r *Request) error
+type ReqHandler func(ctx context.Context, c *Client, r *Request) error
 type ReqGenerator func(iter int64) *Request
 
 type Benchmark struct {
 	clients     []*Client
 	root_client *Client
 	initialized bool
+	outprefix   string
+	rawstream   *rawWriter
+	metrics     *metricsServer
+	sink        MetricSink
+	resultsdb   *resultsDB
+	coalescing  *keyTracker
+	progress    *progressReporter
+	runStart    time.Time       // of the current runBench, for MeasureAfter
+	opened      map[string]bool // the output files opened since a fresh Run
+	zxids       *zxidSampler
+	recorder    *opRecorder
+	replay      *opReplay
+	results     []RunResult
+	// initAttempts is the number of attempts Init needed
+	initAttempts int
+	// connectFailures are the clients that failed to connect in the
+	// attempts of Init
+	connectFailures []*ConnectError
+	// InitRetries is how many times a failed Init is retried
+	InitRetries int
+	// RecordPath records the key of every request of the bench runs and
+	// ReplayPath replays a recording in place of the generated keys
+	RecordPath string
+	ReplayPath string
+	// NoSetup benchmarks the data that already exists under the namespace:
+	// nothing is created or removed, and the requests target the children
+	// of the namespace instead of synthesized keys
+	NoSetup bool
+	// AggregateOnly replaces the per-client summary rows by a single row
+	// per bench run over all clients and disables the raw output
+	AggregateOnly bool
+	// Context bounds the requests of the benchmark: once it is done, the
+	// outstanding requests are abandoned and fail with its error. Nil
+	// means context.Background()
+	Context context.Context
+	// Dashboard, if set, shows the requests live on a terminal
+	Dashboard *Dashboard
+	// Format is the format of the summary and raw results, one of FORMATS.
+	// Empty means csv
+	Format string
 	BenchConfig
 }
 
@@ -72,17 +116,90 @@ func (self BenchType) String() string {
 	}
 }
 
+// Init connects and sets up all clients. If some of them fail, e.g. right
+// after an ensemble restart, the partial state is torn down and the whole
+// init is retried up to InitRetries times with backoff. Once the retries
+// are used up, clients that failed their setup are logged and left as is.
 func (self *Benchmark) Init() {
-	clients, err := NewClients(self.Servers, self.Endpoints, self.NClients, self.Namespace)
-	if err != nil {
+	if err := SetBackend(self.Backend); err != nil {
 		log.Fatal("Error:", err)
 	}
+	ConnectTimeout = self.ConnectTimeout
+	SessionTimeout = self.SessionTimeout
+	for attempt := 1; ; attempt++ {
+		err := self.tryInit()
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("[Bench]: initialized after %d attempts\n", attempt)
+			}
+			self.initAttempts = attempt
+			break
+		}
+		if attempt > self.InitRetries {
+			if self.clients == nil || self.NoSetup {
+				log.Fatal("Error:", err)
+			}
+			self.initAttempts = attempt
+			break
+		}
+		self.closeClients()
+		delay := initBackoff.Delay(int32(attempt))
+		log.Printf("[Bench]: init attempt %d failed: %v, retrying in %s\n", attempt, err, delay)
+		time.Sleep(delay)
+	}
+	self.initialized = true
+}
+
+// initBackoff spaces out the retries of Init.
+var initBackoff = Backoff{Base: time.Second, Max: 30 * time.Second}
+
+// tryInit makes one attempt at Init and returns the first error. Setup
+// errors are logged and do not stop the other clients from being set up.
+func (self *Benchmark) tryInit() error {
+	newClients := NewClients
+	if self.NoSetup {
+		// all clients work on the existing namespace
+		newClients = NewClientsForSharedZnode
+	}
+	clients, err := newClients(self.Servers, self.Endpoints, self.NClients, self.Namespace)
+	if err != nil {
+		var failure *ConnectError
+		if errors.As(err, &failure) {
+			self.connectFailures = append(self.connectFailures, failure)
+		}
+		return err
+	}
 	self.clients = clients
+	for _, client := range self.clients {
+		client.Backoff = self.ReconnectBackoff
+		client.Delay = delayFor(self.ClientDelays, client.Id)
+		client.OpTimeout = self.OpTimeout
+		client.CreateFlags, _ = createFlags(self.CreateMode) // checked by ParseConfig
+		if self.NoSetup {
+			client.CleanupNamespace = false
+		}
+	}
+	if self.NoSetup {
+		if len(self.Servers) > 0 {
+			self.root_client, err = NewClient(0, "root", self.Servers[0], ensemble(self.Endpoints, 0), self.Namespace)
+			if err != nil {
+				return err
+			}
+			self.root_client.CleanupNamespace = false
+		}
+		return self.discoverKeys()
+	}
+	var failed error
 	if len(self.Servers) > 0 {
-		self.root_client, _ = NewClient(0, "root", self.Servers[0], self.Endpoints[0], self.Namespace)
+		self.root_client, err = NewClient(0, "root", self.Servers[0], ensemble(self.Endpoints, 0), self.Namespace)
+		if err != nil {
+			return err
+		}
+		self.root_client.Backoff = self.ReconnectBackoff
 		err := self.root_client.Setup()
 		if err != nil {
 			self.root_client.Log("error in initializing root client: %v", err)
+			failed = err
 		}
 	} else {
 		self.root_client = nil
@@ -90,40 +207,170 @@ func (self *Benchmark) Init() {
 	for _, client := range self.clients {
 		err := client.Setup()
 		if err != nil {
-			client.Log("error in initializing client %s: %v", client.Id, err)
-			// log.Fatal(err)
+			client.Log("error in initializing client %d: %v", client.Id, err)
+			if failed == nil {
+				failed = err
+			}
 		}
 	}
+	return failed
+}
 
-	self.initialized = true
+// closeClients drops the connections of a failed init attempt without
+// touching the data.
+func (self *Benchmark) closeClients() {
+	for _, client := range self.clients {
+		if conn := client.currentConn(); conn != nil {
+			conn.Close()
+		}
+	}
+	self.clients = nil
+	if self.root_client != nil {
+		if conn := self.root_client.currentConn(); conn != nil {
+			conn.Close()
+		}
+		self.root_client = nil
+	}
 }
 
 func (self *Benchmark) Run(outprefix string, raw bool, nonstop bool, iter int64) {
 	if !self.initialized {
 		log.Fatal("Must initialize benchmark first")
 	}
-	summaryf, err := os.OpenFile(outprefix+"summary.dat", os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	self.outprefix = outprefix
+	// only non-stop iterations after the first add to the files of a prefix,
+	// the others replace what an earlier run left there
+	fresh := !nonstop || iter == 1
+	if fresh {
+		self.opened = make(map[string]bool)
+	}
+	manifest := self.startManifest(fresh)
+	sessionEvents.open(outprefix+"session_events.csv", fresh)
+	completed := false // a panic leaves the run aborted in the manifest
+	defer func() {
+		status := MANIFEST_COMPLETED
+		if !completed {
+			status = MANIFEST_ABORTED
+		}
+		self.finishManifest(manifest, status)
+	}()
+	flags := os.O_APPEND | os.O_CREATE | os.O_RDWR
+	if fresh {
+		flags |= os.O_TRUNC
+	}
+	asJSON := self.Format == "json"
+	summaryName := "summary.dat"
+	if asJSON {
+		summaryName = "summary.json"
+	}
+	summaryf, err := os.OpenFile(outprefix+summaryName, flags, 0644)
 	if err != nil {
 		panic(err)
 	}
-	if !nonstop || iter == 1 {
-		summaryf.WriteString("client_id,bench_type,run,operations,errors,average_latency,min_latency,max_latency,99th_latency,total_latency,throughput,group_start_time,throughput_every_sec\n")
+	if fresh && !asJSON {
+		summaryf.WriteString("client_id,bench_type,run,operations,errors,average_latency,min_latency,max_latency,99th_latency,total_latency,throughput,group_start_time,throughput_every_sec" + self.percentileHeader() + ",bytes_sent,bytes_received,mb_per_sec,injected_delay,mean_think_time,jitter,service_time_throughput,stddev_latency,cv_latency,server,endpoint,timeouts,sub_type,disconnects,expirations,intended_requests,achieved_rate_percent,err_average_latency,err_min_latency,err_max_latency,err_99th_latency\n")
+	}
+	if raw && self.AggregateOnly {
+		log.Printf("[Bench]: skip raw stats since only aggregates are written\n")
+		raw = false
 	}
-	var rawf *os.File
+	var rawf *rawFile
 	if raw {
-		rawf, err = os.OpenFile(outprefix+"raw.dat", os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+		rawf, err = openRawFile(outprefix, self.RawRotateBytes, self.RawRotateInterval, fresh, asJSON, self.RawCompress,
+			self.RawKeys, self.RawWarmup)
 		if err != nil {
 			panic(err)
 		}
-		if !nonstop || iter == 1 {
-			rawf.WriteString("client_id,bench_type,run,time,op_id,error,latency\n")
+	}
+	if self.ResultsDB != "" {
+		self.resultsdb, err = openResultsDB(self.ResultsDB, outprefix, &self.BenchConfig)
+		if err != nil {
+			panic(err)
+		}
+		// discards the run if it does not get to the end
+		defer func() {
+			if self.resultsdb != nil {
+				self.resultsdb.Close(false)
+				self.resultsdb = nil
+			}
+		}()
+	}
+	if self.MetricsAddr != "" {
+		self.metrics, err = startMetrics(self.MetricsAddr)
+		if err != nil {
+			panic(err)
 		}
+		defer func() {
+			self.metrics.Close()
+			self.metrics = nil
+		}()
+	}
+	if self.MetricSink != "" && self.MetricSink != "none" {
+		self.sink, err = newMetricSink(self.MetricSink, self.StatsdAddr)
+		if err != nil {
+			panic(err)
+		}
+		defer func() {
+			self.sink.Close()
+			self.sink = nil
+		}()
+	}
+	if self.ZxidSampleRate > 0 {
+		self.zxids, err = newZxidSampler(outprefix+"zxid.dat", self.ZxidSampleRate, fresh)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if self.RecordPath != "" {
+		self.recorder, err = newOpRecorder(self.RecordPath, fresh)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if self.ReplayPath != "" && self.replay == nil {
+		self.replay, err = loadReplay(self.ReplayPath)
+		if err != nil {
+			panic(err)
+		}
+	}
+	// the raw records are streamed to the raw file as requests complete
+	if rawf != nil {
+		self.rawstream = newRawWriter(rawf)
 	}
-	if !nonstop || iter == 1 {
-		self.runBench(WARM_UP, 1, summaryf, rawf)
-		if self.Type&CREATE != 0 {
-			self.runBench(CREATE, 1, summaryf, rawf) // create key space
-			self.runBench(FILL, 1, summaryf, rawf)   // fill in data
+	// also on a panic, so that a compressed raw file is not cut off
+	defer func() {
+		if self.rawstream != nil {
+			self.rawstream.Close()
+			self.rawstream = nil
+		}
+		if rawf != nil {
+			rawf.Close()
+		}
+	}()
+	if fresh {
+		self.logClockBase()
+		self.dumpConnections(summaryf)
+		if self.AdaptiveWarmup {
+			self.runAdaptiveWarmup(summaryf) // until latency settles
+		} else {
+			self.runBench(WARM_UP, 1, summaryf)
+		}
+		if self.WarmupChildren {
+			self.runChildWarmup() // sessions of MIXED request groups
+		}
+		if self.Type&CREATE != 0 && self.NoSetup {
+			log.Printf("[Bench]: skip CREATE and FILL of the existing data\n")
+		} else if self.Type&CREATE != 0 {
+			setupStartTime := time.Now()
+			self.settle(CREATE, 1)
+			self.runBench(CREATE, 1, summaryf) // create key space
+			createStats := make([]*BenchStat, len(self.clients))
+			for i, client := range self.clients {
+				createStats[i] = client.Stat
+			}
+			self.settle(FILL, 1)
+			self.runBench(FILL, 1, summaryf) // fill in data
+			self.dumpSetupStats(createStats, setupStartTime, summaryf)
 		}
 	}
 	// Mark the start of main injection just before READ/WRITE/MIXED runs
@@ -131,19 +378,94 @@ func (self *Benchmark) Run(outprefix string, raw bool, nonstop bool, iter int64)
 	// runs only apply to the actual benchmark
 	for i := 0; i < self.Runs; i++ {
 		if self.Type&READ != 0 {
-			self.runBench(READ, i+1, summaryf, rawf) // read
+			self.settle(READ, i+1)
+			self.runBench(READ, i+1, summaryf) // read
 		}
 		if self.Type&WRITE != 0 {
-			self.runBench(WRITE, i+1, summaryf, rawf) // write
+			self.settle(WRITE, i+1)
+			self.runBench(WRITE, i+1, summaryf) // write
 		}
 		if self.Type&MIXED != 0 {
-			self.runBench(MIXED, i+1, summaryf, rawf) // r/w
+			self.settle(MIXED, i+1)
+			if len(self.PhasedMix) > 0 {
+				self.runPhasedMix(i+1, summaryf) // r/w with changing ratio
+			} else {
+				self.runBench(MIXED, i+1, summaryf) // r/w
+			}
 		}
 	}
+	if len(self.Scenario) > 0 {
+		self.runScenario() // declarative multi-phase workload
+	}
+	if len(self.RateSweep) > 0 {
+		self.runRateSweep() // write latency vs offered rate
+	}
+	if self.CompareContention {
+		self.runContention() // one hot znode vs a znode per client
+	}
+	if self.ClientSweep != 0 {
+		self.runClientSweep() // throughput vs number of clients
+	}
+	if self.LatencyRouting {
+		self.runRouting() // round-robin vs latency-aware reads
+	}
+	if len(self.WatchCounts) > 0 {
+		self.runWatchFanout() // write latency vs number of watches
+	}
+	if self.AppearSamples > 0 {
+		self.runAppearance() // exists watch then read of a new znode
+	}
+	if self.RYWSamples > 0 {
+		self.runReadYourWrites() // write then read back on the same session
+	}
+	if self.TTLSamples > 0 {
+		self.runTTL() // TTL vs persistent node creation
+	}
+	if len(self.EphemeralCounts) > 0 {
+		self.runEphemeral() // session close time vs ephemeral count
+	}
+	if self.ChurnNodes > 0 {
+		self.runChurn() // create+delete at a steady node count
+	}
+	if self.DepthTo > 0 {
+		self.runDepth() // latency vs znode depth
+	}
+	if len(self.ACLDepths) > 0 {
+		self.runACLDepth() // ACL check overhead vs tree depth
+	}
+	if self.ElectionRounds > 0 {
+		self.runElection() // create-if-not-exists race
+	}
 	summaryf.Close()
-	if rawf != nil {
-		rawf.Close()
+	if self.resultsdb != nil {
+		if err := self.resultsdb.Close(true); err != nil {
+			log.Printf("[Bench]: failed to store the results in %s: %v\n", self.ResultsDB, err)
+		}
+		self.resultsdb = nil
+	}
+	if self.zxids != nil {
+		self.zxids.Close()
+		self.zxids = nil
+	}
+	if self.recorder != nil {
+		self.recorder.Close()
+		self.recorder = nil
 	}
+	completed = true
+}
+
+// openOutput opens the output file name of the current prefix, e.g. of a
+// benchmark, for appending. The first time after a fresh Run it truncates
+// what an earlier invocation left in the file; the later bench runs and
+// nonstop iterations append to it.
+func (self *Benchmark) openOutput(name string) (*os.File, error) {
+	flags := os.O_APPEND | os.O_CREATE | os.O_RDWR
+	path := self.outprefix + name
+	if self.opened != nil && !self.opened[path] {
+		flags |= os.O_TRUNC
+		self.opened[path] = true
+	}
+	return os.OpenFile(path, flags, 0644)
 }
 
 // markInjectionStart writes a single-line local timestamp to a fixed file path
@@ -170,16 +492,27 @@ func (self *Benchmark) markInjectionStart() {
 	_, _ = f.WriteString("inj," + now + "\n")
 }
 
-func (self *Benchmark) processRequests(client *Client, optype string, nrequests int64,
+// context returns the context the requests of the benchmark derive from.
+func (self *Benchmark) context() context.Context {
+	if self.Context == nil {
+		return context.Background()
+	}
+	return self.Context
+}
+
+func (self *Benchmark) processRequests(client *Client, btype BenchType, subtype BenchType, run int, optype string, nrequests int64,
 	parallelism int, random bool, same bool, generator ReqGenerator, handler ReqHandler) {
 
 	var req *Request
 	var stat BenchStat
 	var wg sync.WaitGroup
 	var mutex = &sync.Mutex{}
+	var measured time.Time // start of the first request after the warm-up
 
+	stat.BenchType, stat.SubType, stat.Run = btype, subtype, run
 	stat.OpType = optype
-	stat.Latencies = make([]BenchLatency, nrequests)
+	self.prepareStat(&stat, nrequests)
+	phase := self.context()
 	if same {
 		req = generator(-1)
 	}
@@ -189,8 +522,49 @@ func (self *Benchmark) processRequests(client *Client, optype string, nrequests
 	if parallelism > 1 {
 		client.AddChildren(parallelism)
 	}
+	// the sessions of the client and its children lost in the run
+	disconnects, expirations := client.sessionCounts()
+	sessionsLost := func() {
+		d, e := client.sessionCounts()
+		stat.Disconnects, stat.Expirations = d-disconnects, e-expirations
+	}
 	reqf := func(client *Client, zipf *mrand.Zipf, start, end int64, parallel bool) {
+		if parallel {
+			defer wg.Done()
+		}
+		req := req
+		sent, received := client.BytesTransferred()
+		var thought time.Duration
+		defer func() {
+			s, r := client.BytesTransferred()
+			if parallel {
+				mutex.Lock()
+				defer mutex.Unlock()
+			}
+			stat.addBytes(s-sent, r-received, end-start, self.ProtocolOverhead)
+			stat.ThinkTime += thought
+		}()
+		reporter := self.newRequestReporter(client, btype, run)
+		var rd *mrand.Rand
+		if self.ThinkTime > 0 {
+			rd = mrand.New(mrand.NewSource(time.Now().UnixNano() + start))
+		}
+		// with a rate limit, the parallel request groups of a client share
+		// its rate and issue requests on a fixed schedule
+		var interval time.Duration
+		if self.ClientRate > 0 {
+			interval = time.Duration(float64(time.Second) / self.ClientRate)
+			if parallel {
+				interval *= time.Duration(parallelism)
+			}
+		}
+		paced := time.Now()
 		for j := start; j < end; j++ {
+			var scheduled time.Time
+			if interval > 0 {
+				scheduled = paced.Add(time.Duration(j-start) * interval)
+				time.Sleep(time.Until(scheduled))
+			}
 			if !same {
 				if zipf != nil {
 					var key int64 = int64(zipf.Uint64()) + start
@@ -200,37 +574,52 @@ func (self *Benchmark) processRequests(client *Client, optype string, nrequests
 					req = generator(j)
 				}
 			}
+			if self.replay != nil {
+				req = self.replay.request(client.Id, optype, j, req)
+			}
+			if self.recorder != nil {
+				self.recorder.record(client.Id, optype, j, req.key)
+			}
+			if client.Delay > 0 {
+				time.Sleep(client.Delay) // simulated network delay, not measured
+			}
+			if self.coalescing != nil {
+				self.coalescing.begin(client.FullPath(req.key))
+			}
+			var read int64
+			if self.RawKeys {
+				read = atomic.LoadInt64(&client.readBytes)
+			}
+			ctx, cancel := context.WithCancel(phase)
 			begin := time.Now()
-			err := handler(client, req)
+			err := handler(ctx, client, req)
 			d := time.Since(begin)
+			cancel()
+			if self.coalescing != nil {
+				self.coalescing.end(client.FullPath(req.key))
+			}
+			out := &requestOutcome{key: req.key, scheduled: scheduled, begin: begin, d: d, err: err}
+			if self.RawKeys {
+				out.valueBytes = int64(len(req.value)) + atomic.LoadInt64(&client.readBytes) - read
+			}
 			if parallel {
 				mutex.Lock()
 			}
-			stat.Ops++
-			stat.Latencies[j].Start = begin
-			if err != nil {
-				stat.Errors++
-				client.Log("error in processing %s request for key %s: %v", optype, req.key, err)
-				if err == zk.ErrNoServer {
-					client.Reconnect()
-				}
-				stat.Latencies[j].Latency = -1
-			} else {
-				stat.Latencies[j].Latency = d
-				if j == 0 || d < stat.MinLatency {
-					stat.MinLatency = d
-				}
-				if j == 0 || d > stat.MaxLatency {
-					stat.MaxLatency = d
-				}
-				stat.TotalLatency += d
+			// warm-up requests are only kept for the raw output
+			warm := self.warmingUp(&stat, begin)
+			if !warm && (measured.IsZero() || begin.Before(measured)) {
+				measured = begin
 			}
+			latency := self.recordRequest(&stat, client, j, warm, out)
 			if parallel {
 				mutex.Unlock()
 			}
-		}
-		if parallel {
-			wg.Done()
+			reporter.report(j, optype, latency, err)
+			if rd != nil && j+1 < end {
+				think := self.thinkTime(rd)
+				time.Sleep(think)
+				thought += think
+			}
 		}
 	}
 	stat.StartTime = time.Now()
@@ -241,8 +630,11 @@ func (self *Benchmark) processRequests(client *Client, optype string, nrequests
 				break
 			}
 			end = start + group
-			if end > nrequests {
-				end = nrequests // cannot exceed more than nrequests
+			if end > nrequests || p == parallelism-1 {
+				end = nrequests // the last group takes the leftover requests
+			}
+			if end == start {
+				continue // fewer requests than groups
 			}
 			wg.Add(1)
 			c := client.GetChild(p)
@@ -259,6 +651,7 @@ func (self *Benchmark) processRequests(client *Client, optype string, nrequests
 			start = end
 		}
 		wg.Wait()
+		sessionsLost()
 		client.CloseChildren()
 	} else {
 		var zipf *mrand.Zipf
@@ -267,11 +660,23 @@ func (self *Benchmark) processRequests(client *Client, optype string, nrequests
 			zipf = mrand.NewZipf(rd, ZIPF_SKEW, 1.0, uint64(nrequests))
 		}
 		reqf(client, zipf, 0, nrequests, false)
+		sessionsLost()
 	}
 	stat.EndTime = time.Now()
-	stat.NinetyNinethLatency = SamplePercentile(LatArr2IntArr(stat.Latencies), .99)
-	stat.AvgLatency = stat.TotalLatency / time.Duration(stat.Ops)
-	stat.Throughput = float64(stat.Ops) / stat.TotalLatency.Seconds()
+	self.checkClock(client, stat.StartTime, stat.EndTime)
+	stat.dropUnissued()
+	if stat.WarmupOps > 0 {
+		stat.excludeWarmup()
+		if !measured.IsZero() {
+			stat.StartTime = measured
+		}
+	}
+	if self.ClientRate > 0 {
+		stat.IntendedOps = intendedOps(self.ClientRate, stat.EndTime.Sub(stat.StartTime))
+	}
+	stat.ComputePercentiles()
+	stat.computeAvgLatency()
+	stat.computeThroughput()
 
 	if client.Stat != nil {
 		// if the client already has stats, merge the stat
@@ -282,7 +687,7 @@ func (self *Benchmark) processRequests(client *Client, optype string, nrequests
 	}
 }
 
-func (self *Benchmark) runBench(btype BenchType, run int, statf *os.File, rawf *os.File) {
+func (self *Benchmark) runBench(btype BenchType, run int, statf *os.File) {
 	var empty []byte
 	var wg sync.WaitGroup
 
@@ -290,6 +695,23 @@ func (self *Benchmark) runBench(btype BenchType, run int, statf *os.File, rawf *
 	key := sameKey(self.KeySizeBytes)
 	val := randBytes(src, self.ValueSizeBytes)
 	fillVal := []byte("whosyourdaddy")
+	// by default all writes of this run send the same value
+	value := func() []byte { return val }
+	if self.RegenerateValues {
+		var srcmu sync.Mutex
+		value = func() []byte {
+			srcmu.Lock()
+			defer srcmu.Unlock()
+			return randBytes(src, self.ValueSizeBytes)
+		}
+	}
+	read := func(ctx context.Context, c *Client, r *Request) error {
+		_, stat, err := c.Read(ctx, r.key)
+		if err == nil && self.zxids != nil {
+			self.zxids.sample(c, btype, run, r.key, stat)
+		}
+		return err
+	}
 
 	// at most two concurrent request types (r/w)
 	generators := make([]ReqGenerator, 2)
@@ -303,8 +725,8 @@ func (self *Benchmark) runBench(btype BenchType, run int, statf *os.File, rawf *
 	switch btype {
 	case WARM_UP:
 		generators[0] = func(iter int64) *Request { return &Request{} }
-		handlers[0] = func(c *Client, r *Request) error {
-			_, _, err := c.Read(r.key)
+		handlers[0] = func(ctx context.Context, c *Client, r *Request) error {
+			_, _, err := c.Read(ctx, r.key)
 			return err
 		}
 		nrequests[0] = self.NRequests / 10 // warm up n/10 iterations
@@ -312,12 +734,9 @@ func (self *Benchmark) runBench(btype BenchType, run int, statf *os.File, rawf *
 		if self.SameKey {
 			generators[0] = func(iter int64) *Request { return &Request{key, empty} }
 		} else {
-			generators[0] = func(iter int64) *Request { return &Request{sequentialKey(self.KeySizeBytes, iter), empty} }
-		}
-		handlers[0] = func(c *Client, r *Request) error {
-			_, _, err := c.Read(r.key)
-			return err
+			generators[0] = func(iter int64) *Request { return &Request{self.keyAt(iter), empty} }
 		}
+		handlers[0] = read
 		if self.ReadPercent > 0 {
 			nrequests[0] = int64(float64(self.ReadPercent) * float64(self.NRequests))
 		} else {
@@ -327,12 +746,12 @@ func (self *Benchmark) runBench(btype BenchType, run int, statf *os.File, rawf *
 		random = self.RandomAccess
 	case WRITE:
 		if self.SameKey {
-			generators[0] = func(iter int64) *Request { return &Request{key, val} }
+			generators[0] = func(iter int64) *Request { return &Request{key, value()} }
 		} else {
-			generators[0] = func(iter int64) *Request { return &Request{sequentialKey(self.KeySizeBytes, iter), val} }
+			generators[0] = func(iter int64) *Request { return &Request{self.keyAt(iter), value()} }
 		}
-		handlers[0] = func(c *Client, r *Request) error {
-			return c.Write(r.key, r.value)
+		handlers[0] = func(ctx context.Context, c *Client, r *Request) error {
+			return c.Write(ctx, r.key, r.value)
 		}
 		if self.WritePercent > 0 {
 			nrequests[0] = int64(float64(self.WritePercent) * float64(self.NRequests))
@@ -345,46 +764,43 @@ func (self *Benchmark) runBench(btype BenchType, run int, statf *os.File, rawf *
 		if self.SameKey {
 			generators[0] = func(iter int64) *Request { return &Request{key, empty} }
 		} else {
-			generators[0] = func(iter int64) *Request { return &Request{sequentialKey(self.KeySizeBytes, iter), empty} }
+			generators[0] = func(iter int64) *Request { return &Request{self.keyAt(iter), empty} }
 		}
-		handlers[0] = func(c *Client, r *Request) error {
-			return c.Create(r.key, r.value)
+		handlers[0] = func(ctx context.Context, c *Client, r *Request) error {
+			return c.Create(ctx, r.key, r.value)
 		}
 		nrequests[0] = self.NRequests // full key space
 	case FILL:
 		if self.SameKey {
 			generators[0] = func(iter int64) *Request { return &Request{key, fillVal} }
 		} else {
-			generators[0] = func(iter int64) *Request { return &Request{sequentialKey(self.KeySizeBytes, iter), fillVal} }
+			generators[0] = func(iter int64) *Request { return &Request{self.keyAt(iter), fillVal} }
 		}
-		handlers[0] = func(c *Client, r *Request) error {
-			return c.Write(r.key, r.value)
+		handlers[0] = func(ctx context.Context, c *Client, r *Request) error {
+			return c.Write(ctx, r.key, r.value)
 		}
 		nrequests[0] = self.NRequests // full key space
 	case DELETE:
 		if self.SameKey {
 			generators[0] = func(iter int64) *Request { return &Request{key, empty} }
 		} else {
-			generators[0] = func(iter int64) *Request { return &Request{sequentialKey(self.KeySizeBytes, iter), empty} }
+			generators[0] = func(iter int64) *Request { return &Request{self.keyAt(iter), empty} }
 		}
-		handlers[0] = func(c *Client, r *Request) error {
-			return c.Delete(r.key)
+		handlers[0] = func(ctx context.Context, c *Client, r *Request) error {
+			return c.Delete(ctx, r.key)
 		}
 		nrequests[0] = self.NRequests // full requests
 	case MIXED:
 		if self.SameKey {
 			generators[0] = func(iter int64) *Request { return &Request{key, empty} }
-			generators[1] = func(iter int64) *Request { return &Request{key, val} }
+			generators[1] = func(iter int64) *Request { return &Request{key, value()} }
 		} else {
-			generators[0] = func(iter int64) *Request { return &Request{sequentialKey(self.KeySizeBytes, iter), empty} }
-			generators[1] = func(iter int64) *Request { return &Request{sequentialKey(self.KeySizeBytes, iter), val} }
-		}
-		handlers[0] = func(c *Client, r *Request) error {
-			_, _, err := c.Read(r.key)
-			return err
+			generators[0] = func(iter int64) *Request { return &Request{self.keyAt(iter), empty} }
+			generators[1] = func(iter int64) *Request { return &Request{self.keyAt(iter), value()} }
 		}
-		handlers[1] = func(c *Client, r *Request) error {
-			return c.Write(r.key, r.value)
+		handlers[0] = read
+		handlers[1] = func(ctx context.Context, c *Client, r *Request) error {
+			return c.Write(ctx, r.key, r.value)
 		}
 		if self.ReadPercent > 0 {
 			nrequests[0] = int64(float64(self.ReadPercent) * float64(self.NRequests))
@@ -404,20 +820,42 @@ func (self *Benchmark) runBench(btype BenchType, run int, statf *os.File, rawf *
 		parallelism = self.Parallelism
 	}
 
-	reqf := func(client *Client, nrequests int64, optype string, parallelims int, random bool, generator ReqGenerator, handler ReqHandler) {
+	reqf := func(client *Client, nrequests int64, subtype BenchType, parallelims int, random bool, generator ReqGenerator, handler ReqHandler) {
+		optype := statLabel(btype, subtype, run)
 		client.Log("start bench %s", optype)
-		self.processRequests(client, optype, nrequests, parallelism, random, self.SameKey, generator, handler)
+		// fresh values need a new request per iteration even for the same key
+		same := self.SameKey && !self.RegenerateValues
+		self.processRequests(client, btype, subtype, run, optype, nrequests, parallelism, random, same, generator, handler)
 		client.Log("done bench %s", optype)
 		wg.Done()
 	}
 
+	if self.TrackCoalescing {
+		self.coalescing = newKeyTracker()
+	}
+	readers := -1 // by default every client runs all request types
+	if btype == MIXED && self.ReadPoolFraction > 0 {
+		readers = self.readPoolSize()
+	}
+	self.progress = self.startProgress(fmt.Sprintf("%s run %d", btype.String(), run))
 	groupStartTime := time.Now()
-	for _, client := range self.clients {
+	self.runStart = groupStartTime
+	defer func() { self.runStart = time.Time{} }()
+	for i, client := range self.clients {
 		// since each run of a benchmark type is independent
 		// and that at the end of this function stat will be
 		// saved, we should reset the stat each time
 		client.Stat = nil
-		if concurrency > 1 {
+		if readers >= 0 {
+			// with asymmetric pools a client only runs its pool's type
+			pool := 1
+			if i < readers {
+				pool = 0
+			}
+			wg.Add(1)
+			self.progress.expect(nrequests[pool])
+			go reqf(client, nrequests[pool], subtypes[pool], parallelism, random, generators[pool], handlers[pool])
+		} else if concurrency > 1 {
 			// if the concurrency level is larger than 1
 			// need to create multiple clients to launch concurrent requests
 			// otherwise there will be data races
@@ -426,17 +864,19 @@ func (self *Benchmark) runBench(btype BenchType, run int, statf *os.File, rawf *
 				child := client.GetChild(i)
 				if child != nil {
 					wg.Add(1)
-					bstr := fmt.Sprintf("%s.%s.%d", btype.String(), subtypes[i].String(), run)
-					go reqf(child, nrequests[i], bstr, parallelism, random, generators[i], handlers[i])
+					self.progress.expect(nrequests[i])
+					go reqf(child, nrequests[i], subtypes[i], parallelism, random, generators[i], handlers[i])
 				}
 			}
 		} else {
 			wg.Add(1)
-			bstr := fmt.Sprintf("%s.%d", btype.String(), run)
-			go reqf(client, nrequests[0], bstr, parallelism, random, generators[0], handlers[0])
+			self.progress.expect(nrequests[0])
+			go reqf(client, nrequests[0], 0, parallelism, random, generators[0], handlers[0])
 		}
 	}
 	wg.Wait()
+	self.progress.Close()
+	self.progress = nil
 
 	// aggregate child request stats
 	// then destroy child clients
@@ -453,114 +893,443 @@ func (self *Benchmark) runBench(btype BenchType, run int, statf *os.File, rawf *
 				client.Stat.Merge(child.Stat)
 			} else {
 				client.Stat = child.Stat
-				// reset the optype
-				client.Stat.OpType = fmt.Sprintf("%s.%d", btype.String(), run)
 			}
-			child.Conn.Close()
-			child.Conn = nil
 		}
-		client.Children = nil
+		if client.Stat != nil {
+			// the children ran different request types
+			client.Stat.SubType = 0
+			client.Stat.OpType = client.Stat.Label()
+		}
+		client.CloseChildren()
 	}
 
 	// dump client stats
-	for _, client := range self.clients {
-		stat := client.Stat
-		statf.WriteString(fmt.Sprintf("%d,%s,%d,%d,%d,%d,%d,%d,%d,%s,%f,%s,", client.Id, btype.String(), run, stat.Ops,
-			stat.Errors, stat.AvgLatency.Nanoseconds(), stat.MinLatency.Nanoseconds(),
-			stat.MaxLatency.Nanoseconds(), stat.NinetyNinethLatency, stat.TotalLatency.String(), stat.Throughput,
-			groupStartTime.UTC().Format("2006-01-02T15:04:05.999999Z")))
+	self.dumpStats(btype, run, groupStartTime, statf)
+	if self.rawstream != nil {
+		self.rawstream.Flush() // the streamed records of the run are complete
+	}
+	if readers >= 0 {
+		self.dumpPoolStats(run, readers)
+	}
+	if self.ModelCheck {
+		outstanding := concurrency * parallelism
+		if readers >= 0 {
+			outstanding = parallelism
+		}
+		self.dumpModel(btype, run, outstanding)
+	}
+	if self.coalescing != nil {
+		self.dumpCoalescing(btype, run, self.coalescing)
+		self.coalescing = nil
+	}
+}
 
-		// output throughput for every second
+// warmingUp reports whether a request of stat that starts at begin is
+// still in the warm-up excluded from the stats: one of the first
+// SkipFirstOps requests of the stat, or one that starts within MeasureAfter
+// of the start of the bench run. All stats of a run, e.g. of the children
+// of a client, share the same cutoff time.
+func (self *Benchmark) warmingUp(stat *BenchStat, begin time.Time) bool {
+	if stat.Ops+stat.WarmupOps < self.SkipFirstOps {
+		return true
+	}
+	if self.MeasureAfter <= 0 {
+		return false
+	}
+	from := self.runStart
+	if from.IsZero() {
+		from = stat.StartTime
+	}
+	return begin.Before(from.Add(self.MeasureAfter))
+}
 
-		secondMap := make(map[int]int)
-		for _, latency := range stat.Latencies {
-			second := int(latency.Start.Add(latency.Latency).Sub(groupStartTime).Seconds())
-			secondMap[second] += 1
-		}
-		// fmt.Println(secondMap)
+// keepLatencies reports whether the bench runs keep the latency of every
+// request. Only the time series, CDF and buckets need them once the raw
+// records are streamed or the percentiles are estimated anyway;
+// otherwise the stats keep running stats and estimate their percentiles
+// with a t-digest, so that their memory does not grow with the number of
+// requests.
+func (self *Benchmark) keepLatencies() bool {
+	if self.TimeSeries || self.CDFPoints > 0 || self.BucketInterval > 0 {
+		return true
+	}
+	return self.rawstream == nil && self.LatencyMode != "tdigest"
+}
 
-		sortedSeconds := make([]int, 0, len(secondMap))
-		for k := range secondMap {
-			sortedSeconds = append(sortedSeconds, k)
+// metricSink returns the sink the requests are reported to, a no-op one
+// outside of Run or without a metric_sink and Dashboard.
+func (self *Benchmark) metricSink() MetricSink {
+	if self.Dashboard != nil && self.sink != nil {
+		return teeSink{self.sink, self.Dashboard}
+	} else if self.Dashboard != nil {
+		return self.Dashboard
+	} else if self.sink == nil {
+		return nopSink{}
+	}
+	return self.sink
+}
+
+// percentileHeader returns the summary columns of the configured percentiles.
+// With a client_rate, the percentiles of the corrected latencies follow.
+func (self *Benchmark) percentileHeader() string {
+	var cols string
+	for _, p := range self.Percentiles {
+		cols += ",p" + strconv.FormatFloat(p, 'f', -1, 64) + "_latency"
+	}
+	if self.ClientRate > 0 {
+		for _, p := range self.Percentiles {
+			cols += ",corrected_p" + strconv.FormatFloat(p, 'f', -1, 64) + "_latency"
 		}
-		sort.Ints(sortedSeconds)
+	}
+	return cols
+}
 
-		lastSecond := -1
-		for _, second := range sortedSeconds {
-			if lastSecond == -1 {
-				for i := 0; i < second; i++ {
-					statf.WriteString("0:")
-				}
-				lastSecond = second
-			} else { // lastSecond != second
-				statf.WriteString(":")
-				for i := 0; i < second-lastSecond-1; i++ {
-					statf.WriteString("0:")
-				}
-			}
-			statf.WriteString(fmt.Sprintf("%d", secondMap[second]))
-			lastSecond = second
+// percentileCols computes the configured percentiles of a stat from its
+// successful latencies, or its digest, so they stay correct for stats
+// merged from several children. With a client_rate, they are followed by the percentiles of
+// the latencies from the scheduled starts, which include the queueing
+// behind stalled requests that the closed loop otherwise hides.
+func (self *Benchmark) percentileCols(stat *BenchStat) string {
+	var cols string
+	if len(self.Percentiles) == 0 {
+		return cols
+	}
+	ps := make([]float64, len(self.Percentiles))
+	for i, p := range self.Percentiles {
+		ps[i] = p / 100
+	}
+	for _, v := range stat.percentiles(ps) {
+		cols += fmt.Sprintf(",%d", v.Nanoseconds())
+	}
+	if self.ClientRate > 0 {
+		for _, v := range stat.correctedPercentiles(ps) {
+			cols += fmt.Sprintf(",%d", v.Nanoseconds())
 		}
+	}
+	return cols
+}
 
-		statf.WriteString("\n")
+// bytesCols returns the estimated network bytes of a stat and the MB/s
+// they amount to over the stat's wall-clock time.
+func bytesCols(stat *BenchStat) string {
+	var mbps float64
+	if elapsed := stat.EndTime.Sub(stat.StartTime); elapsed > 0 {
+		mbps = float64(stat.BytesSent+stat.BytesReceived) / 1e6 / elapsed.Seconds()
 	}
-	if rawf != nil {
-		for _, client := range self.clients {
-			cid := client.Id
-			stat := client.Stat
-			for opid, latency := range stat.Latencies {
-				latency_error := 0
-				if latency.Latency < 0 {
-					latency_error = 1
-				}
-				rawf.WriteString(fmt.Sprintf("%d,%s,%d,%s,%d,%d,%d\n", cid, btype.String(), run, latency.Start.UTC().Format("2006-01-02T15:04:05.000Z07:00"), opid, latency_error, latency.Latency.Nanoseconds()))
-			}
+	return fmt.Sprintf(",%d,%d,%f", stat.BytesSent, stat.BytesReceived, mbps)
+}
+
+// delayCol returns the artificial delay injected before every request of
+// a client, which the latency columns exclude.
+func delayCol(delay time.Duration) string {
+	return fmt.Sprintf(",%d", delay.Nanoseconds())
+}
+
+// jitterCol returns the jitter of a stat, i.e. how much the latencies of
+// consecutive requests differ on average.
+func jitterCol(stat *BenchStat) string {
+	return fmt.Sprintf(",%d", stat.Jitter().Nanoseconds())
+}
+
+// serviceCol returns the throughput a stat would have without overlapping
+// requests, from its summed latency.
+func serviceCol(stat *BenchStat) string {
+	return fmt.Sprintf(",%f", stat.ServiceTimeThroughput)
+}
+
+// summaryCols returns the summary columns of a stat after the per-second
+// throughput.
+func (self *Benchmark) summaryCols(stat *BenchStat, delay time.Duration, server string) string {
+	return self.percentileCols(stat) + bytesCols(stat) + delayCol(delay) + thinkCol(stat) + jitterCol(stat) +
+		serviceCol(stat) + dispersionCols(stat) + serverCols(stat, server) + timeoutCol(stat) +
+		subTypeCol(stat) + sessionCols(stat) + rateCols(stat) + errLatencyCols(stat)
+}
+
+// errLatencyCols returns the average, min, max and p99 latency of the
+// failed requests of a stat, i.e. the time until they failed.
+func errLatencyCols(stat *BenchStat) string {
+	return fmt.Sprintf(",%d,%d,%d,%d", stat.ErrAvgLatency.Nanoseconds(), stat.ErrMinLatency.Nanoseconds(),
+		stat.ErrMaxLatency.Nanoseconds(), stat.ErrP99Latency.Nanoseconds())
+}
+
+// sessionCols returns how often the sessions of the clients of a stat
+// were disconnected and expired.
+func sessionCols(stat *BenchStat) string {
+	return fmt.Sprintf(",%d,%d", stat.Disconnects, stat.Expirations)
+}
+
+// subTypeCol returns the request type of a stat of a MIXED run, empty if
+// it covers several.
+func subTypeCol(stat *BenchStat) string {
+	if stat.SubType == 0 {
+		return ","
+	}
+	return "," + stat.SubType.String()
+}
+
+// timeoutCol returns how many of the errors of a stat were timeouts.
+func timeoutCol(stat *BenchStat) string {
+	return fmt.Sprintf(",%d", stat.Timeouts)
+}
+
+// serverCols returns the server of a summary row and the endpoint that
+// served most of its requests, which differs from the configured one if
+// the clients failed over. Rows of all servers have no endpoint.
+func serverCols(stat *BenchStat, server string) string {
+	if server == ALL_SERVERS {
+		return "," + server + ","
+	}
+	return "," + server + "," + stat.MajorityServer()
+}
+
+// dispersionCols returns the standard deviation and coefficient of
+// variation of the latencies of a stat.
+func dispersionCols(stat *BenchStat) string {
+	return fmt.Sprintf(",%d,%f", stat.StdDevLatency.Nanoseconds(), stat.CVLatency())
+}
+
+// aggregateStats merges the stats of all clients into a single stat. The
+// percentiles are taken over the merged latencies, or digests of stats
+// that keep none, and the throughput over the time span of all clients.
+// Returns the stat and the mean injected delay of the clients.
+func (self *Benchmark) aggregateStats(stats []*BenchStat) (*BenchStat, time.Duration) {
+	var all *BenchStat
+	var delay time.Duration
+	n := 0
+	for i, stat := range stats {
+		if stat == nil {
+			continue
 		}
+		if all == nil {
+			copied := *stat
+			copied.Latencies = append([]BenchLatency(nil), stat.Latencies...)
+			all = &copied
+		} else {
+			all.Merge(stat)
+		}
+		delay += self.clients[i].Delay
+		n++
+	}
+	if all == nil {
+		return nil, 0
 	}
+	all.ComputePercentiles()
+	return all, delay / time.Duration(n)
 }
 
-//CHANG: test on https://play.golang.org/p/zJ_4MktkMzg
-func SamplePercentile(values int64Slice, perc float64) int64 {
-	ps := []float64{perc}
+// ALL_CLIENTS is the client id of the summary rows that merge all clients,
+// written as ALL, and ALL_SERVERS the server of the rows that merge the
+// clients of all servers
+const (
+	ALL_CLIENTS = -1
+	ALL_SERVERS = "ALL"
+)
 
-	scores := make([]int64, len(ps))
-	size := len(values)
-	if size > 0 {
-		sort.Sort(values)
-		for i, p := range ps {
-			pos := p * float64(size+1) //ALTERNATIVELY, DROP THE +1
-			if pos < 1.0 {
-				scores[i] = int64(values[0])
-			} else if pos >= float64(size) {
-				scores[i] = int64(values[size-1])
-			} else {
-				lower := int64(values[int(pos)-1])
-				scores[i] = lower
+// clientCol returns the client_id column of a summary row.
+func clientCol(id int) string {
+	if id == ALL_CLIENTS {
+		return "ALL"
+	}
+	return strconv.Itoa(id)
+}
+
+// summaryRow formats the summary columns of a stat up to, but excluding,
+// the per-second throughput.
+func summaryRow(id int, btype string, run int, stat *BenchStat, groupStartTime time.Time) string {
+	return fmt.Sprintf("%s,%s,%d,%d,%d,%d,%d,%d,%d,%s,%f,%s,", clientCol(id), btype, run, stat.Ops,
+		stat.Errors, stat.AvgLatency.Nanoseconds(), stat.MinLatency.Nanoseconds(),
+		stat.MaxLatency.Nanoseconds(), stat.NinetyNinethLatency, stat.TotalLatency.String(), stat.Throughput,
+		groupStartTime.UTC().Format("2006-01-02T15:04:05.999999Z"))
+}
+
+// dumpSetupStats writes a SETUP summary row per client that combines the
+// CREATE stats with the FILL stats the clients currently hold, i.e. the
+// total cost of creating the key space and then setting its data, and a
+// row that merges the clients.
+func (self *Benchmark) dumpSetupStats(createStats []*BenchStat, groupStartTime time.Time, statf *os.File) {
+	setups := make([]*BenchStat, len(self.clients))
+	for i, client := range self.clients {
+		if createStats[i] == nil || client.Stat == nil {
+			continue
+		}
+		setup := *createStats[i]
+		setup.Latencies = nil // rebuilt below without touching the CREATE stats
+		setup.Merge(client.Stat)
+		setup.Latencies = append(append([]BenchLatency(nil), createStats[i].Latencies...), client.Stat.Latencies...)
+		setup.ComputePercentiles()
+		setups[i] = &setup
+		if !self.AggregateOnly {
+			self.storeSummary(client.Id, client.Server, "SETUP", 1, &setup)
+		}
+		if self.Format == "json" && !self.AggregateOnly {
+			self.writeSummaryJSON(statf, client.Id, client.Server, "SETUP", 1, &setup, groupStartTime, client.Delay, nil)
+		} else if !self.AggregateOnly {
+			statf.WriteString(summaryRow(client.Id, "SETUP", 1, &setup, groupStartTime) + self.summaryCols(&setup, client.Delay, client.Server) + "\n")
+		}
+	}
+	id := ALL_CLIENTS
+	if self.AggregateOnly {
+		id = 0
+	}
+	all, delay := self.aggregateStats(setups)
+	if all != nil {
+		self.storeSummary(id, ALL_SERVERS, "SETUP", 1, all)
+	}
+	if all != nil && self.Format == "json" {
+		self.writeSummaryJSON(statf, id, ALL_SERVERS, "SETUP", 1, all, groupStartTime, delay, nil)
+	} else if all != nil {
+		statf.WriteString(summaryRow(id, "SETUP", 1, all, groupStartTime) + self.summaryCols(all, delay, ALL_SERVERS) + "\n")
+	}
+}
+
+// dumpStats writes the summary row of every client for one bench run,
+// followed by rows with client id ALL that merge the clients of every
+// server and of all servers. With AggregateOnly, only the row of all servers is written,
+// with client id 0.
+func (self *Benchmark) dumpStats(btype BenchType, run int, groupStartTime time.Time, statf *os.File) {
+	stats := make([]*BenchStat, len(self.clients))
+	for i, client := range self.clients {
+		stats[i] = client.Stat
+		if client.Stat != nil && !self.AggregateOnly {
+			self.writeSummary(statf, client.Id, client.Server, btype.String(), run, client.Stat, groupStartTime, client.Delay)
+		}
+	}
+	id := ALL_CLIENTS
+	if self.AggregateOnly {
+		id = 0
+	} else {
+		for _, server := range self.Servers {
+			// the stats of the other clients are left out as nil
+			pinned := make([]*BenchStat, len(self.clients))
+			for i, client := range self.clients {
+				if client.Server == server {
+					pinned[i] = client.Stat
+				}
+			}
+			if all, delay := self.aggregateStats(pinned); all != nil {
+				self.writeSummary(statf, ALL_CLIENTS, server, btype.String(), run, all, groupStartTime, delay)
 			}
 		}
 	}
-	return scores[0]
+	if all, delay := self.aggregateStats(stats); all != nil {
+		self.writeSummary(statf, id, ALL_SERVERS, btype.String(), run, all, groupStartTime, delay)
+		warnSaturation(statLabel(btype, 0, run), all.IntendedOps, all.Ops)
+	}
+	self.recordResult(btype, run)
+	if self.CDFPoints > 0 {
+		self.dumpCDF(btype, run)
+	}
+	if self.TimeSeries {
+		self.dumpTimeSeries(btype, run, groupStartTime)
+	}
+	if self.BucketInterval > 0 {
+		self.dumpBuckets(btype, run)
+	}
 }
 
-func LatArr2IntArr(oldArr []BenchLatency) int64Slice {
-	var newArr []int64
+// storeSummary adds a summary row to the results database, if any.
+func (self *Benchmark) storeSummary(id int, server string, btype string, run int, stat *BenchStat) {
+	if self.resultsdb == nil {
+		return
+	}
+	if err := self.resultsdb.add(id, server, btype, run, stat); err != nil {
+		panic(err)
+	}
+}
 
-	for i := range oldArr {
-		newArr = append(newArr, int64(oldArr[i].Latency.Nanoseconds()))
+// writeSummary writes the summary row of a stat including its throughput
+// in every second since groupStartTime.
+func (self *Benchmark) writeSummary(statf *os.File, id int, server string, btype string, run int, stat *BenchStat,
+	groupStartTime time.Time, delay time.Duration) {
+	stat.ComputePercentiles() // latencies may have been merged since
+	self.storeSummary(id, server, btype, run, stat)
+	if self.Format == "json" {
+		self.writeSummaryJSON(statf, id, server, btype, run, stat, groupStartTime, delay, secondCounts(stat, groupStartTime))
+		return
 	}
+	statf.WriteString(summaryRow(id, btype, run, stat, groupStartTime))
+
+	// output throughput for every second
 
-	return newArr
+	secondMap := completedPerSecond(stat, groupStartTime)
+	// fmt.Println(secondMap)
+
+	sortedSeconds := make([]int, 0, len(secondMap))
+	for k := range secondMap {
+		sortedSeconds = append(sortedSeconds, k)
+	}
+	sort.Ints(sortedSeconds)
+
+	lastSecond := -1
+	for _, second := range sortedSeconds {
+		if lastSecond == -1 {
+			for i := 0; i < second; i++ {
+				statf.WriteString("0:")
+			}
+			lastSecond = second
+		} else { // lastSecond != second
+			statf.WriteString(":")
+			for i := 0; i < second-lastSecond-1; i++ {
+				statf.WriteString("0:")
+			}
+		}
+		statf.WriteString(fmt.Sprintf("%d", secondMap[second]))
+		lastSecond = second
+	}
+
+	statf.WriteString(self.summaryCols(stat, delay, server) + "\n")
 }
 
-func (self *Benchmark) SmokeTest() {
+// SmokeTest lists the namespace through every client, giving up on a
+// client after SmokeTimeout, and logs how many clients of each server
+// passed. With RequireSmokePass, any failure is returned as an error.
+func (self *Benchmark) SmokeTest() error {
+	type result struct {
+		children []string
+		stat     *zk.Stat
+		err      error
+	}
+	passed := make(map[string]int)
+	failed := make(map[string]int)
 	for _, client := range self.clients {
-		children, stat, _, err := client.Conn.ChildrenW(self.Namespace)
-		if err != nil {
-			log.Println(err)
-			// panic(err)
+		done := make(chan result, 1)
+		go func(client *Client) {
+			conn := client.currentConn()
+			if conn == nil {
+				done <- result{err: zk.ErrNoServer}
+				return
+			}
+			children, stat, _, err := conn.ChildrenW(self.Namespace)
+			done <- result{children, stat, err}
+		}(client)
+		var r result
+		select {
+		case r = <-done:
+		case <-time.After(self.SmokeTimeout):
+			r.err = fmt.Errorf("no response within %s", self.SmokeTimeout)
+		}
+		if r.err != nil {
+			client.Log("smoke test failed: %v", r.err)
+			failed[client.Server]++
+			continue
 		}
-		client.Log("children: %+v; stat: %+v", children, stat)
+		passed[client.Server]++
+		client.Log("children: %+v; stat: %+v", r.children, r.stat)
 	}
+	for i, server := range self.Servers {
+		if passed[server]+failed[server] > 0 {
+			log.Printf("[Bench]: smoke test of %s (%s): %d clients passed, %d failed\n",
+				server, self.Endpoints[i], passed[server], failed[server])
+		}
+	}
+	nfailed := 0
+	for _, n := range failed {
+		nfailed += n
+	}
+	if nfailed > 0 && self.RequireSmokePass {
+		return fmt.Errorf("smoke test failed for %d of %d clients", nfailed, len(self.clients))
+	}
+	return nil
 }
 
 func (self *Benchmark) Done() {
@@ -591,6 +1360,43 @@ func (self *Benchmark) Done() {
 	}
 }
 
+// keyAt returns the key of the iter-th request: an entry of KeyList if one
+// was configured, otherwise a synthesized sequential key.
+func (self *Benchmark) keyAt(iter int64) string {
+	if len(self.KeyList) > 0 {
+		return self.KeyList[iter%int64(len(self.KeyList))]
+	}
+	return sequentialKey(self.KeySizeBytes, iter)
+}
+
+// discoverKeys sets KeyList to the existing children of the namespace, unless
+// a key list was configured.
+func (self *Benchmark) discoverKeys() error {
+	if len(self.KeyList) > 0 {
+		return nil
+	}
+	if self.root_client == nil {
+		return fmt.Errorf("no server to discover the keys of %s", self.Namespace)
+	}
+	conn := self.root_client.currentConn()
+	if conn == nil {
+		return fmt.Errorf("no connection to discover the keys of %s", self.Namespace)
+	}
+	children, _, err := conn.Children(self.Namespace)
+	if err != nil {
+		return fmt.Errorf("failed to discover the keys of %s: %v", self.Namespace, err)
+	}
+	if len(children) == 0 {
+		return fmt.Errorf("no keys under %s", self.Namespace)
+	}
+	self.KeyList = make([]string, len(children))
+	for i, child := range children {
+		self.KeyList[i] = self.root_client.FullPath(child)
+	}
+	log.Printf("[Bench]: discovered %d keys under %s\n", len(children), self.Namespace)
+	return nil
+}
+
 func sameKey(size int64) string {
 	return strings.Repeat("x", int(size))
 }
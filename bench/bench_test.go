@@ -0,0 +1,429 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runSelfTest runs the benchmarks of the config spec, e.g. against the
+// memory backend as -selftest does, with the results in format and the raw
+// output streamed into dir. Returns the benchmark and its output prefix.
+func runSelfTest(t *testing.T, dir, spec, format string) (*Benchmark, string) {
+	conf := filepath.Join(dir, "bench.conf")
+	if err := os.WriteFile(conf, []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ParseConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := new(Benchmark)
+	b.BenchConfig = *config
+	b.Format = format
+	b.Init()
+	if err := b.SmokeTest(); err != nil {
+		t.Fatal(err)
+	}
+	prefix := filepath.Join(dir, "zkresult-")
+	b.Run(prefix, true, false, 1)
+	b.Done()
+	return b, prefix
+}
+
+// readSummary returns the rows of a summary.dat, each by column name.
+func readSummary(t *testing.T, prefix string) []map[string]string {
+	data, err := os.ReadFile(prefix + "summary.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	header := strings.Split(lines[0], ",")
+	var rows []map[string]string
+	for _, line := range lines[1:] {
+		row := make(map[string]string)
+		for i, col := range strings.Split(line, ",") {
+			if i < len(header) {
+				row[header[i]] = col
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// column returns a column of a summary row as an integer.
+func column(t *testing.T, row map[string]string, name string) int64 {
+	v, err := strconv.ParseInt(row[name], 10, 64)
+	if err != nil {
+		t.Fatalf("column %s of %s row: %v", name, row["bench_type"], err)
+	}
+	return v
+}
+
+// selfTestConf is the config of the self tests, of selfTestClients
+// clients sending selfTestRequests requests each.
+const selfTestConf = `namespace = zkselftest
+clients = 2
+requests = 200
+parallelism = 2
+key_size_bytes = 8
+value_size_bytes = 16
+same_key = false
+random_access = false
+runs = 1
+cleanup = true
+type = crum
+server.0 = localhost:1
+backend = memory
+`
+
+const selfTestClients, selfTestRequests = 2, 200
+
+func TestSelfTest(t *testing.T) {
+	const clients, requests = selfTestClients, selfTestRequests
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"streamed", ""},
+		{"tdigest", "latency_mode = tdigest\n"},
+		// the time series keeps the latency of every request
+		{"exact", "timeseries = true\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, prefix := runSelfTest(t, t.TempDir(), selfTestConf+test.config, "csv")
+
+			rows := readSummary(t, prefix)
+			counted := make(map[string]int)
+			for _, row := range rows {
+				btype := row["bench_type"]
+				if row["client_id"] == "ALL" || btype != "READ" && btype != "WRITE" {
+					continue
+				}
+				counted[btype]++
+				if ops := column(t, row, "operations"); ops != requests {
+					t.Errorf("%s: %d operations, want %d", btype, ops, requests)
+				}
+				if errs := column(t, row, "errors"); errs != 0 {
+					t.Errorf("%s: %d errors", btype, errs)
+				}
+				if column(t, row, "average_latency") <= 0 || column(t, row, "99th_latency") <= 0 {
+					t.Errorf("%s: average %s p99 %s", btype, row["average_latency"], row["99th_latency"])
+				}
+			}
+			if counted["READ"] != clients || counted["WRITE"] != clients {
+				t.Errorf("%d READ and %d WRITE rows, want %d each", counted["READ"], counted["WRITE"], clients)
+			}
+
+			checkAllRow(t, b, rows)
+
+			raw, err := os.ReadFile(prefix + "raw.dat")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := strings.Count(string(raw), ",READ,1,"); n != clients*requests {
+				t.Errorf("%d raw READ records, want %d", n, clients*requests)
+			}
+		})
+	}
+}
+
+// checkAllRow checks that the ALL row of the last bench run, MIXED, equals
+// the merge of the client stats of that run.
+func checkAllRow(t *testing.T, b *Benchmark, rows []map[string]string) {
+	var want *BenchStat
+	for _, client := range b.clients {
+		if want == nil {
+			copied := *client.Stat
+			copied.Latencies = append([]BenchLatency(nil), client.Stat.Latencies...)
+			want = &copied
+		} else {
+			want.Merge(client.Stat)
+		}
+	}
+	want.ComputePercentiles()
+	var all map[string]string
+	for _, row := range rows {
+		if row["client_id"] == "ALL" && row["bench_type"] == "MIXED" && row["server"] == "ALL" {
+			all = row
+		}
+	}
+	if all == nil {
+		t.Fatal("no ALL row of MIXED in the summary")
+	}
+	cols := []struct {
+		name string
+		want int64
+	}{
+		{"operations", want.Ops},
+		{"errors", want.Errors},
+		{"average_latency", want.AvgLatency.Nanoseconds()},
+		{"min_latency", want.MinLatency.Nanoseconds()},
+		{"max_latency", want.MaxLatency.Nanoseconds()},
+		{"99th_latency", want.NinetyNinethLatency},
+		{"p50_latency", want.P50Latency.Nanoseconds()},
+		{"p90_latency", want.P90Latency.Nanoseconds()},
+		{"p95_latency", want.P95Latency.Nanoseconds()},
+		{"p99_latency", want.P99Latency.Nanoseconds()},
+		{"p99.9_latency", want.P999Latency.Nanoseconds()},
+	}
+	for _, col := range cols {
+		if got := column(t, all, col.name); got != col.want {
+			t.Errorf("ALL row: %s %d, want %d of the merged clients", col.name, got, col.want)
+		}
+	}
+}
+
+// TestRunTruncates reruns into the prefix of a longer run and checks that
+// nothing of the longer run is left in its files.
+func TestRunTruncates(t *testing.T) {
+	dir := t.TempDir()
+	runSelfTest(t, dir, selfTestConf, "csv")
+	const requests = 20
+	short := strings.Replace(selfTestConf, "requests = 200", "requests = "+strconv.Itoa(requests), 1)
+	_, prefix := runSelfTest(t, dir, short, "csv")
+
+	summary, err := os.ReadFile(prefix + "summary.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(summary), "client_id,"); n != 1 {
+		t.Errorf("%d summary headers, want 1", n)
+	}
+	for _, row := range readSummary(t, prefix) {
+		if btype := row["bench_type"]; row["client_id"] != "ALL" && (btype == "READ" || btype == "WRITE") {
+			if ops := column(t, row, "operations"); ops != requests {
+				t.Errorf("%s: %d operations, want %d", btype, ops, requests)
+			}
+		}
+	}
+	raw, err := os.ReadFile(prefix + "raw.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(raw), ",READ,1,"); n != selfTestClients*requests {
+		t.Errorf("%d raw READ records, want %d", n, selfTestClients*requests)
+	}
+}
+
+// TestRunTruncatesOutputs leaves stale output files in the prefix and
+// checks that a fresh run replaces them, while its bench runs append.
+func TestRunTruncatesOutputs(t *testing.T) {
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "zkresult-")
+	for _, name := range []string{"timeseries.dat", "cdf.csv", "raw.0.dat", "raw.1.dat"} {
+		if err := os.WriteFile(prefix+name, []byte("stale\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spec := selfTestConf + "timeseries = true\ncdf_points = 10\nraw_rotate_size_mb = 1\n"
+	runSelfTest(t, dir, spec, "csv")
+
+	for _, name := range []string{"timeseries.dat", "cdf.csv", "raw.0.dat"} {
+		data, err := os.ReadFile(prefix + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "stale") {
+			t.Errorf("%s: line of an earlier run left", name)
+		}
+		if n := strings.Count(string(data), "bench_type,"); n != 1 {
+			t.Errorf("%s: %d headers, want 1", name, n)
+		}
+		for _, btype := range []string{"READ", "WRITE", "MIXED"} {
+			if !strings.Contains(string(data), btype+",1,") {
+				t.Errorf("%s: no %s run", name, btype)
+			}
+		}
+	}
+	if _, err := os.Stat(prefix + "raw.1.dat"); err == nil {
+		t.Error("raw chunk of an earlier run left")
+	}
+}
+
+// TestParallelThroughput delays every request by a known time and checks
+// that the throughput of parallel requests is taken over the wall-clock
+// time rather than the summed latencies, which leave out the delay.
+func TestParallelThroughput(t *testing.T) {
+	const parallelism, delay = 4, 10 * time.Millisecond
+	spec := strings.Replace(selfTestConf, "parallelism = 2", "parallelism = 4", 1)
+	spec = strings.Replace(spec, "type = crum", "type = cm", 1) + "client_delay = 10ms\n"
+	_, prefix := runSelfTest(t, t.TempDir(), spec, "csv")
+
+	// a MIXED run sends its reads and its writes side by side, each in
+	// parallelism groups
+	want := 2 * parallelism / delay.Seconds()
+	rows := 0
+	for _, row := range readSummary(t, prefix) {
+		if row["bench_type"] != "MIXED" || row["client_id"] == "ALL" {
+			continue
+		}
+		rows++
+		throughput, err := strconv.ParseFloat(row["throughput"], 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// sleeps overshoot, but the summed latencies would be way off
+		if throughput < .75*want || throughput > 1.1*want {
+			t.Errorf("client %s: throughput %.1f, want about %.1f", row["client_id"], throughput, want)
+		}
+	}
+	if rows != selfTestClients {
+		t.Errorf("%d MIXED rows, want %d", rows, selfTestClients)
+	}
+}
+
+// TestLeftoverRequests runs more requests than split evenly over the
+// parallel request groups and checks that every request is sent once and
+// that no unsent request counts as a success.
+func TestLeftoverRequests(t *testing.T) {
+	tests := []struct {
+		requests    int
+		parallelism int
+	}{
+		{10, 3},
+		{20, 7},
+		{20, 30},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d over %d", test.requests, test.parallelism), func(t *testing.T) {
+			spec := strings.NewReplacer(
+				"requests = 200", "requests = "+strconv.Itoa(test.requests),
+				"parallelism = 2", "parallelism = "+strconv.Itoa(test.parallelism),
+				"type = crum", "type = cm").Replace(selfTestConf)
+			// the time series keeps the latencies to check
+			spec += "timeseries = true\n"
+			b, prefix := runSelfTest(t, t.TempDir(), spec, "csv")
+
+			for _, client := range b.clients {
+				if n := len(client.Stat.Latencies); int64(n) != client.Stat.Ops {
+					t.Errorf("client %d: %d latencies of %d operations", client.Id, n, client.Stat.Ops)
+				}
+				for _, l := range client.Stat.Latencies {
+					if l.Start.IsZero() {
+						t.Fatalf("client %d: latency of an unsent request", client.Id)
+					}
+				}
+			}
+			for _, row := range readSummary(t, prefix) {
+				if row["bench_type"] != "MIXED" || row["client_id"] == "ALL" {
+					continue
+				}
+				if errs := column(t, row, "errors"); errs != 0 {
+					t.Errorf("client %s: %d errors", row["client_id"], errs)
+				}
+				if column(t, row, "min_latency") <= 0 {
+					t.Errorf("client %s: min latency %s", row["client_id"], row["min_latency"])
+				}
+			}
+		})
+	}
+}
+
+// TestServerAssignment checks that clients are assigned to the servers
+// round-robin, and that the summary has the server of every client and the
+// rows merging the clients of every server.
+func TestServerAssignment(t *testing.T) {
+	spec := strings.Replace(selfTestConf, "clients = 2", "clients = 5", 1)
+	spec = strings.Replace(spec, "type = crum", "type = cr", 1)
+	spec = strings.Replace(spec, "server.0 = localhost:1\n", "server.0 = localhost:1\nserver.1 = localhost:2\nserver.2 = localhost:3\n", 1)
+	_, prefix := runSelfTest(t, t.TempDir(), spec, "csv")
+
+	rows := make(map[string]map[string]string)
+	for _, row := range readSummary(t, prefix) {
+		if row["bench_type"] == "READ" {
+			rows[row["client_id"]+" "+row["server"]] = row
+		}
+	}
+	tests := []struct {
+		client   string
+		server   string
+		endpoint string
+		ops      int64
+	}{
+		{"1", "server.0", "localhost:1", selfTestRequests},
+		{"2", "server.1", "localhost:2", selfTestRequests},
+		{"3", "server.2", "localhost:3", selfTestRequests},
+		{"4", "server.0", "localhost:1", selfTestRequests},
+		{"5", "server.1", "localhost:2", selfTestRequests},
+		{"ALL", "server.0", "localhost:1", 2 * selfTestRequests},
+		{"ALL", "server.1", "localhost:2", 2 * selfTestRequests},
+		{"ALL", "server.2", "localhost:3", selfTestRequests},
+		{"ALL", "ALL", "", 5 * selfTestRequests},
+	}
+	for _, test := range tests {
+		t.Run(test.client+" "+test.server, func(t *testing.T) {
+			row := rows[test.client+" "+test.server]
+			if row == nil {
+				t.Fatalf("no READ row of client %s on %s", test.client, test.server)
+			}
+			if row["endpoint"] != test.endpoint {
+				t.Errorf("endpoint %s, want %s", row["endpoint"], test.endpoint)
+			}
+			if ops := column(t, row, "operations"); ops != test.ops {
+				t.Errorf("%d operations, want %d", ops, test.ops)
+			}
+		})
+	}
+	if len(rows) != len(tests) {
+		t.Errorf("%d READ rows, want %d", len(rows), len(tests))
+	}
+}
+
+// TestSummaryFields checks the bench type, sub type and run of the client
+// stats of a MIXED run, merged over its request types, and of the summary
+// in both formats.
+func TestSummaryFields(t *testing.T) {
+	spec := strings.Replace(selfTestConf, "type = crum", "type = cm", 1)
+	for _, format := range FORMATS {
+		t.Run(format, func(t *testing.T) {
+			b, prefix := runSelfTest(t, t.TempDir(), spec, format)
+			for _, client := range b.clients {
+				stat := client.Stat
+				if stat.BenchType != MIXED || stat.SubType != 0 || stat.Run != 1 || stat.Label() != "MIXED.1" {
+					t.Errorf("client %d: bench type %s sub type %s run %d label %s, want MIXED.1",
+						client.Id, stat.BenchType, stat.SubType, stat.Run, stat.Label())
+				}
+			}
+
+			var rows []map[string]string
+			if format == "csv" {
+				rows = readSummary(t, prefix)
+			} else {
+				data, err := os.ReadFile(prefix + "summary.json")
+				if err != nil {
+					t.Fatal(err)
+				}
+				dec := json.NewDecoder(strings.NewReader(string(data)))
+				for dec.More() {
+					var rec summaryRecord
+					if err := dec.Decode(&rec); err != nil {
+						t.Fatal(err)
+					}
+					rows = append(rows, map[string]string{"bench_type": rec.BenchType,
+						"run": strconv.Itoa(rec.Run), "sub_type": rec.SubType})
+				}
+			}
+			mixed := 0
+			for _, row := range rows {
+				if row["bench_type"] != "MIXED" {
+					continue
+				}
+				mixed++
+				if row["run"] != "1" || row["sub_type"] != "" {
+					t.Errorf("MIXED row of run %s and sub type %s, want run 1 without", row["run"], row["sub_type"])
+				}
+			}
+			if mixed == 0 {
+				t.Error("no MIXED rows in the summary")
+			}
+		})
+	}
+}
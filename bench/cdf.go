@@ -0,0 +1,45 @@
+package bench
+
+import (
+	"fmt"
+	"sort"
+)
+
+// dumpCDF appends the latency CDF of a bench run, merged across clients,
+// to the cdf file as CDFPoints (latency, cumulative_fraction) points taken
+// at evenly spaced ranks of the sorted latencies. Failed requests are left
+// out.
+func (self *Benchmark) dumpCDF(btype BenchType, run int) {
+	var latencies int64Slice
+	for _, client := range self.clients {
+		if client.Stat == nil {
+			continue
+		}
+		for _, latency := range client.Stat.Latencies {
+			if latency.Latency >= 0 {
+				latencies = append(latencies, latency.Latency.Nanoseconds())
+			}
+		}
+	}
+	if len(latencies) == 0 {
+		return
+	}
+	cf, err := self.openOutput("cdf.csv")
+	if err != nil {
+		panic(err)
+	}
+	defer cf.Close()
+	if info, err := cf.Stat(); err == nil && info.Size() == 0 {
+		cf.WriteString("bench_type,run,latency,cumulative_fraction\n")
+	}
+	sort.Sort(latencies)
+	n := len(latencies)
+	points := self.CDFPoints
+	if points > n {
+		points = n
+	}
+	for k := 1; k <= points; k++ {
+		rank := (k*n + points - 1) / points // ceil(k*n/points)
+		cf.WriteString(fmt.Sprintf("%s,%d,%d,%f\n", btype.String(), run, latencies[rank-1], float64(rank)/float64(n)))
+	}
+}
@@ -0,0 +1,135 @@
+package bench
+
+import (
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	churnKey = "churn"
+	// churnSampleInterval is how often the live node count is sampled
+	churnSampleInterval = 100 * time.Millisecond
+)
+
+// churnNode returns the key of the seq-th node a client created in churn.
+func churnNode(seq int64) string {
+	return fmt.Sprintf("%s/%d", churnKey, seq)
+}
+
+// runChurn keeps ChurnNodes live znodes per client while replacing them, like
+// a queue with a bounded backlog: every client first creates ChurnNodes
+// znodes, then for NRequests times creates a new one and deletes its oldest.
+// The latency of every create+delete pair goes to the churn file, and the
+// total live node count, sampled over time, to the churn nodes file.
+func (self *Benchmark) runChurn() {
+	ctx := self.context()
+	cf, err := self.openOutput("churn.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer cf.Close()
+	if info, err := cf.Stat(); err == nil && info.Size() == 0 {
+		cf.WriteString("client_id,live_nodes,pairs,errors,average_create_latency,average_delete_latency,average_latency,99th_latency\n")
+	}
+	nf, err := self.openOutput("churn_nodes.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer nf.Close()
+	if info, err := nf.Stat(); err == nil && info.Size() == 0 {
+		nf.WriteString("time,node_count\n")
+	}
+
+	val := randBytes(mrand.NewSource(time.Now().UnixNano()), self.ValueSizeBytes)
+	live := int64(self.ChurnNodes)
+	for _, client := range self.clients {
+		if _, err := client.CreateIfNotExist(churnKey, nil); err != nil {
+			client.Log("error in creating churn znode: %v", err)
+			return
+		}
+		for seq := int64(0); seq < live; seq++ {
+			if err := client.Create(ctx, churnNode(seq), val); err != nil {
+				client.Log("error in creating churn node %d: %v", seq, err)
+			}
+		}
+	}
+
+	// sample the node count until all clients are done
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(churnSampleInterval)
+		defer ticker.Stop()
+		for {
+			count := int32(0)
+			for _, client := range self.clients {
+				conn := client.currentConn()
+				if conn == nil {
+					continue
+				}
+				if _, stat, err := conn.Exists(client.FullPath(churnKey)); err == nil && stat != nil {
+					count += stat.NumChildren
+				}
+			}
+			nf.WriteString(fmt.Sprintf("%s,%d\n", time.Now().UTC().Format("2006-01-02T15:04:05.999Z"), count))
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	log.Printf("[Bench]: start churn of %d live nodes per client\n", live)
+	for _, client := range self.clients {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			var pairs, creates, deletes BenchStat
+			for seq := int64(0); seq < self.NRequests; seq++ {
+				begin := time.Now()
+				err := client.Create(ctx, churnNode(seq+live), val)
+				created := time.Now()
+				creates.add(client.ServerAddr(), begin, created.Sub(begin), err)
+				if err == nil {
+					err = client.Delete(ctx, churnNode(seq))
+					deletes.add(client.ServerAddr(), created, time.Since(created), err)
+				}
+				if err != nil {
+					client.Log("error in churn of node %d: %v", seq, err)
+				}
+				pairs.add(client.ServerAddr(), begin, time.Since(begin), err)
+			}
+			pairs.finish()
+			creates.finish()
+			deletes.finish()
+			cf.WriteString(fmt.Sprintf("%d,%d,%d,%d,%d,%d,%d,%d\n", client.Id, live, pairs.Ops, pairs.Errors,
+				creates.AvgLatency.Nanoseconds(), deletes.AvgLatency.Nanoseconds(), pairs.AvgLatency.Nanoseconds(),
+				pairs.NinetyNinethLatency))
+			client.Log("done churn: avg create+delete latency %s", pairs.AvgLatency)
+		}(client)
+	}
+	wg.Wait()
+	close(done)
+	<-sampled
+
+	for _, client := range self.clients {
+		conn := client.currentConn()
+		if conn == nil {
+			continue
+		}
+		children, _, err := conn.Children(client.FullPath(churnKey))
+		if err != nil {
+			continue
+		}
+		for _, child := range children {
+			conn.Delete(client.FullPath(churnKey+"/"+child), -1)
+		}
+		conn.Delete(client.FullPath(churnKey), -1)
+	}
+}
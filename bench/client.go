@@ -1,10 +1,14 @@
 package bench
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"path"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/samuel/go-zookeeper/zk"
@@ -15,8 +19,9 @@ type Client struct {
 	Name      string
 	Server    string
 	Namespace string
-	EndPoint  string
-	Conn      *zk.Conn
+	EndPoint  string   // the primary server the client prefers
+	EndPoints []string // the ensemble in the order the client tries it, starting with EndPoint
+	Conn      ZKConn
 	connMu    sync.RWMutex
 	// CleanupNamespace controls whether Cleanup() removes the namespace subtree.
 	// Keep this enabled for regular clients. It can be disabled for clients that
@@ -25,93 +30,278 @@ type Client struct {
 
 	Stat     *BenchStat // the stats for requests issued by this client
 	Children []*Client  // a client may have multiple child clients to launch concurrent requests
+	// KeepChildren makes CloseChildren keep the child sessions open for
+	// the next AddChildren instead of closing them
+	KeepChildren bool
+	idle         []*Client // child sessions kept by CloseChildren
+
+	// payload bytes of paths and data sent and received by the benchmark
+	// operations of this client, excluding protocol overhead
+	bytesSent     int64
+	bytesReceived int64
+	readBytes     int64 // of bytesReceived, the data returned by Read
+
+	Backoff  Backoff // delays Reconnect after consecutive failures
+	failures int32   // consecutive reconnects without a successful request
+
+	// sessions of this client that were disconnected or expired, counted
+	// by watchSession
+	disconnects int64
+	expirations int64
+
+	Delay time.Duration // artificial network delay injected before each request
+
+	// ConnectStart is when the client started to connect and
+	// ConnectLatency how long it took until it had a session
+	ConnectStart   time.Time
+	ConnectLatency time.Duration
+
+	// OpTimeout is the deadline of every request, after which it fails
+	// with ErrOpTimeout; 0 means none
+	OpTimeout time.Duration
+
+	// CreateFlags are the zk flags of the znodes Create makes
+	CreateFlags int32
+	names       *createdNames // the paths sequential creates got, shared with the children
+}
+
+// CREATE_MODES lists the kinds of znodes the benchmark can create.
+var CREATE_MODES = []string{"persistent", "ephemeral", "sequential", "ephemeral_sequential"}
+
+// createFlags returns the zk flags of a create mode of CREATE_MODES.
+func createFlags(mode string) (int32, error) {
+	switch mode {
+	case "persistent":
+		return 0, nil
+	case "ephemeral":
+		return zk.FlagEphemeral, nil
+	case "sequential":
+		return zk.FlagSequence, nil
+	case "ephemeral_sequential":
+		return zk.FlagEphemeral | zk.FlagSequence, nil
+	}
+	return 0, fmt.Errorf("Unknown create mode '%s', must be one of %s\n", mode, strings.Join(CREATE_MODES, "|"))
+}
+
+// createdNames maps the path a sequential create asked for to the path the
+// server assigned, so the later requests for the key find the znode.
+type createdNames struct {
+	mu    sync.RWMutex
+	paths map[string]string
+}
+
+func (self *createdNames) set(requested, created string) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if created == "" {
+		delete(self.paths, requested)
+	} else {
+		self.paths[requested] = created
+	}
+}
+
+func (self *createdNames) get(requested string) (string, bool) {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	created, ok := self.paths[requested]
+	return created, ok
 }
 
+// ErrOpTimeout is the error of a request that exceeded the OpTimeout of
+// its client.
+var ErrOpTimeout = errors.New("operation timed out")
+
 var (
 	zkCreateFlags = int32(0)
 	zkCreateACL   = zk.WorldACL(zk.PermAll)
 )
 
+// ZKVerbose makes new connections forward go-zookeeper's internal logs to
+// the standard logger instead of silencing them. Useful when debugging
+// connection issues.
+var ZKVerbose = false
+
 type ConnLogger int32
 
 func (l *ConnLogger) Printf(string, ...interface{}) {
 	// do not print for now
 }
 
+// VerboseConnLogger forwards go-zookeeper's logs to the standard logger.
+type VerboseConnLogger int32
+
+func (l *VerboseConnLogger) Printf(format string, args ...interface{}) {
+	log.Printf("[zk debug]: "+format+"\n", args...)
+}
+
+func newConnLogger() zk.Logger {
+	if ZKVerbose {
+		var l VerboseConnLogger
+		return &l
+	}
+	var l ConnLogger
+	return &l
+}
+
 func (self *Client) Log(spec string, args ...interface{}) {
 	prefix := fmt.Sprintf("[Client %s->%s]: %s\n", self.Name, self.EndPoint, spec)
 	log.Printf(prefix, args...)
 }
 
-func (self *Client) currentConn() *zk.Conn {
+func (self *Client) currentConn() ZKConn {
 	self.connMu.RLock()
 	conn := self.Conn
 	self.connMu.RUnlock()
 	return conn
 }
 
-func (self *Client) Read(rpath string) ([]byte, *zk.Stat, error) {
+// call runs the operation op unless ctx is done first. The underlying zk
+// calls cannot be interrupted, so on expiry op is abandoned to finish in
+// the background and ctx.Err() is returned. op must only publish its
+// results once it has returned nil.
+func call(ctx context.Context, op func() error) error {
+	if ctx.Done() == nil {
+		return op() // never canceled, save the goroutine
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- op()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// call runs op like call, additionally bounded by the OpTimeout of the
+// client. Since an op that exceeds it is abandoned, it only ever fails the
+// request and cannot later count as a success.
+func (self *Client) call(ctx context.Context, op func() error) error {
+	if self.OpTimeout <= 0 {
+		return call(ctx, op)
+	}
+	opctx, cancel := context.WithTimeout(ctx, self.OpTimeout)
+	defer cancel()
+	err := call(opctx, op)
+	if err == context.DeadlineExceeded && ctx.Err() == nil {
+		return ErrOpTimeout
+	}
+	return err
+}
+
+func (self *Client) Read(ctx context.Context, rpath string) ([]byte, *zk.Stat, error) {
 	conn := self.currentConn()
 	if conn == nil {
 		return nil, nil, zk.ErrNoServer
 	}
-	if len(rpath) == 0 {
-		return conn.Get(self.Namespace)
+	rpath = self.target(rpath)
+	var data []byte
+	var stat *zk.Stat
+	err := self.call(ctx, func() error {
+		d, s, err := conn.Get(rpath)
+		atomic.AddInt64(&self.bytesSent, int64(len(rpath)))
+		atomic.AddInt64(&self.bytesReceived, int64(len(d)))
+		atomic.AddInt64(&self.readBytes, int64(len(d)))
+		data, stat = d, s
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
 	}
-	return conn.Get(self.Namespace + "/" + rpath)
+	return data, stat, nil
 }
 
 // GetW reads a znode and sets a watch for data changes. Used to induce watch storms
 // when many clients watch the same path and writers update it.
-func (self *Client) GetW(rpath string) ([]byte, *zk.Stat, <-chan zk.Event, error) {
+func (self *Client) GetW(ctx context.Context, rpath string) ([]byte, *zk.Stat, <-chan zk.Event, error) {
 	conn := self.currentConn()
 	if conn == nil {
 		return nil, nil, nil, zk.ErrNoServer
 	}
-	p := self.Namespace
-	if len(rpath) > 0 {
-		p = self.Namespace + "/" + rpath
+	var data []byte
+	var stat *zk.Stat
+	var ch <-chan zk.Event
+	err := self.call(ctx, func() error {
+		d, s, c, err := conn.GetW(self.target(rpath))
+		data, stat, ch = d, s, c
+		return err
+	})
+	if err != nil {
+		return nil, nil, nil, err
 	}
-	return conn.GetW(p)
+	return data, stat, ch, nil
 }
 
-func (self *Client) Write(rpath string, data []byte) error {
+// ExistsW checks whether a znode exists and sets a watch that fires when it
+// is created, or, if it exists, changed or deleted.
+func (self *Client) ExistsW(ctx context.Context, rpath string) (bool, *zk.Stat, <-chan zk.Event, error) {
 	conn := self.currentConn()
 	if conn == nil {
-		return zk.ErrNoServer
-	}
-	var err error
-	if len(rpath) == 0 {
-		_, err = conn.Set(self.Namespace, data, -1)
-	} else {
-		_, err = conn.Set(self.Namespace+"/"+rpath, data, -1)
+		return false, nil, nil, zk.ErrNoServer
+	}
+	var exists bool
+	var stat *zk.Stat
+	var ch <-chan zk.Event
+	err := self.call(ctx, func() error {
+		e, s, c, err := conn.ExistsW(self.target(rpath))
+		exists, stat, ch = e, s, c
+		return err
+	})
+	if err != nil {
+		return false, nil, nil, err
 	}
-	return err
+	return exists, stat, ch, nil
 }
 
-func (self *Client) ReadWrite(rpath string, data []byte) error {
+func (self *Client) Write(ctx context.Context, rpath string, data []byte) error {
 	conn := self.currentConn()
 	if conn == nil {
 		return zk.ErrNoServer
 	}
-	if len(rpath) == 0 {
-		rpath = self.Namespace
-	} else {
-		rpath = self.Namespace + "/" + rpath
-	}
-	_, stat, err := conn.Get(rpath)
-	if err != nil {
+	rpath = self.target(rpath)
+	return self.call(ctx, func() error {
+		_, err := conn.Set(rpath, data, -1)
+		atomic.AddInt64(&self.bytesSent, int64(len(rpath)+len(data)))
 		return err
+	})
+}
+
+func (self *Client) ReadWrite(ctx context.Context, rpath string, data []byte) error {
+	conn := self.currentConn()
+	if conn == nil {
+		return zk.ErrNoServer
 	}
-	_, err = conn.Set(rpath, data, stat.Version)
-	return err
+	rpath = self.target(rpath)
+	return self.call(ctx, func() error {
+		_, stat, err := conn.Get(rpath)
+		if err != nil {
+			return err
+		}
+		_, err = conn.Set(rpath, data, stat.Version)
+		return err
+	})
 }
 
-func (self *Client) Delete(rpath string) error {
-	if len(rpath) == 0 {
-		return self.Conn.Delete(self.Namespace, 0)
+func (self *Client) Delete(ctx context.Context, rpath string) error {
+	conn := self.currentConn()
+	if conn == nil {
+		return zk.ErrNoServer
 	}
-	return self.Conn.Delete(self.Namespace+"/"+rpath, 0)
+	requested := self.FullPath(rpath)
+	rpath = self.target(rpath)
+	return self.call(ctx, func() error {
+		atomic.AddInt64(&self.bytesSent, int64(len(rpath)))
+		err := conn.Delete(rpath, 0)
+		if err == nil && rpath != requested {
+			self.names.set(requested, "")
+		}
+		return err
+	})
 }
 
 func (self *Client) DeleteR(rpath string) error {
@@ -120,30 +310,43 @@ func (self *Client) DeleteR(rpath string) error {
 	} else {
 		rpath = self.Namespace + "/" + rpath
 	}
-	children, _, err := self.Conn.Children(rpath)
+	conn := self.currentConn()
+	if conn == nil {
+		return zk.ErrNoServer
+	}
+	children, _, err := conn.Children(rpath)
 	if err != nil {
 		return err
 	}
 	for _, child := range children {
 		fpath := self.Namespace + "/" + child
 		// log.Printf("Delete %s\n", fpath)
-		err := self.Conn.Delete(fpath, -1)
+		err := conn.Delete(fpath, -1)
 		if err != nil {
 			return err
 		}
 	}
 	// log.Printf("Delete %s\n", rpath)
-	return self.Conn.Delete(rpath, -1)
+	return conn.Delete(rpath, -1)
 }
 
-func (self *Client) Create(rpath string, data []byte) error {
-	if len(rpath) == 0 {
-		rpath = self.Namespace
-	} else {
-		rpath = self.Namespace + "/" + rpath
+// Create creates a znode of the client's CreateFlags. Unlike CreateR, which
+// builds the namespace and so always creates persistent znodes.
+func (self *Client) Create(ctx context.Context, rpath string, data []byte) error {
+	conn := self.currentConn()
+	if conn == nil {
+		return zk.ErrNoServer
 	}
-	_, err := self.Conn.Create(rpath, data, zkCreateFlags, zkCreateACL)
-	return err
+	rpath = self.FullPath(rpath)
+	return self.call(ctx, func() error {
+		created, err := conn.Create(rpath, data, self.CreateFlags, zkCreateACL)
+		atomic.AddInt64(&self.bytesSent, int64(len(rpath)+len(data)))
+		atomic.AddInt64(&self.bytesReceived, int64(len(created)))
+		if err == nil && self.CreateFlags&zk.FlagSequence != 0 {
+			self.names.set(rpath, created)
+		}
+		return err
+	})
 }
 
 func (self *Client) CreateR(rpath string, data []byte) error {
@@ -152,6 +355,10 @@ func (self *Client) CreateR(rpath string, data []byte) error {
 	} else {
 		rpath = self.Namespace + "/" + rpath
 	}
+	conn := self.currentConn()
+	if conn == nil {
+		return zk.ErrNoServer
+	}
 	var subps []string
 	if len(rpath) > 0 && rpath != "/" {
 		subps = append(subps, rpath)
@@ -165,12 +372,12 @@ func (self *Client) CreateR(rpath string, data []byte) error {
 	for i := range subps {
 		subp := subps[l-i]
 		if i != l {
-			exists, _, err := self.Conn.Exists(subp)
+			exists, _, err := conn.Exists(subp)
 			if err == nil && !exists {
-				_, err = self.Conn.Create(subp, []byte(""), zkCreateFlags, zkCreateACL)
+				_, err = conn.Create(subp, []byte(""), zkCreateFlags, zkCreateACL)
 			}
 		} else {
-			_, err = self.Conn.Create(subp, data, zkCreateFlags, zkCreateACL)
+			_, err = conn.Create(subp, data, zkCreateFlags, zkCreateACL)
 		}
 		if err != nil {
 			return err
@@ -179,10 +386,25 @@ func (self *Client) CreateR(rpath string, data []byte) error {
 	return nil
 }
 
+// target resolves rpath to the znode a request for it goes to, which for
+// a key created sequentially is the path the server assigned.
+func (self *Client) target(rpath string) string {
+	p := self.FullPath(rpath)
+	if created, ok := self.names.get(p); ok {
+		return created
+	}
+	return p
+}
+
+// FullPath resolves rpath against the client namespace. An absolute rpath
+// (e.g. from a key list file) is used as is.
 func (self *Client) FullPath(rpath string) string {
 	if len(rpath) == 0 {
 		return self.Namespace
 	}
+	if rpath[0] == '/' {
+		return rpath
+	}
 	return self.Namespace + "/" + rpath
 }
 
@@ -192,19 +414,27 @@ func (self *Client) CreateIfNotExist(rpath string, data []byte) (bool, error) {
 	} else {
 		rpath = self.Namespace + "/" + rpath
 	}
-	exists, _, err := self.Conn.Exists(rpath)
+	conn := self.currentConn()
+	if conn == nil {
+		return false, zk.ErrNoServer
+	}
+	exists, _, err := conn.Exists(rpath)
 	if err != nil {
 		return false, err
 	}
 	if !exists {
-		_, err = self.Conn.Create(rpath, data, zkCreateFlags, zkCreateACL)
+		_, err = conn.Create(rpath, data, zkCreateFlags, zkCreateACL)
 		return false, err
 	}
 	return true, nil
 }
 
 func (self *Client) Setup() error {
-	exists, _, err := self.Conn.Exists(self.Namespace)
+	conn := self.currentConn()
+	if conn == nil {
+		return zk.ErrNoServer
+	}
+	exists, _, err := conn.Exists(self.Namespace)
 	if err != nil {
 		return err
 	}
@@ -215,46 +445,89 @@ func (self *Client) Setup() error {
 }
 
 func (self *Client) Cleanup() error {
-	self.connMu.Lock()
-	defer self.connMu.Unlock()
-	if self.Conn == nil {
+	self.closeIdle()
+	if self.currentConn() == nil {
 		return nil
 	}
 	var err error
 	if self.CleanupNamespace {
+		// DeleteR takes the connection itself, so not under connMu
 		err = self.DeleteR("")
 	}
-	self.Conn.Close()
-	self.Conn = nil
+	self.closeConn()
 	return err
 }
 
+// Reconnect replaces the connection, first waiting for the jittered backoff
+// if the previous reconnects have not been followed by a successful request.
 func (self *Client) Reconnect() error {
+	n := atomic.AddInt32(&self.failures, 1)
+	if delay := self.Backoff.Delay(n); delay > 0 {
+		self.Log("reconnect attempt %d after %s", n, delay)
+		time.Sleep(delay)
+	}
 	self.connMu.Lock()
 	defer self.connMu.Unlock()
 	if self.Conn != nil {
 		self.Conn.Close()
 	}
 	self.Conn = nil
-	conn, _, err := zk.Connect([]string{self.EndPoint}, time.Second)
+	// the old connection is closed either way, so a failed reconnect
+	// leaves the client without one rather than leaking it
+	conn, err := connect(self.EndPoints)
 	if err != nil {
 		return err
 	}
-	var l ConnLogger
-	conn.SetLogger(&l)
 	self.Conn = conn
+	self.watchSession(conn)
 	return nil
 }
 
+// ResetBackoff marks the connection healthy after a successful request.
+func (self *Client) ResetBackoff() {
+	if atomic.LoadInt32(&self.failures) != 0 {
+		atomic.StoreInt32(&self.failures, 0)
+	}
+}
+
+// ServerAddr returns the server the client is currently connected to. For
+// connections that do not report it, e.g. of other backends, it is the
+// configured endpoint.
+func (self *Client) ServerAddr() string {
+	if conn, ok := self.currentConn().(interface{ Server() string }); ok {
+		if server := conn.Server(); server != "" {
+			return server
+		}
+	}
+	return self.EndPoint
+}
+
+// BytesTransferred returns the payload bytes sent and received so far.
+func (self *Client) BytesTransferred() (int64, int64) {
+	return atomic.LoadInt64(&self.bytesSent), atomic.LoadInt64(&self.bytesReceived)
+}
+
 func (self *Client) AddChildren(n int) error {
 	if self.Children == nil {
 		self.Children = make([]*Client, 0, n)
 	}
+	for ; n > 0 && len(self.idle) > 0; n-- {
+		child := self.idle[len(self.idle)-1]
+		self.idle = self.idle[:len(self.idle)-1]
+		child.Stat = nil
+		self.Children = append(self.Children, child)
+	}
 	for i := 0; i < n; i++ {
-		child, err := NewClient(self.Id, self.Name, self.Server, self.EndPoint, self.Namespace)
+		child, err := NewClient(self.Id, self.Name, self.Server, self.EndPoints, self.Namespace)
 		if err != nil {
 			self.Log("failed to create child client: %s", err)
 		} else {
+			child.Backoff = self.Backoff
+			child.Delay = self.Delay
+			child.OpTimeout = self.OpTimeout
+			child.KeepChildren = self.KeepChildren
+			child.CreateFlags = self.CreateFlags
+			child.names = self.names
 			self.Children = append(self.Children, child)
 		}
 	}
@@ -266,44 +539,85 @@ func (self *Client) CloseChildren() {
 		// no child clients, great
 		return
 	}
+	if self.KeepChildren {
+		self.idle = append(self.idle, self.Children...)
+		self.Children = nil
+		return
+	}
 	for _, child := range self.Children {
-		child.Conn.Close()
-		child.Conn = nil
+		child.closeConn()
 	}
 	self.Children = nil
 }
 
+// closeIdle closes the child sessions kept by CloseChildren.
+func (self *Client) closeIdle() {
+	for _, child := range self.idle {
+		child.closeIdle()
+		child.closeConn()
+	}
+	self.idle = nil
+}
+
+// closeConn closes the connection of the client, if it still has one.
+func (self *Client) closeConn() {
+	self.connMu.Lock()
+	defer self.connMu.Unlock()
+	if self.Conn != nil {
+		self.Conn.Close()
+	}
+	self.Conn = nil
+}
+
 func (self *Client) GetChild(i int) *Client {
-	if self.Children == nil || i < 0 || i > len(self.Children) {
+	if self.Children == nil || i < 0 || i >= len(self.Children) {
 		return nil
 	}
 	return self.Children[i]
 }
 
-func NewClient(id int, name string, server string, endpoint string, namespace string) (*Client, error) {
-	conn, _, err := zk.Connect([]string{endpoint}, time.Second)
+// NewClient connects a client to the ensemble of endpoints. It prefers
+// endpoints[0], the endpoint of server, and fails over to the others.
+func NewClient(id int, name string, server string, endpoints []string, namespace string) (*Client, error) {
+	begin := time.Now()
+	conn, err := connect(endpoints)
+	latency := time.Since(begin)
 	if err != nil {
-		return nil, err
+		return nil, &ConnectError{Id: id, Server: server, Endpoint: endpoints[0], Start: begin, Latency: latency, Err: err}
 	}
-	var l ConnLogger
-	conn.SetLogger(&l)
-	return &Client{
+	client := &Client{
 		Id:               id,
 		Name:             name,
 		Server:           server,
 		Namespace:        namespace,
-		EndPoint:         endpoint,
+		EndPoint:         endpoints[0],
+		EndPoints:        endpoints,
 		Conn:             conn,
 		CleanupNamespace: true,
-	}, nil
+		names:            &createdNames{paths: make(map[string]string)},
+		ConnectStart:     begin,
+		ConnectLatency:   latency,
+	}
+	client.watchSession(conn)
+	return client, nil
+}
+
+// ensemble returns the endpoints in the order a client with the primary
+// endpoints[i] tries them: from i on, wrapping around.
+func ensemble(endpoints []string, i int) []string {
+	i %= len(endpoints)
+	return append(append([]string{}, endpoints[i:]...), endpoints[:i]...)
 }
 
 func NewClients(servers []string, endpoints []string, nclients int, namespace string) ([]*Client, error) {
+	if len(servers) != len(endpoints) {
+		return nil, fmt.Errorf("got %d servers but %d endpoints", len(servers), len(endpoints))
+	}
 	clients := make([]*Client, nclients)
 	for i := 0; i < nclients; i++ {
 		sid := fmt.Sprintf("%d", i+1)
 		ns := namespace + "/client" + sid
-		client, err := NewClient(i+1, sid, servers[i%len(servers)], endpoints[i%len(endpoints)], ns)
+		client, err := NewClient(i+1, sid, servers[i%len(servers)], ensemble(endpoints, i), ns)
 		if err != nil {
 			return nil, err
 		}
@@ -316,10 +630,13 @@ func NewClients(servers []string, endpoints []string, nclients int, namespace st
 // This is useful for hotspot-style workloads where all clients read/write the
 // same relative znode path.
 func NewClientsForSharedZnode(servers []string, endpoints []string, nclients int, namespace string) ([]*Client, error) {
+	if len(servers) != len(endpoints) {
+		return nil, fmt.Errorf("got %d servers but %d endpoints", len(servers), len(endpoints))
+	}
 	clients := make([]*Client, nclients)
 	for i := 0; i < nclients; i++ {
 		sid := fmt.Sprintf("%d", i+1)
-		client, err := NewClient(i+1, sid, servers[i%len(servers)], endpoints[i%len(endpoints)], namespace)
+		client, err := NewClient(i+1, sid, servers[i%len(servers)], ensemble(endpoints, i), namespace)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,104 @@
+package bench
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// clockBase is the monotonic reference of the mono_offset column of the raw
+// file. Unlike the wall-clock time column, offsets from it are unaffected
+// by clock adjustments during the run.
+var clockBase = time.Now()
+
+// clockJumpThreshold is how far wall-clock and monotonic elapsed time may
+// diverge over a bench run before the system clock is considered to have
+// jumped.
+const clockJumpThreshold = 50 * time.Millisecond
+
+var clockMutex sync.Mutex
+
+// clockEvent appends a clock event to the clock file.
+func (self *Benchmark) clockEvent(event string, value time.Duration) {
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+	cf, err := self.openOutput("clock.dat")
+	if err != nil {
+		log.Printf("[Bench]: fail to open clock file: %v\n", err)
+		return
+	}
+	defer cf.Close()
+	if info, err := cf.Stat(); err == nil && info.Size() == 0 {
+		cf.WriteString("time,event,value\n")
+	}
+	cf.WriteString(fmt.Sprintf("%s,%s,%d\n", time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00"), event, value.Nanoseconds()))
+}
+
+// checkClock warns if the system clock jumped between start and end, both
+// taken with time.Now and hence carrying monotonic readings. Latencies are
+// always measured on the monotonic clock, but the wall-clock timestamps of
+// the raw file are off by the jump.
+func (self *Benchmark) checkClock(client *Client, start, end time.Time) {
+	mono := end.Sub(start)
+	wall := end.Round(0).Sub(start.Round(0))
+	if drift := wall - mono; drift > clockJumpThreshold || drift < -clockJumpThreshold {
+		client.Log("warning: system clock jumped by %s, raw timestamps are skewed", drift)
+		self.clockEvent("clock_jump", drift)
+	}
+}
+
+// logClockBase records the wall-clock time of clockBase, so mono_offset
+// values can be mapped back to wall-clock time, and, if an NTP server is
+// configured, the offset of the local clock from it.
+func (self *Benchmark) logClockBase() {
+	self.clockEvent("mono_base", time.Duration(clockBase.Round(0).UnixNano()))
+	if self.NTPServer == "" {
+		return
+	}
+	offset, err := sntpOffset(self.NTPServer)
+	if err != nil {
+		log.Printf("[Bench]: fail to query NTP server %s: %v\n", self.NTPServer, err)
+		return
+	}
+	log.Printf("[Bench]: local clock is off by %s from NTP server %s\n", offset, self.NTPServer)
+	self.clockEvent("ntp_offset", offset)
+}
+
+// ntpEpoch is the offset of the NTP epoch (1900) from the Unix epoch.
+const ntpEpoch = 2208988800
+
+func ntpTime(b []byte) time.Time {
+	secs := int64(binary.BigEndian.Uint32(b[0:4])) - ntpEpoch
+	frac := int64(binary.BigEndian.Uint32(b[4:8]))
+	return time.Unix(secs, frac*int64(time.Second)>>32)
+}
+
+// sntpOffset returns how far the server's clock is ahead of the local one
+// using a single SNTP request.
+func sntpOffset(server string) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	conn, err := net.DialTimeout("udp", server, 5*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req := make([]byte, 48)
+	req[0] = 0x1B // LI 0, version 3, client mode
+	t1 := time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	res := make([]byte, 48)
+	if _, err := conn.Read(res); err != nil {
+		return 0, err
+	}
+	t4 := t1.Add(time.Since(t1))
+	t2, t3 := ntpTime(res[32:40]), ntpTime(res[40:48])
+	return (t2.Sub(t1) + t3.Sub(t4)) / 2, nil
+}
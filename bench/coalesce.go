@@ -0,0 +1,73 @@
+package bench
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// keyTracker tracks the requests in flight per key to detect when parallel
+// workers hit the same key at once, in which case the server or network may
+// coalesce them and distort the measured latency.
+type keyTracker struct {
+	mutex    sync.Mutex
+	inflight map[string]int
+	max      map[string]int
+}
+
+func newKeyTracker() *keyTracker {
+	return &keyTracker{inflight: make(map[string]int), max: make(map[string]int)}
+}
+
+func (self *keyTracker) begin(key string) {
+	self.mutex.Lock()
+	n := self.inflight[key] + 1
+	self.inflight[key] = n
+	if n > self.max[key] {
+		self.max[key] = n
+	}
+	self.mutex.Unlock()
+}
+
+func (self *keyTracker) end(key string) {
+	self.mutex.Lock()
+	if n := self.inflight[key] - 1; n > 0 {
+		self.inflight[key] = n
+	} else {
+		delete(self.inflight, key)
+	}
+	self.mutex.Unlock()
+}
+
+// dumpCoalescing appends the max concurrency of every key that had more
+// than one request in flight at once during a bench run to the coalescing
+// file. Keys never requested concurrently are left out.
+func (self *Benchmark) dumpCoalescing(btype BenchType, run int, tracker *keyTracker) {
+	cf, err := self.openOutput("coalescing.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer cf.Close()
+	if info, err := cf.Stat(); err == nil && info.Size() == 0 {
+		cf.WriteString("bench_type,run,key,max_concurrency\n")
+	}
+	keys := make([]string, 0, len(tracker.max))
+	for key := range tracker.max {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	overall, concurrent := 0, 0
+	for _, key := range keys {
+		n := tracker.max[key]
+		if n > overall {
+			overall = n
+		}
+		if n > 1 {
+			concurrent++
+			cf.WriteString(fmt.Sprintf("%s,%d,%s,%d\n", btype.String(), run, key, n))
+		}
+	}
+	log.Printf("[Bench]: %s.%d: %d of %d keys had concurrent requests, max concurrency %d\n",
+		btype.String(), run, concurrent, len(keys), overall)
+}
@@ -1,8 +1,13 @@
 package bench
 
 import (
+	"bufio"
 	"fmt"
+	"os"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	zkc "github.com/OrderLab/zkbench/config"
 )
@@ -23,6 +28,180 @@ type BenchConfig struct {
 	Runs           int
 	Parallelism    int
 	Cleanup        bool
+	PhasedMix      []MixPhase
+	// RegenerateValues makes every write send freshly generated random
+	// bytes instead of reusing a single value for the whole run
+	RegenerateValues bool
+	// RateSweep lists the offered write rates (req/s) of the fsync batching
+	// sweep, each held for RateSweepStep
+	RateSweep     []float64
+	RateSweepStep time.Duration
+	// KeyList holds explicit keys to operate on instead of synthesized ones.
+	// Keys are relative to the client namespace unless they start with '/'
+	KeyList []string
+	// Percentiles lists the latency percentiles (0-100] reported in the summary
+	Percentiles []float64
+	// Backend is the system under test, one of BACKENDS
+	Backend string
+	// CreateMode is the kind of znodes CREATE makes, one of CREATE_MODES
+	CreateMode string
+	// TrackCoalescing tracks concurrent requests per key and reports the
+	// max concurrency each key saw
+	TrackCoalescing bool
+	// ProtocolOverhead is the estimated bytes of request/response framing
+	// added to each operation's payload in the network bytes report
+	ProtocolOverhead int64
+	// Profiles are alternative server sets the workload is run against in
+	// sequence, replacing the top-level servers
+	Profiles []Profile
+	// ReconnectBackoff spreads out the reconnects of clients that lost
+	// their server
+	ReconnectBackoff Backoff
+	// ClientRate caps the requests per second each client issues, 0 means
+	// no limit
+	ClientRate float64
+	// ZxidSampleRate is the fraction of reads whose returned zxids and
+	// version are recorded, 0 means none
+	ZxidSampleRate float64
+	// ElectionRounds is the number of rounds of the create race in which
+	// all clients try to create the same znode, 0 means none
+	ElectionRounds int
+	// RawRotateBytes and RawRotateInterval split the raw output into
+	// numbered chunks of at most that size or time span, 0 means no limit
+	RawRotateBytes    int64
+	RawRotateInterval time.Duration
+	// RawCompress gzips the raw output into files ending in .gz
+	RawCompress bool
+	// RawKeys adds the key and value size of every request to the raw
+	// output
+	RawKeys bool
+	// RawWarmup keeps the warm-up requests in the raw output, marked in a
+	// warmup column
+	RawWarmup bool
+	// MeasureAfter and SkipFirstOps exclude the requests that start
+	// within that time of the start of a bench run, or are among the first
+	// of every stat, from the stats as warm-up
+	MeasureAfter time.Duration
+	SkipFirstOps int64
+	// AdaptiveWarmup warms up each client until the coefficient of
+	// variation of its last WarmupWindow latencies is at most WarmupCV,
+	// for at most WarmupMax requests, instead of for NRequests/10 requests
+	AdaptiveWarmup bool
+	WarmupWindow   int
+	WarmupCV       float64
+	WarmupMax      int64
+	// ReadPoolFraction splits the clients of MIXED runs into a pool that
+	// only reads and one that only writes, 0 means every client does both
+	ReadPoolFraction float64
+	// CompareContention runs the hot-node vs spread write comparison
+	CompareContention bool
+	// Scenario lists the phases of a scenario file, run in order after
+	// the bench types
+	Scenario []ScenarioPhase
+	// WatchCounts lists the numbers of watches set on a znode while
+	// WatchSamples writes to it are measured
+	WatchCounts  []int
+	WatchSamples int
+	// CDFPoints is the number of points of the per-run latency CDF, 0
+	// means no CDF output
+	CDFPoints int
+	// TimeSeries writes the requests of every bench run bucketed by second
+	TimeSeries bool
+	// BucketInterval splits the requests of every bench run into intervals
+	// since its start, 0 means no buckets output
+	BucketInterval time.Duration
+	// ProgressInterval is how often the progress of a bench run is
+	// logged, 0 means never
+	ProgressInterval time.Duration
+	// ClientDelays inject artificial network delay before the requests
+	// of groups of clients
+	ClientDelays []ClientDelay
+	// NTPServer is queried for the offset of the local clock at the start
+	// of the benchmark
+	NTPServer string
+	// MetricsAddr is the address of the live Prometheus metrics served
+	// during the benchmark, empty for none
+	MetricsAddr string
+	// MetricSink is the exporter of METRIC_SINKS every request is reported
+	// to, at StatsdAddr for statsd
+	MetricSink string
+	StatsdAddr string
+	// ResultsDB is the path of a SQLite database the summary rows of
+	// every run are also stored in, empty for none
+	ResultsDB string
+	// LatencyMode is one of LATENCY_MODES, tdigest to estimate the
+	// percentiles from mergeable sketches
+	LatencyMode string
+	// MaxInflight caps the outstanding requests of open-loop dispatch, 0
+	// means no cap; at the cap InflightPolicy either drops or blocks
+	MaxInflight    int
+	InflightPolicy string
+	// ModelCheck compares the achieved throughput of every run with the
+	// one Little's law predicts from the measured latency
+	ModelCheck bool
+	// ClientSweep is READ or WRITE to run that workload with a growing
+	// number of clients, 0 means no sweep
+	ClientSweep BenchType
+	// ACLDepths lists the depths below the namespace at which reads and
+	// writes of ACL-protected znodes are measured
+	ACLDepths []int
+	// LatencyRouting compares round-robin with latency-aware routing of
+	// reads across the servers, re-probing them every RoutingProbe reads
+	LatencyRouting bool
+	RoutingProbe   int
+	// AppearSamples is the number of exists-watch-then-read samples, 0
+	// means no appearance benchmark
+	AppearSamples int
+	// ConnectTimeout bounds establishing a connection to a server
+	ConnectTimeout time.Duration
+	// SessionTimeout is the ZooKeeper session timeout of the clients
+	SessionTimeout time.Duration
+	// OpTimeout is the deadline of every request of the clients, after
+	// which it fails as a timeout; 0 means none
+	OpTimeout time.Duration
+	// WarmupChildren establishes the child sessions of MIXED during the
+	// warm-up and keeps them open across runs
+	WarmupChildren bool
+	// DepthFrom and DepthTo bound the znode depths at which read and
+	// write latency is measured, 0 means no depth benchmark
+	DepthFrom int
+	DepthTo   int
+	// ChurnNodes is the number of live znodes per client that the churn
+	// benchmark keeps while replacing them, 0 means no churn
+	ChurnNodes int
+	// MaxErrorRate is the fraction of failed requests up to which the
+	// exit status reports a pass
+	MaxErrorRate float64
+	// AssertP99, AssertMaxErrorRate and AssertMinThroughput are the SLA
+	// every measured bench run must meet, 0 or for the error rate a
+	// negative value means no assertion
+	AssertP99           time.Duration
+	AssertMaxErrorRate  float64
+	AssertMinThroughput float64
+	// SmokeTimeout bounds the smoke test of every client; with
+	// RequireSmokePass a failed smoke test aborts the benchmark
+	SmokeTimeout     time.Duration
+	RequireSmokePass bool
+	// ThinkTime is the mean pause between the requests of a closed loop,
+	// drawn from the ThinkDist distribution
+	ThinkTime time.Duration
+	ThinkDist string
+	// EphemeralCounts lists the numbers of ephemeral znodes per session
+	// whose cleanup on session close is measured
+	EphemeralCounts []int
+	// RYWSamples is the number of write-then-read samples per client
+	// checking read-your-writes, 0 to skip
+	RYWSamples int
+	// PhaseDelay is the least pause before every bench type after the
+	// warmup, and PhaseDrain extends it until the servers have no
+	// outstanding requests
+	PhaseDelay time.Duration
+	PhaseDrain bool
+	// TTLSamples is the number of TTL nodes to create, 0 to skip. They live
+	// for TTL, and TTLVerify waits for the server to expire them
+	TTLSamples int
+	TTL        time.Duration
+	TTLVerify  bool
 }
 
 var (
@@ -35,6 +214,22 @@ var (
 	}
 )
 
+// ParseType parses a bench type string such as "cru" into the Type bitmask.
+func ParseType(btypestr string) (uint32, error) {
+	if len(btypestr) > 4 {
+		return 0, fmt.Errorf("Bench type should be at most 4-char\n")
+	}
+	var btype uint32 = 0
+	for _, c := range btypestr {
+		t, ok := BENCHTYPEMAP[c]
+		if !ok {
+			return 0, fmt.Errorf("Unrecognized bench type\n")
+		}
+		btype = btype | uint32(t)
+	}
+	return btype, nil
+}
+
 func TypeStr(btype uint32) string {
 	var types [4]byte
 	i := 0
@@ -62,101 +257,761 @@ func ParseConfig(path string) (*BenchConfig, error) {
 		return nil, fmt.Errorf("Fail to parse config: %v\n", err)
 	}
 	namespace, err := config.GetString("namespace")
+	if err != nil {
+		namespace = "zkbench" // by default benchmark under /zkbench
+	}
+	nclients := 1 // by default a single client
+	if config.Has("clients") {
+		nclients, err = checkPosInt(config, "clients")
+		if err != nil {
+			return nil, err
+		}
+	}
+	var nrequests int64 = 1000 // by default 1000 requests per client
+	if config.Has("requests") {
+		nrequests, err = checkPosInt64(config, "requests")
+		if err != nil {
+			return nil, err
+		}
+	}
+	var rdpercent float32 = -1 // full requests
+	if config.Has("read_percent") {
+		rdpercent, err = checkPosFloat32(config, "read_percent")
+		if err != nil {
+			return nil, err
+		}
+	}
+	var wrpercent float32 = -1 // full requests
+	if config.Has("write_percent") {
+		wrpercent, err = checkPosFloat32(config, "write_percent")
+		if err != nil {
+			return nil, err
+		}
+	}
+	parallelism := 1 // by default each client send requests synchronously
+	if config.Has("parallelism") {
+		parallelism, err = checkPosInt(config, "parallelism")
+		if err != nil {
+			return nil, err
+		}
+	}
+	runs := 1 // by default single run
+	if config.Has("runs") {
+		runs, err = checkPosInt(config, "runs")
+		if err != nil {
+			return nil, err
+		}
+	}
+	var key_size_bytes int64 = 16 // by default 16-byte keys
+	if config.Has("key_size_bytes") {
+		key_size_bytes, err = checkPosInt64(config, "key_size_bytes")
+		if err != nil {
+			return nil, err
+		}
+	}
+	var value_size_bytes int64 = 64 // by default 64-byte values
+	if config.Has("value_size_bytes") {
+		value_size_bytes, err = checkPosInt64(config, "value_size_bytes")
+		if err != nil {
+			return nil, err
+		}
+	}
+	cleanup, err := getBool(config, "cleanup", true) // by default cleanup after benchmark
 	if err != nil {
 		return nil, err
 	}
-	nclients, err := checkPosInt(config, "clients")
+	random, err := getBool(config, "random_access", false) // by default sequential access
 	if err != nil {
 		return nil, err
 	}
-	nrequests, err := checkPosInt64(config, "requests")
+	samekey, err := getBool(config, "same_key", false) // by default different key
 	if err != nil {
 		return nil, err
 	}
-	rdpercent, err := checkPosFloat32(config, "read_percent")
+	servers := config.GetKeys("server")
+	btypestr, err := config.GetString("type")
 	if err != nil {
-		rdpercent = -1 // full requests
+		if !config.Has("scenario") {
+			return nil, err
+		}
+		btypestr = "" // the scenario describes the workload
 	}
-	fmt.Printf("read percent %f\n", rdpercent)
-	wrpercent, err := checkPosFloat32(config, "write_percent")
+	btype, err := ParseType(btypestr)
 	if err != nil {
-		wrpercent = -1 // full requests
+		return nil, err
 	}
-	fmt.Printf("write percent %f\n", wrpercent)
-	parallelism, err := checkPosInt(config, "parallelism")
+
+	regenerate, err := getBool(config, "regenerate_values", false) // by default reuse one value per run
 	if err != nil {
-		parallelism = 1 // by default each client send requests synchronously
+		return nil, err
+	}
+	var ratesweep []float64
+	if spec, err := config.GetString("rate_sweep"); err == nil {
+		ratesweep, err = parseRates(spec)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ratesweepstep := 10 * time.Second // by default hold each rate for 10s
+	if spec, err := config.GetString("rate_sweep_step"); err == nil {
+		ratesweepstep, err = time.ParseDuration(spec)
+		if err != nil || ratesweepstep <= 0 {
+			return nil, fmt.Errorf("parameter 'rate_sweep_step' must be a positive duration\n")
+		}
+	}
+	var keylist []string
+	if path, err := config.GetString("key_list_file"); err == nil {
+		keylist, err = loadKeyList(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	percentiles := []float64{50, 90, 95, 99, 99.9} // by default report p50/p90/p95/p99/p99.9
+	if spec, err := config.GetString("percentiles"); err == nil {
+		percentiles, err = parsePercentiles(spec)
+		if err != nil {
+			return nil, err
+		}
 	}
-	runs, err := checkPosInt(config, "runs")
+	backend, err := config.GetString("backend")
 	if err != nil {
-		runs = 1 // by default single run
+		backend = "zookeeper" // by default benchmark ZooKeeper
 	}
-	key_size_bytes, err := checkPosInt64(config, "key_size_bytes")
+	if err := checkBackend(backend); err != nil {
+		return nil, err
+	}
+	createmode, err := config.GetString("create_mode")
 	if err != nil {
+		createmode = "persistent" // by default CREATE makes persistent znodes
+	}
+	if _, err := createFlags(createmode); err != nil {
 		return nil, err
 	}
-	value_size_bytes, err := checkPosInt64(config, "value_size_bytes")
+	coalescing, err := getBool(config, "track_coalescing", false) // by default do not track requests per key
 	if err != nil {
 		return nil, err
 	}
-	cleanup, err := config.GetBool("cleanup")
+	var overhead int64 = 32 // by default roughly the ZooKeeper request and reply headers
+	if config.Has("protocol_overhead_bytes") {
+		overhead, err = config.GetInt64("protocol_overhead_bytes")
+		if err != nil || overhead < 0 {
+			return nil, fmt.Errorf("Parameter 'protocol_overhead_bytes' must be a non-negative integer\n")
+		}
+	}
+	backoff := Backoff{Base: 100 * time.Millisecond, Max: 10 * time.Second} // by default back off from 100ms up to 10s
+	if spec, err := config.GetString("reconnect_backoff_base"); err == nil {
+		backoff.Base, err = time.ParseDuration(spec)
+		if err != nil || backoff.Base < 0 {
+			return nil, fmt.Errorf("parameter 'reconnect_backoff_base' must be a non-negative duration\n")
+		}
+	}
+	if spec, err := config.GetString("reconnect_backoff_max"); err == nil {
+		backoff.Max, err = time.ParseDuration(spec)
+		if err != nil || backoff.Max < backoff.Base {
+			return nil, fmt.Errorf("parameter 'reconnect_backoff_max' must be a duration no less than 'reconnect_backoff_base'\n")
+		}
+	}
+	var clientrate float64 // by default clients issue requests as fast as they can
+	if config.Has("client_rate") {
+		rate, err := config.GetFloat64("client_rate")
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("parameter 'client_rate' must be positive\n")
+		}
+		clientrate = rate
+	} else if config.Has("target_rate") {
+		rate, err := config.GetFloat64("target_rate")
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("parameter 'target_rate' must be positive\n")
+		}
+		clientrate = rate / float64(nclients)
+	}
+	zxidrate := 0.0 // by default do not record zxids
+	if config.Has("zxid_sample_rate") {
+		zxidrate, err = config.GetFloat64("zxid_sample_rate")
+		if err != nil || zxidrate < 0 || zxidrate > 1 {
+			return nil, fmt.Errorf("parameter 'zxid_sample_rate' must be within [0, 1]\n")
+		}
+	}
+	elections := 0 // by default no election rounds
+	if config.Has("election_rounds") {
+		elections, err = checkPosInt(config, "election_rounds")
+		if err != nil {
+			return nil, err
+		}
+	}
+	var rotatebytes int64 // by default keep the raw output in one file
+	if config.Has("raw_rotate_size_mb") {
+		mb, err := checkPosInt64(config, "raw_rotate_size_mb")
+		if err != nil {
+			return nil, err
+		}
+		rotatebytes = mb << 20
+	}
+	var rotateinterval time.Duration
+	if spec, err := config.GetString("raw_rotate_interval"); err == nil {
+		rotateinterval, err = time.ParseDuration(spec)
+		if err != nil || rotateinterval <= 0 {
+			return nil, fmt.Errorf("parameter 'raw_rotate_interval' must be a positive duration\n")
+		}
+	}
+	rawcompress, err := getBool(config, "raw_compress", false) // by default write the raw output uncompressed
 	if err != nil {
-		cleanup = true // by default cleanup after benchmark
+		return nil, err
 	}
-	random, err := config.GetBool("random_access")
+	rawkeys, err := getBool(config, "raw_keys", false) // by default leave the keys, which may be sensitive, out of the raw output
 	if err != nil {
-		random = false // by default sequential access
+		return nil, err
 	}
-	samekey, err := config.GetBool("same_key")
+	rawwarmup, err := getBool(config, "raw_warmup", false) // by default leave the excluded warm-up out of the raw output
 	if err != nil {
-		samekey = false // by default different key
+		return nil, err
 	}
-	servers := config.GetKeys("server")
+	var measureafter time.Duration // by default measure from the first request
+	if spec, err := config.GetString("measure_after"); err == nil {
+		measureafter, err = time.ParseDuration(spec)
+		if err != nil || measureafter <= 0 {
+			return nil, fmt.Errorf("Parameter 'measure_after' must be a positive duration\n")
+		}
+	}
+	var skipfirstops int64 // by default measure every request
+	if config.Has("skip_first_ops") {
+		skipfirstops, err = checkPosInt64(config, "skip_first_ops")
+		if err != nil {
+			return nil, err
+		}
+	}
+	adaptive, err := getBool(config, "warmup_adaptive", false) // by default warm up with a fixed number of requests
 	if err != nil {
 		return nil, err
 	}
-	btypestr, err := config.GetString("type")
+	warmupwindow := 100 // by default judge the latency of the last 100 requests
+	if config.Has("warmup_window") {
+		warmupwindow, err = checkPosInt(config, "warmup_window")
+		if err != nil {
+			return nil, err
+		}
+	}
+	warmupcv := 0.2 // by default stddev within 20% of the mean
+	if config.Has("warmup_cv") {
+		warmupcv, err = config.GetFloat64("warmup_cv")
+		if err != nil || warmupcv <= 0 {
+			return nil, fmt.Errorf("parameter 'warmup_cv' must be positive\n")
+		}
+	}
+	warmupmax := nrequests // by default at most as many requests as a run
+	if config.Has("warmup_max") {
+		warmupmax, err = checkPosInt64(config, "warmup_max")
+		if err != nil {
+			return nil, err
+		}
+	}
+	readpool := 0.0 // by default every client both reads and writes in MIXED
+	if config.Has("read_pool_fraction") {
+		readpool, err = config.GetFloat64("read_pool_fraction")
+		if err != nil || readpool <= 0 || readpool >= 1 {
+			return nil, fmt.Errorf("parameter 'read_pool_fraction' must be within (0, 1)\n")
+		}
+	}
+	contention, err := getBool(config, "compare_contention", false) // by default do not compare hot-node writes
 	if err != nil {
 		return nil, err
 	}
-	if len(btypestr) > 4 {
-		return nil, fmt.Errorf("Bench type should be at most 4-char\n")
+	var scenario []ScenarioPhase
+	if path, err := config.GetString("scenario"); err == nil {
+		scenario, err = loadScenario(path)
+		if err != nil {
+			return nil, err
+		}
 	}
-	var btype uint32 = 0
-	for _, c := range btypestr {
-		t, ok := BENCHTYPEMAP[c]
-		if !ok {
-			return nil, fmt.Errorf("Unrecognized bench type\n")
+	if len(keylist) == 0 {
+		// sequential keys are zero-padded to key_size_bytes and must not
+		// outgrow it, or keys come out longer than configured
+		maxkeys := int64(0)
+		if !samekey {
+			maxkeys = nrequests
+		}
+		for _, phase := range scenario {
+			if phase.Keys > maxkeys {
+				maxkeys = phase.Keys
+			} else if phase.Keys == 0 && nrequests > maxkeys {
+				maxkeys = nrequests
+			}
+		}
+		if digits := int64(len(strconv.FormatInt(maxkeys-1, 10))); maxkeys > 0 && digits > key_size_bytes {
+			return nil, fmt.Errorf("Parameter 'key_size_bytes' is %d but %d distinct keys need %d bytes\n",
+				key_size_bytes, maxkeys, digits)
+		}
+	}
+	var watchcounts []int
+	if spec, err := config.GetString("watch_counts"); err == nil {
+		watchcounts, err = parseCounts(spec)
+		if err != nil {
+			return nil, err
+		}
+	}
+	watchsamples := 100 // by default measure 100 writes per watch count
+	if config.Has("watch_samples") {
+		watchsamples, err = checkPosInt(config, "watch_samples")
+		if err != nil {
+			return nil, err
+		}
+	}
+	cdfpoints := 0 // by default no CDF output
+	if config.Has("cdf_points") {
+		cdfpoints, err = checkPosInt(config, "cdf_points")
+		if err != nil {
+			return nil, err
+		}
+	}
+	timeseries, err := getBool(config, "timeseries", false) // by default no time series output
+	if err != nil {
+		return nil, err
+	}
+	var bucketinterval time.Duration // by default no buckets output
+	if spec, err := config.GetString("bucket_interval"); err == nil {
+		bucketinterval, err = time.ParseDuration(spec)
+		if err != nil || bucketinterval <= 0 {
+			return nil, fmt.Errorf("parameter 'bucket_interval' must be a positive duration\n")
+		}
+	}
+	progressinterval := 10 * time.Second // by default log the progress every 10s
+	if spec, err := config.GetString("progress_interval"); err == nil {
+		progressinterval, err = time.ParseDuration(spec)
+		if err != nil || progressinterval < 0 {
+			return nil, fmt.Errorf("Parameter 'progress_interval' must be a non-negative duration\n")
+		}
+	}
+	var delays []ClientDelay // by default no injected delay
+	if spec, err := config.GetString("client_delay"); err == nil {
+		delays, err = parseClientDelays(spec)
+		if err != nil {
+			return nil, err
+		}
+	}
+	metricsaddr, err := config.GetString("metrics_addr")
+	if err != nil {
+		metricsaddr = "" // by default no live metrics
+	}
+	metricsink, err := config.GetString("metric_sink")
+	if err != nil {
+		metricsink = "none" // by default report the requests only in the files
+	}
+	statsdaddr, err := config.GetString("statsd_addr")
+	if err != nil {
+		statsdaddr = "127.0.0.1:8125" // by default the local statsd agent
+	}
+	if err := checkMetricSink(metricsink); err != nil {
+		return nil, err
+	}
+	resultsdb, err := config.GetString("results_db")
+	if err != nil {
+		resultsdb = "" // by default the results are only in the files
+	}
+	latencymode, err := config.GetString("latency_mode")
+	if err != nil {
+		latencymode = "exact" // by default the percentiles are over all latencies
+	}
+	if err := checkLatencyMode(latencymode); err != nil {
+		return nil, err
+	}
+	ntpserver, err := config.GetString("ntp_server")
+	if err != nil {
+		ntpserver = "" // by default do not check the clock against NTP
+	}
+	maxinflight := 0 // by default do not cap open-loop requests
+	if config.Has("max_inflight") {
+		maxinflight, err = checkPosInt(config, "max_inflight")
+		if err != nil {
+			return nil, err
+		}
+	}
+	policy, err := config.GetString("inflight_policy")
+	if err != nil {
+		policy = "drop" // by default keep the offered rate and drop over the cap
+	} else if policy != "drop" && policy != "block" {
+		return nil, fmt.Errorf("Parameter 'inflight_policy' must be drop or block\n")
+	}
+	modelcheck, err := getBool(config, "model_check", false) // by default do not compare against the model
+	if err != nil {
+		return nil, err
+	}
+	var clientsweep BenchType // by default no client count sweep
+	if spec, err := config.GetString("client_sweep"); err == nil {
+		switch spec {
+		case "read":
+			clientsweep = READ
+		case "write":
+			clientsweep = WRITE
+		default:
+			return nil, fmt.Errorf("Parameter 'client_sweep' must be read or write\n")
+		}
+	}
+	var acldepths []int // by default no ACL depth benchmark
+	if spec, err := config.GetString("acl_depths"); err == nil {
+		acldepths, err = parseCounts(spec)
+		if err != nil {
+			return nil, err
+		}
+		for _, depth := range acldepths {
+			if depth < 1 {
+				return nil, fmt.Errorf("Parameter 'acl_depths' must list depths of at least 1\n")
+			}
+		}
+	}
+	routing, err := getBool(config, "latency_routing", false) // by default every client sticks to its server
+	if err != nil {
+		return nil, err
+	}
+	routingprobe := 100 // by default probe the servers every 100 reads
+	if config.Has("routing_probe_interval") {
+		routingprobe, err = checkPosInt(config, "routing_probe_interval")
+		if err != nil {
+			return nil, err
+		}
+	}
+	appearsamples := 0 // by default do not measure appearance latency
+	if config.Has("appear_samples") {
+		appearsamples, err = checkPosInt(config, "appear_samples")
+		if err != nil {
+			return nil, err
+		}
+	}
+	connecttimeout := 10 * time.Second // by default give up connecting after 10s
+	if spec, err := config.GetString("connect_timeout"); err == nil {
+		connecttimeout, err = time.ParseDuration(spec)
+		if err != nil || connecttimeout <= 0 {
+			return nil, fmt.Errorf("Parameter 'connect_timeout' must be a positive duration\n")
+		}
+	}
+	sessiontimeout := time.Second // by default sessions expire after 1s
+	if spec, err := config.GetString("session_timeout"); err == nil {
+		sessiontimeout, err = time.ParseDuration(spec)
+		if err != nil || sessiontimeout <= 0 {
+			return nil, fmt.Errorf("Parameter 'session_timeout' must be a positive duration\n")
+		}
+	}
+	var optimeout time.Duration // by default requests only fail with the session
+	if spec, err := config.GetString("op_timeout"); err == nil {
+		optimeout, err = time.ParseDuration(spec)
+		if err != nil || optimeout <= 0 {
+			return nil, fmt.Errorf("Parameter 'op_timeout' must be a positive duration\n")
+		}
+	}
+	warmupchildren, err := getBool(config, "warmup_children", false) // by default MIXED opens fresh child sessions every run
+	if err != nil {
+		return nil, err
+	}
+	var depthfrom, depthto int // by default no depth benchmark
+	if spec, err := config.GetString("depth_range"); err == nil {
+		depthfrom, depthto, err = parseDepthRange(spec)
+		if err != nil {
+			return nil, err
+		}
+	}
+	churnnodes := 0 // by default no churn at a steady node count
+	if config.Has("churn_nodes") {
+		churnnodes, err = checkPosInt(config, "churn_nodes")
+		if err != nil {
+			return nil, err
+		}
+	}
+	maxerrorrate := 0.01 // by default pass with at most 1% failed requests
+	if config.Has("max_error_rate") {
+		maxerrorrate, err = config.GetFloat64("max_error_rate")
+		if err != nil || maxerrorrate < 0 || maxerrorrate > 1 {
+			return nil, fmt.Errorf("Parameter 'max_error_rate' must be within [0, 1]\n")
+		}
+	}
+	var assertp99 time.Duration // by default no SLA on the latency
+	if config.Has("assert_p99_ms") {
+		ms, err := config.GetFloat64("assert_p99_ms")
+		if err != nil || ms <= 0 {
+			return nil, fmt.Errorf("Parameter 'assert_p99_ms' must be a positive number\n")
+		}
+		assertp99 = time.Duration(ms * float64(time.Millisecond))
+	}
+	asserterrorrate := -1.0 // by default no SLA on the errors
+	if config.Has("assert_max_error_rate") {
+		asserterrorrate, err = config.GetFloat64("assert_max_error_rate")
+		if err != nil || asserterrorrate < 0 || asserterrorrate > 1 {
+			return nil, fmt.Errorf("Parameter 'assert_max_error_rate' must be within [0, 1]\n")
+		}
+	}
+	assertthroughput := 0.0 // by default no SLA on the throughput
+	if config.Has("assert_min_throughput") {
+		assertthroughput, err = config.GetFloat64("assert_min_throughput")
+		if err != nil || assertthroughput <= 0 {
+			return nil, fmt.Errorf("Parameter 'assert_min_throughput' must be a positive number\n")
+		}
+	}
+	smoketimeout := 5 * time.Second // by default wait 5s for every client
+	if spec, err := config.GetString("smoke_timeout"); err == nil {
+		smoketimeout, err = time.ParseDuration(spec)
+		if err != nil || smoketimeout <= 0 {
+			return nil, fmt.Errorf("Parameter 'smoke_timeout' must be a positive duration\n")
+		}
+	}
+	requiresmoke, err := getBool(config, "require_smoke_pass", false) // by default run even if the smoke test failed
+	if err != nil {
+		return nil, err
+	}
+	var thinktime time.Duration // by default no pause between requests
+	if spec, err := config.GetString("think_time"); err == nil {
+		thinktime, err = time.ParseDuration(spec)
+		if err != nil || thinktime < 0 {
+			return nil, fmt.Errorf("Parameter 'think_time' must be a non-negative duration\n")
+		}
+	}
+	thinkdist, err := config.GetString("think_time_dist")
+	if err != nil {
+		thinkdist = "constant" // by default always pause for think_time
+	} else if err = checkThinkDist(thinkdist); err != nil {
+		return nil, err
+	}
+	var ephemeralcounts []int // by default do not measure session close
+	if spec, err := config.GetString("ephemeral_counts"); err == nil {
+		ephemeralcounts, err = parseCounts(spec)
+		if err != nil {
+			return nil, err
+		}
+	}
+	rywsamples := 0 // by default do not check read-your-writes
+	if config.Has("read_your_writes") {
+		rywsamples, err = checkPosInt(config, "read_your_writes")
+		if err != nil {
+			return nil, err
+		}
+	}
+	var phasedelay time.Duration // by default start the next bench type right away
+	if spec, err := config.GetString("phase_delay"); err == nil {
+		phasedelay, err = time.ParseDuration(spec)
+		if err != nil || phasedelay < 0 {
+			return nil, fmt.Errorf("Parameter 'phase_delay' must be a non-negative duration\n")
+		}
+	}
+	phasedrain, err := getBool(config, "phase_drain", false) // by default do not wait for the servers to drain
+	if err != nil {
+		return nil, err
+	}
+	ttlsamples := 0 // by default do not benchmark TTL nodes
+	if config.Has("ttl_samples") {
+		ttlsamples, err = checkPosInt(config, "ttl_samples")
+		if err != nil {
+			return nil, err
+		}
+	}
+	ttl := time.Second // by default TTL nodes expire after 1s
+	if spec, err := config.GetString("ttl_duration"); err == nil {
+		ttl, err = time.ParseDuration(spec)
+		if err != nil || ttl < time.Millisecond {
+			return nil, fmt.Errorf("Parameter 'ttl_duration' must be a duration of at least 1ms\n")
+		}
+	}
+	ttlverify, err := getBool(config, "ttl_verify", false) // by default do not wait for the TTL nodes to expire
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := parseProfiles(config)
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 && len(profiles) == 0 {
+		return nil, fmt.Errorf("No server or profile configured\n")
+	}
+	var phasedmix []MixPhase
+	if spec, err := config.GetString("phased_mix"); err == nil {
+		phasedmix, err = parsePhasedMix(spec)
+		if err != nil {
+			return nil, err
+		}
+		if readpool > 0 {
+			return nil, fmt.Errorf("Parameters 'phased_mix' and 'read_pool_fraction' cannot be combined\n")
 		}
-		btype = btype | uint32(t)
 	}
 
 	sort.Strings(servers)
 	endpoints := make([]string, len(servers))
 	for i, server := range servers {
 		endpoints[i], _ = config.GetString(server)
-		fmt.Println(server + "=" + endpoints[i])
+	}
+	if err := checkEndpoints("server.", servers, endpoints); err != nil {
+		return nil, err
 	}
 	benchconf := &BenchConfig{
-		Namespace:      "/" + namespace,
-		NClients:       nclients,
-		Servers:        servers,
-		Endpoints:      endpoints,
-		Type:           btype,
-		NRequests:      nrequests,
-		ReadPercent:    rdpercent,
-		WritePercent:   wrpercent,
-		KeySizeBytes:   key_size_bytes,
-		ValueSizeBytes: value_size_bytes,
-		SameKey:        samekey,
-		RandomAccess:   random,
-		Parallelism:    parallelism,
-		Runs:           runs,
-		Cleanup:        cleanup,
+		Namespace:           "/" + namespace,
+		NClients:            nclients,
+		Servers:             servers,
+		Endpoints:           endpoints,
+		Type:                btype,
+		NRequests:           nrequests,
+		ReadPercent:         rdpercent,
+		WritePercent:        wrpercent,
+		KeySizeBytes:        key_size_bytes,
+		ValueSizeBytes:      value_size_bytes,
+		SameKey:             samekey,
+		RandomAccess:        random,
+		Parallelism:         parallelism,
+		Runs:                runs,
+		Cleanup:             cleanup,
+		PhasedMix:           phasedmix,
+		RegenerateValues:    regenerate,
+		RateSweep:           ratesweep,
+		RateSweepStep:       ratesweepstep,
+		KeyList:             keylist,
+		Percentiles:         percentiles,
+		Backend:             backend,
+		CreateMode:          createmode,
+		TrackCoalescing:     coalescing,
+		ProtocolOverhead:    overhead,
+		Profiles:            profiles,
+		ReconnectBackoff:    backoff,
+		ClientRate:          clientrate,
+		ZxidSampleRate:      zxidrate,
+		ElectionRounds:      elections,
+		RawRotateBytes:      rotatebytes,
+		RawRotateInterval:   rotateinterval,
+		RawCompress:         rawcompress,
+		RawKeys:             rawkeys,
+		RawWarmup:           rawwarmup,
+		MeasureAfter:        measureafter,
+		SkipFirstOps:        skipfirstops,
+		AdaptiveWarmup:      adaptive,
+		WarmupWindow:        warmupwindow,
+		WarmupCV:            warmupcv,
+		WarmupMax:           warmupmax,
+		ReadPoolFraction:    readpool,
+		CompareContention:   contention,
+		Scenario:            scenario,
+		WatchCounts:         watchcounts,
+		WatchSamples:        watchsamples,
+		CDFPoints:           cdfpoints,
+		TimeSeries:          timeseries,
+		BucketInterval:      bucketinterval,
+		ProgressInterval:    progressinterval,
+		ClientDelays:        delays,
+		NTPServer:           ntpserver,
+		MetricsAddr:         metricsaddr,
+		MetricSink:          metricsink,
+		StatsdAddr:          statsdaddr,
+		ResultsDB:           resultsdb,
+		LatencyMode:         latencymode,
+		MaxInflight:         maxinflight,
+		InflightPolicy:      policy,
+		ModelCheck:          modelcheck,
+		ClientSweep:         clientsweep,
+		ACLDepths:           acldepths,
+		LatencyRouting:      routing,
+		RoutingProbe:        routingprobe,
+		AppearSamples:       appearsamples,
+		ConnectTimeout:      connecttimeout,
+		SessionTimeout:      sessiontimeout,
+		OpTimeout:           optimeout,
+		WarmupChildren:      warmupchildren,
+		DepthFrom:           depthfrom,
+		DepthTo:             depthto,
+		ChurnNodes:          churnnodes,
+		MaxErrorRate:        maxerrorrate,
+		AssertP99:           assertp99,
+		AssertMaxErrorRate:  asserterrorrate,
+		AssertMinThroughput: assertthroughput,
+		SmokeTimeout:        smoketimeout,
+		RequireSmokePass:    requiresmoke,
+		ThinkTime:           thinktime,
+		ThinkDist:           thinkdist,
+		EphemeralCounts:     ephemeralcounts,
+		RYWSamples:          rywsamples,
+		PhaseDelay:          phasedelay,
+		PhaseDrain:          phasedrain,
+		TTLSamples:          ttlsamples,
+		TTL:                 ttl,
+		TTLVerify:           ttlverify,
 	}
 	return benchconf, nil
 }
 
+// checkEndpoints makes sure every server key of the form prefix+"N" has
+// exactly one non-empty endpoint, so clients are not silently paired with
+// the wrong server.
+func checkEndpoints(prefix string, servers []string, endpoints []string) error {
+	if len(servers) != len(endpoints) {
+		return fmt.Errorf("Got %d servers but %d endpoints\n", len(servers), len(endpoints))
+	}
+	seen := make(map[string]string)
+	for i, server := range servers {
+		if !strings.HasPrefix(server, prefix) || len(server) == len(prefix) {
+			return fmt.Errorf("Malformed server key '%s', expecting '%sN'\n", server, prefix)
+		}
+		endpoint := strings.TrimSpace(endpoints[i])
+		if len(endpoint) == 0 {
+			return fmt.Errorf("Server '%s' has no endpoint\n", server)
+		}
+		if other, ok := seen[endpoint]; ok {
+			fmt.Fprintf(os.Stderr, "warning: servers %s and %s share endpoint %s\n", other, server, endpoint)
+		}
+		seen[endpoint] = server
+	}
+	return nil
+}
+
+func checkBackend(backend string) error {
+	for _, b := range BACKENDS {
+		if b == backend {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown backend '%s', must be one of %s\n", backend, strings.Join(BACKENDS, "|"))
+}
+
+// parsePercentiles parses a comma-separated list of percentiles in (0, 100].
+func parsePercentiles(spec string) ([]float64, error) {
+	var percentiles []float64
+	for _, s := range strings.Split(spec, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || p <= 0 || p > 100 {
+			return nil, fmt.Errorf("Invalid percentile '%s': must be within (0, 100]\n", s)
+		}
+		percentiles = append(percentiles, p)
+	}
+	return percentiles, nil
+}
+
+// loadKeyList reads one key per line, skipping blank lines and # comments.
+func loadKeyList(path string) ([]string, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to open key list: %v\n", err)
+	}
+	defer fp.Close()
+	var keys []string
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Fail to read key list: %v\n", err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("Key list %s is empty\n", path)
+	}
+	return keys, nil
+}
+
+// getBool returns the boolean key of config, or def if it is not set.
+func getBool(config *zkc.Config, key string, def bool) (bool, error) {
+	if !config.Has(key) {
+		return def, nil
+	}
+	val, err := config.GetBool(key)
+	if err != nil {
+		return false, fmt.Errorf("Parameter '%s' must be true or false\n", key)
+	}
+	return val, nil
+}
+
 func checkPosFloat32(config *zkc.Config, key string) (float32, error) {
 	val, err := config.GetFloat32(key)
 	if err != nil {
@@ -0,0 +1,54 @@
+package bench
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// parseSpec parses the config spec as a config file.
+func parseSpec(t *testing.T, spec string) (*BenchConfig, error) {
+	conf := filepath.Join(t.TempDir(), "bench.conf")
+	if err := os.WriteFile(conf, []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return ParseConfig(conf)
+}
+
+func TestParseConfigDefaults(t *testing.T) {
+	config, err := parseSpec(t, "type = r\nserver.0 = localhost:1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ReadPercent != -1 || config.Parallelism != 1 || config.Runs != 1 {
+		t.Errorf("read percent %f parallelism %d runs %d, want -1, 1 and 1",
+			config.ReadPercent, config.Parallelism, config.Runs)
+	}
+	if config.ProtocolOverhead != 32 || !config.Cleanup || config.ZxidSampleRate != 0 || config.ClientRate != 0 {
+		t.Errorf("overhead %d cleanup %t zxid rate %f client rate %f, want 32, true, 0 and 0",
+			config.ProtocolOverhead, config.Cleanup, config.ZxidSampleRate, config.ClientRate)
+	}
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	tests := []string{
+		"protocol_overhead_bytes = -1",
+		"protocol_overhead_bytes = many",
+		"read_percent = half",
+		"write_percent = 0",
+		"parallelism = 0",
+		"runs = -2",
+		"client_rate = fast",
+		"target_rate = 0",
+		"zxid_sample_rate = all",
+		"cleanup = maybe",
+		"raw_keys = 2",
+	}
+	for _, line := range tests {
+		t.Run(line, func(t *testing.T) {
+			if _, err := parseSpec(t, "type = r\nserver.0 = localhost:1\n"+line+"\n"); err == nil {
+				t.Errorf("no error")
+			}
+		})
+	}
+}
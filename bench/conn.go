@@ -0,0 +1,155 @@
+package bench
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// ZKConn is the set of ZooKeeper operations Client relies on. Client only
+// talks to ZooKeeper through it, which allows mocking the connection,
+// wrapping it for instrumentation or plugging in another backend. The
+// default implementation is go-zookeeper's *zk.Conn; MemConn is an
+// in-memory stand-in for testing the benchmark without an ensemble.
+type ZKConn interface {
+	Get(path string) ([]byte, *zk.Stat, error)
+	GetW(path string) ([]byte, *zk.Stat, <-chan zk.Event, error)
+	Set(path string, data []byte, version int32) (*zk.Stat, error)
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+	Delete(path string, version int32) error
+	Children(path string) ([]string, *zk.Stat, error)
+	ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error)
+	Exists(path string) (bool, *zk.Stat, error)
+	ExistsW(path string) (bool, *zk.Stat, <-chan zk.Event, error)
+	Sync(path string) (string, error)
+	AddAuth(scheme string, auth []byte) error
+	Multi(ops ...interface{}) ([]zk.MultiResponse, error)
+	Close()
+}
+
+// connect opens a connection to an ensemble given by its server
+// endpoints, preferring the first one. SetBackend replaces it to run the
+// benchmark against another backend.
+var connect = connectZK
+
+// ConnectTimeout bounds dialing a ZooKeeper server and establishing the
+// session on it, so an unreachable endpoint fails fast instead of being
+// retried in the background while the first request hangs. It is separate
+// from the session timeout.
+var ConnectTimeout = 10 * time.Second
+
+// SessionTimeout is the ZooKeeper session timeout of new connections.
+var SessionTimeout = time.Second
+
+// orderedHosts is a zk.HostProvider that tries the servers in the given
+// order, starting over from the first one, unlike go-zookeeper's default
+// provider which shuffles them. This keeps a client on its primary server
+// as long as that server is up.
+type orderedHosts struct {
+	mu      sync.Mutex
+	servers []string
+	curr    int
+	last    int
+}
+
+func (self *orderedHosts) Init(servers []string) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if len(servers) == 0 {
+		return fmt.Errorf("no servers")
+	}
+	self.servers = append([]string{}, servers...)
+	self.curr = -1
+	self.last = -1
+	return nil
+}
+
+func (self *orderedHosts) Len() int {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return len(self.servers)
+}
+
+func (self *orderedHosts) Next() (string, bool) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.curr = (self.curr + 1) % len(self.servers)
+	retryStart := self.curr == self.last
+	if self.last == -1 {
+		self.last = 0
+	}
+	return self.servers[self.curr], retryStart
+}
+
+func (self *orderedHosts) Connected() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.last = self.curr
+}
+
+// connectZK connects to a ZooKeeper ensemble. The connection fails over to
+// the other endpoints if the first one is down or goes away.
+func connectZK(endpoints []string) (ZKConn, error) {
+	timeout := ConnectTimeout
+	dialer := func(network, address string, _ time.Duration) (net.Conn, error) {
+		return net.DialTimeout(network, address, timeout)
+	}
+	conn, events, err := zk.Connect(endpoints, SessionTimeout, zk.WithLogger(newConnLogger()), zk.WithDialer(dialer),
+		zk.WithHostProvider(&orderedHosts{}))
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.After(timeout)
+	for {
+		select {
+		case ev := <-events:
+			if ev.State == zk.StateHasSession {
+				// the later events are watched by the client
+				return &sessionConn{Conn: conn, events: events}, nil
+			}
+		case <-deadline:
+			conn.Close()
+			return nil, fmt.Errorf("no session with %s within %s", strings.Join(endpoints, ","), timeout)
+		}
+	}
+}
+
+// BACKENDS lists the systems the benchmark can run against.
+var BACKENDS = []string{"zookeeper", "etcd", "memory"}
+
+// SetBackend selects the system clients created afterwards connect to:
+// a ZooKeeper ensemble, an etcd cluster through the same operation set, or
+// the in-memory ZooKeeper of UseMemStore.
+func SetBackend(backend string) error {
+	switch backend {
+	case "zookeeper":
+		connect = connectZK
+	case "etcd":
+		connect = connectEtcd
+	case "memory":
+		UseMemStore()
+	default:
+		return fmt.Errorf("Unknown backend %s", backend)
+	}
+	return nil
+}
+
+// UseMemStore makes all clients created afterwards connect to one shared
+// in-memory ZooKeeper instead of the configured servers. This allows
+// self-testing the benchmark logic without a real ensemble.
+func UseMemStore() {
+	store := NewMemStore()
+	connect = func(endpoints []string) (ZKConn, error) {
+		return store.Connect(), nil
+	}
+}
+
+var (
+	_ ZKConn = (*zk.Conn)(nil)
+	_ ZKConn = (*MemConn)(nil)
+	_ ZKConn = (*EtcdConn)(nil)
+)
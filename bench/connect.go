@@ -0,0 +1,82 @@
+package bench
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// ConnectError is a client that did not get a session within the
+// ConnectTimeout.
+type ConnectError struct {
+	Id       int
+	Server   string
+	Endpoint string
+	Start    time.Time
+	Latency  time.Duration // until the client gave up
+	Err      error
+}
+
+func (self *ConnectError) Error() string {
+	return fmt.Sprintf("client %d failed to connect to %s after %s: %v", self.Id, self.Endpoint,
+		self.Latency.Round(time.Millisecond), self.Err)
+}
+
+func (self *ConnectError) Unwrap() error {
+	return self.Err
+}
+
+// dumpConnections writes how long every client took to establish its
+// session to connections.dat, after the clients that failed to connect in
+// earlier attempts of Init, and CONNECT summary rows of the clients and of
+// all clients merged.
+func (self *Benchmark) dumpConnections(statf *os.File) {
+	cf, err := os.Create(self.outprefix + "connections.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer cf.Close()
+	cf.WriteString("client_id,server,endpoint,connect_latency,success\n")
+	for _, f := range self.connectFailures {
+		cf.WriteString(fmt.Sprintf("%d,%s,%s,%d,false\n", f.Id, f.Server, f.Endpoint, f.Latency.Nanoseconds()))
+	}
+	stats := make([]*BenchStat, len(self.clients))
+	var groupStartTime time.Time
+	for i, client := range self.clients {
+		cf.WriteString(fmt.Sprintf("%d,%s,%s,%d,true\n", client.Id, client.Server, client.EndPoint,
+			client.ConnectLatency.Nanoseconds()))
+		stat := &BenchStat{OpType: "CONNECT.1", Run: 1}
+		stat.add(client.EndPoint, client.ConnectStart, client.ConnectLatency, nil)
+		stat.finish()
+		stat.ComputePercentiles()
+		stats[i] = stat
+		if groupStartTime.IsZero() || client.ConnectStart.Before(groupStartTime) {
+			groupStartTime = client.ConnectStart
+		}
+	}
+	for i, client := range self.clients {
+		if self.AggregateOnly {
+			break
+		}
+		self.storeSummary(client.Id, client.Server, "CONNECT", 1, stats[i])
+		if self.Format == "json" {
+			self.writeSummaryJSON(statf, client.Id, client.Server, "CONNECT", 1, stats[i], groupStartTime, client.Delay, nil)
+		} else {
+			statf.WriteString(summaryRow(client.Id, "CONNECT", 1, stats[i], groupStartTime) + self.summaryCols(stats[i], client.Delay, client.Server) + "\n")
+		}
+	}
+	id := ALL_CLIENTS
+	if self.AggregateOnly {
+		id = 0
+	}
+	all, delay := self.aggregateStats(stats)
+	if all == nil {
+		return
+	}
+	self.storeSummary(id, ALL_SERVERS, "CONNECT", 1, all)
+	if self.Format == "json" {
+		self.writeSummaryJSON(statf, id, ALL_SERVERS, "CONNECT", 1, all, groupStartTime, delay, nil)
+	} else {
+		statf.WriteString(summaryRow(id, "CONNECT", 1, all, groupStartTime) + self.summaryCols(all, delay, ALL_SERVERS) + "\n")
+	}
+}
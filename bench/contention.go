@@ -0,0 +1,94 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"sync"
+	"time"
+)
+
+const contentionKey = "contention"
+
+// runContention compares writes by all clients to one hot znode against
+// writes by every client to a znode of its own, with the same number of
+// clients, requests and value size. The difference isolates the cost of
+// write contention on a single node, e.g. a shared config node.
+func (self *Benchmark) runContention() {
+	cf, err := self.openOutput("contention.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer cf.Close()
+	if info, err := cf.Stat(); err == nil && info.Size() == 0 {
+		cf.WriteString("mode,clients,operations,errors,average_latency,99th_latency,throughput,slowdown\n")
+	}
+
+	src := mrand.NewSource(time.Now().UnixNano())
+	val := randBytes(src, self.ValueSizeBytes)
+	if self.root_client != nil {
+		if _, err := self.root_client.CreateIfNotExist(contentionKey, val); err != nil {
+			self.root_client.Log("error in creating hot znode: %v", err)
+		}
+	}
+	for _, client := range self.clients {
+		if _, err := client.CreateIfNotExist(contentionKey, val); err != nil {
+			client.Log("error in creating contention znode: %v", err)
+		}
+	}
+
+	modes := []struct {
+		name string
+		key  string
+	}{
+		{"HOT", self.Namespace + "/" + contentionKey}, // absolute, shared by all clients
+		{"SPREAD", contentionKey},                     // relative to each client namespace
+	}
+	handler := func(ctx context.Context, c *Client, r *Request) error {
+		return c.Write(ctx, r.key, r.value)
+	}
+	var hotAvg time.Duration
+	for _, mode := range modes {
+		var wg sync.WaitGroup
+		key := mode.key
+		generator := func(iter int64) *Request { return &Request{key, val} }
+		optype := "CONTENTION." + mode.name
+		for _, client := range self.clients {
+			client.Stat = nil
+			wg.Add(1)
+			go func(client *Client) {
+				defer wg.Done()
+				client.Log("start bench %s", optype)
+				self.processRequests(client, WRITE, 0, 1, optype, self.NRequests, self.Parallelism, false, true, generator, handler)
+				client.Log("done bench %s", optype)
+			}(client)
+		}
+		wg.Wait()
+
+		var total BenchStat
+		for _, client := range self.clients {
+			if total.Ops == 0 {
+				total = *client.Stat
+				total.Latencies = append([]BenchLatency(nil), client.Stat.Latencies...)
+			} else {
+				total.Merge(client.Stat)
+			}
+		}
+		total.ComputePercentiles()
+		var throughput float64
+		if elapsed := total.EndTime.Sub(total.StartTime); elapsed > 0 {
+			throughput = float64(total.Ops-total.Errors) / elapsed.Seconds()
+		}
+		// slowdown of the hot node relative to spread writes
+		slowdown := 1.0
+		if mode.name == "HOT" {
+			hotAvg = total.AvgLatency
+		} else if total.AvgLatency > 0 {
+			slowdown = float64(hotAvg) / float64(total.AvgLatency)
+		}
+		cf.WriteString(fmt.Sprintf("%s,%d,%d,%d,%d,%d,%f,%f\n", mode.name, len(self.clients), total.Ops, total.Errors,
+			total.AvgLatency.Nanoseconds(), total.NinetyNinethLatency, throughput, slowdown))
+		log.Printf("[Bench]: %s writes: avg latency %s, throughput %f req/s\n", optype, total.AvgLatency, throughput)
+	}
+}
@@ -0,0 +1,177 @@
+package bench
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	dashboardRefresh  = time.Second
+	dashboardLogLines = 8
+	// dashboardSamples caps the latencies kept per refresh for the
+	// percentiles, the requests beyond it are only counted
+	dashboardSamples = 1 << 16
+)
+
+// IsTerminal reports whether f is a terminal a Dashboard can draw on.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+type dashboardClient struct {
+	optype string
+	ops    int64
+	errors int64
+	last   int64 // ops at the previous refresh
+}
+
+// Dashboard is a MetricSink that redraws a live view of the benchmark on a
+// terminal every second: the throughput and errors of every client, and
+// the throughput and p50/p99 latency of all clients since the last
+// refresh. It takes over the terminal with its alternate screen, so log
+// lines should be written to it instead, which shows the latest ones.
+type Dashboard struct {
+	out     *os.File
+	mutex   sync.Mutex
+	clients map[string]*dashboardClient
+	samples []time.Duration
+	ops     int64 // of all clients since the last refresh
+	logs    []string
+	start   time.Time
+	stop    chan struct{}
+	done    chan struct{}
+	signals chan os.Signal
+	once    sync.Once
+}
+
+// NewDashboard switches the terminal out to its alternate screen and
+// starts drawing. The terminal is restored by Close, or on SIGINT and
+// SIGTERM, which then end the process.
+func NewDashboard(out *os.File) *Dashboard {
+	self := &Dashboard{
+		out:     out,
+		clients: make(map[string]*dashboardClient),
+		start:   time.Now(),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		signals: make(chan os.Signal, 1),
+	}
+	out.WriteString("\x1b[?1049h\x1b[?25l") // alternate screen, hide cursor
+	signal.Notify(self.signals, syscall.SIGINT, syscall.SIGTERM)
+	go self.loop()
+	return self
+}
+
+func (self *Dashboard) RecordOp(client, optype string, latency time.Duration, err error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	c, ok := self.clients[client]
+	if !ok {
+		c = &dashboardClient{}
+		self.clients[client] = c
+	}
+	c.optype = optype
+	c.ops++
+	self.ops++
+	if err != nil {
+		c.errors++
+	} else if len(self.samples) < dashboardSamples {
+		self.samples = append(self.samples, latency)
+	}
+}
+
+// Write keeps the latest log lines to show below the stats.
+func (self *Dashboard) Write(p []byte) (int, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		self.logs = append(self.logs, line)
+	}
+	if len(self.logs) > dashboardLogLines {
+		self.logs = self.logs[len(self.logs)-dashboardLogLines:]
+	}
+	return len(p), nil
+}
+
+func (self *Dashboard) loop() {
+	defer close(self.done)
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-self.stop:
+			return
+		case sig := <-self.signals:
+			self.restore()
+			fmt.Fprintf(os.Stderr, "Interrupted by %v\n", sig)
+			os.Exit(130)
+		case now := <-ticker.C:
+			self.draw(now.Sub(last))
+			last = now
+		}
+	}
+}
+
+// draw renders the stats of the elapsed time since the last refresh.
+func (self *Dashboard) draw(elapsed time.Duration) {
+	self.mutex.Lock()
+	names := make([]string, 0, len(self.clients))
+	for name := range self.clients {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) < len(names[j]) // numeric ids in order
+		}
+		return names[i] < names[j]
+	})
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J") // home, clear
+	fmt.Fprintf(&b, "zkbench  elapsed %s\n\n", time.Since(self.start).Round(time.Second))
+	sort.Slice(self.samples, func(i, j int) bool { return self.samples[i] < self.samples[j] })
+	var p50, p99 time.Duration
+	if n := len(self.samples); n > 0 {
+		p50, p99 = self.samples[n/2], self.samples[n*99/100]
+	}
+	fmt.Fprintf(&b, "all clients  %.1f req/s  p50 %v  p99 %v\n\n", float64(self.ops)/elapsed.Seconds(), p50, p99)
+	tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "client\tbench\treq/s\tops\terrors\n")
+	for _, name := range names {
+		c := self.clients[name]
+		fmt.Fprintf(tw, "%s\t%s\t%.1f\t%d\t%d\n", name, c.optype, float64(c.ops-c.last)/elapsed.Seconds(), c.ops, c.errors)
+		c.last = c.ops
+	}
+	tw.Flush()
+	b.WriteString("\n")
+	for _, line := range self.logs {
+		b.WriteString(line + "\n")
+	}
+	self.samples, self.ops = self.samples[:0], 0
+	self.mutex.Unlock()
+	self.out.WriteString(b.String())
+}
+
+// restore switches the terminal back to its main screen, once.
+func (self *Dashboard) restore() {
+	self.once.Do(func() {
+		signal.Stop(self.signals)
+		self.out.WriteString("\x1b[?25h\x1b[?1049l")
+	})
+}
+
+// Close stops drawing and restores the terminal.
+func (self *Dashboard) Close() error {
+	close(self.stop)
+	<-self.done
+	self.restore()
+	return nil
+}
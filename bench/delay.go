@@ -0,0 +1,62 @@
+package bench
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClientDelay is an artificial network delay injected before every request
+// of the clients with ids From to To, e.g. to model clients in a remote
+// region. The delay is not part of the measured latency and is reported in
+// its own summary column.
+type ClientDelay struct {
+	From  int
+	To    int
+	Delay time.Duration
+}
+
+// parseClientDelays parses either a single duration applied to all clients
+// or a comma-separated list of id ranges with their delay, e.g.
+// "1-4:0ms,5-8:80ms".
+func parseClientDelays(spec string) ([]ClientDelay, error) {
+	if d, err := time.ParseDuration(strings.TrimSpace(spec)); err == nil {
+		if d < 0 {
+			return nil, fmt.Errorf("Invalid client delay '%s': must not be negative\n", spec)
+		}
+		return []ClientDelay{{From: 1, To: int(^uint(0) >> 1), Delay: d}}, nil
+	}
+	var delays []ClientDelay
+	for _, s := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(s), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid client delay '%s': expecting ids:duration\n", s)
+		}
+		ids := strings.SplitN(parts[0], "-", 2)
+		from, err := strconv.Atoi(ids[0])
+		to := from
+		if err == nil && len(ids) == 2 {
+			to, err = strconv.Atoi(ids[1])
+		}
+		if err != nil || from < 1 || to < from {
+			return nil, fmt.Errorf("Invalid client ids '%s' in client delay\n", parts[0])
+		}
+		d, err := time.ParseDuration(parts[1])
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("Invalid duration '%s' in client delay\n", parts[1])
+		}
+		delays = append(delays, ClientDelay{From: from, To: to, Delay: d})
+	}
+	return delays, nil
+}
+
+// delayFor returns the delay of client id, 0 if none applies.
+func delayFor(delays []ClientDelay, id int) time.Duration {
+	for _, d := range delays {
+		if id >= d.From && id <= d.To {
+			return d.Delay
+		}
+	}
+	return 0
+}
@@ -0,0 +1,96 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const depthKey = "depth"
+
+// parseDepthRange parses a depth range such as "1-16", or a single depth.
+func parseDepthRange(spec string) (int, int, error) {
+	parts := strings.SplitN(strings.TrimSpace(spec), "-", 2)
+	from, err := strconv.Atoi(parts[0])
+	to := from
+	if err == nil && len(parts) == 2 {
+		to, err = strconv.Atoi(parts[1])
+	}
+	if err != nil || from < 1 || to < from {
+		return 0, 0, fmt.Errorf("Invalid depth range '%s': expecting from-to with 1 <= from <= to\n", spec)
+	}
+	return from, to, nil
+}
+
+// depthPath returns the key depth levels below the client namespace. The
+// keys of all depths lie on one chain, so creating the deepest one with
+// CreateR builds them all.
+func depthPath(depth int) string {
+	return depthKey + strings.Repeat("/n", depth-1)
+}
+
+// runDepth measures the latency of reads and writes of znodes at every depth
+// of DepthFrom to DepthTo below the namespace of the first client, to tell
+// what the path traversal of a deep tree costs.
+func (self *Benchmark) runDepth() {
+	df, err := self.openOutput("depth.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer df.Close()
+	if info, err := df.Stat(); err == nil && info.Size() == 0 {
+		df.WriteString("depth,path_bytes,op,operations,errors,average_latency,99th_latency\n")
+	}
+	if len(self.clients) == 0 {
+		return
+	}
+	client := self.clients[0]
+	val := randBytes(mrand.NewSource(time.Now().UnixNano()), self.ValueSizeBytes)
+	if err := client.CreateR(depthPath(self.DepthTo), val); err != nil {
+		client.Log("error in creating the znodes of depth %d: %v", self.DepthTo, err)
+		return
+	}
+	ops := []struct {
+		name    string
+		handler ReqHandler
+	}{
+		{"READ", func(ctx context.Context, c *Client, r *Request) error {
+			_, _, err := c.Read(ctx, r.key)
+			return err
+		}},
+		{"WRITE", func(ctx context.Context, c *Client, r *Request) error {
+			return c.Write(ctx, r.key, r.value)
+		}},
+	}
+	for depth := self.DepthFrom; depth <= self.DepthTo; depth++ {
+		key := depthPath(depth)
+		generator := func(iter int64) *Request { return &Request{key, val} }
+		for _, op := range ops {
+			optype := fmt.Sprintf("DEPTH.%s.%d", op.name, depth)
+			client.Stat = nil
+			self.processRequests(client, READ, 0, 1, optype, self.NRequests, 1, false, true, generator, op.handler)
+			stat := client.Stat
+			if stat == nil || stat.Ops == 0 {
+				continue
+			}
+			stat.ComputePercentiles()
+			df.WriteString(fmt.Sprintf("%d,%d,%s,%d,%d,%d,%d\n", depth, len(client.FullPath(key)), op.name, stat.Ops,
+				stat.Errors, stat.AvgLatency.Nanoseconds(), stat.NinetyNinethLatency))
+			log.Printf("[Bench]: %s: avg latency %s\n", optype, stat.AvgLatency)
+		}
+	}
+	conn := client.currentConn()
+	if conn == nil {
+		client.Log("no connection to delete the znodes of depth 1 to %d", self.DepthTo)
+		return
+	}
+	for depth := self.DepthTo; depth >= 1; depth-- {
+		if err := conn.Delete(client.FullPath(depthPath(depth)), -1); err != nil {
+			client.Log("error in deleting the znode of depth %d: %v", depth, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package bench
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// runElection benchmarks the create-if-not-exists race leader election is
+// built on. In every round all clients try to create the same znode at
+// once; exactly one of them should win and the others should fail with
+// zk.ErrNodeExists. The election latency is the time from the start of the
+// round until the winner's create returns, and the decided latency the time
+// until every client knows the outcome.
+func (self *Benchmark) runElection() {
+	ctx := self.context()
+	ef, err := self.openOutput("election.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer ef.Close()
+	if info, err := ef.Stat(); err == nil && info.Size() == 0 {
+		ef.WriteString("round,clients,winners,node_exists,other_errors,election_latency,decided_latency\n")
+	}
+
+	var empty []byte
+	for round := 1; round <= self.ElectionRounds; round++ {
+		p := self.Namespace + "/election" + strconv.Itoa(round)
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		winners, exists, others := 0, 0, 0
+		var elected, decided time.Duration
+		start := make(chan struct{})
+		var begin time.Time
+		for _, client := range self.clients {
+			wg.Add(1)
+			go func(client *Client) {
+				defer wg.Done()
+				<-start
+				err := client.Create(ctx, p, empty)
+				d := time.Since(begin)
+				mutex.Lock()
+				defer mutex.Unlock()
+				switch err {
+				case nil:
+					winners++
+					elected = d
+				case zk.ErrNodeExists:
+					exists++
+				default:
+					others++
+					client.Log("error in creating election znode %s: %v", p, err)
+				}
+				if d > decided {
+					decided = d
+				}
+			}(client)
+		}
+		begin = time.Now()
+		close(start)
+		wg.Wait()
+		if winners != 1 {
+			log.Printf("[Bench]: election round %d had %d winners\n", round, winners)
+		}
+		ef.WriteString(fmt.Sprintf("%d,%d,%d,%d,%d,%d,%d\n", round, len(self.clients), winners, exists, others,
+			elected.Nanoseconds(), decided.Nanoseconds()))
+		if self.root_client != nil {
+			if conn := self.root_client.currentConn(); conn == nil {
+				self.root_client.Log("no connection to delete election znode %s", p)
+			} else if err := conn.Delete(p, -1); err != nil && err != zk.ErrNoNode {
+				self.root_client.Log("error in deleting election znode %s: %v", p, err)
+			}
+		}
+	}
+	log.Printf("[Bench]: done %d election rounds with %d clients\n", self.ElectionRounds, len(self.clients))
+}
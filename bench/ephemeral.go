@@ -0,0 +1,117 @@
+package bench
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const ephemeralKey = "ephemeral"
+
+// runEphemeral measures what ending sessions with many ephemeral znodes
+// costs. For every count of EphemeralCounts, NClients fresh sessions each
+// create that many ephemeral znodes and are then closed at once, like
+// clients dropping together. The close latency is how long closing a
+// session took, and the cleanup time how long it took from the closes until
+// an observer session saw all ephemeral znodes gone.
+func (self *Benchmark) runEphemeral() {
+	ef, err := self.openOutput("ephemeral.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer ef.Close()
+	if info, err := ef.Stat(); err == nil && info.Size() == 0 {
+		ef.WriteString("ephemerals_per_session,sessions,create_errors,average_close_latency,max_close_latency,cleanup_time\n")
+	}
+	if self.root_client == nil {
+		return
+	}
+	parent := self.Namespace + "/" + ephemeralKey
+	if _, err := self.root_client.CreateIfNotExist(ephemeralKey, nil); err != nil {
+		self.root_client.Log("error in creating ephemeral parent: %v", err)
+		return
+	}
+	defer func() {
+		if conn := self.root_client.currentConn(); conn != nil {
+			conn.Delete(parent, -1)
+		}
+	}()
+
+	for _, count := range self.EphemeralCounts {
+		sessions := make([]*Client, 0, len(self.clients))
+		for i, client := range self.clients {
+			session, err := NewClient(-1, fmt.Sprintf("ephemeral%d", i+1), client.Server, client.EndPoints, self.Namespace)
+			if err != nil {
+				log.Printf("[Bench]: failed to create ephemeral session %d: %v\n", i+1, err)
+				continue
+			}
+			sessions = append(sessions, session)
+		}
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		errors := 0
+		for i, session := range sessions {
+			wg.Add(1)
+			go func(i int, session *Client) {
+				defer wg.Done()
+				conn := session.currentConn()
+				for n := 0; n < count; n++ {
+					p := fmt.Sprintf("%s/%d.%d", parent, i+1, n)
+					err := zk.ErrNoServer
+					if conn != nil {
+						_, err = conn.Create(p, nil, zk.FlagEphemeral, zkCreateACL)
+					}
+					if err != nil {
+						mutex.Lock()
+						errors++
+						mutex.Unlock()
+					}
+				}
+			}(i, session)
+		}
+		wg.Wait()
+
+		closes := make([]time.Duration, len(sessions))
+		start := time.Now()
+		for i, session := range sessions {
+			wg.Add(1)
+			go func(i int, session *Client) {
+				defer wg.Done()
+				begin := time.Now()
+				session.closeConn()
+				closes[i] = time.Since(begin)
+			}(i, session)
+		}
+		wg.Wait()
+		var cleanup time.Duration = -1
+		for deadline := start.Add(time.Minute); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			conn := self.root_client.currentConn()
+			if conn == nil {
+				break
+			}
+			_, stat, err := conn.Exists(parent)
+			if err == nil && stat != nil && stat.NumChildren == 0 {
+				cleanup = time.Since(start)
+				break
+			}
+		}
+		var total, max time.Duration
+		for _, d := range closes {
+			total += d
+			if d > max {
+				max = d
+			}
+		}
+		var avg time.Duration
+		if len(closes) > 0 {
+			avg = total / time.Duration(len(closes))
+		}
+		ef.WriteString(fmt.Sprintf("%d,%d,%d,%d,%d,%d\n", count, len(sessions), errors, avg.Nanoseconds(),
+			max.Nanoseconds(), cleanup.Nanoseconds()))
+		log.Printf("[Bench]: closed %d sessions with %d ephemerals each: avg close %s, cleanup %s\n",
+			len(sessions), count, avg, cleanup)
+	}
+}
@@ -0,0 +1,327 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// EtcdConn implements ZKConn on top of etcd's v3 API, so the same workload
+// can be run against etcd. It talks to the JSON gateway every etcd server
+// exposes on its client port, which avoids pulling in the gRPC client.
+//
+// ZooKeeper semantics are mapped as follows: a znode path is an etcd key,
+// the znode version is the etcd key version minus one, and the children of
+// a path are the keys directly below path + "/". Watches are not supported;
+// the watch channels returned by GetW, ChildrenW and ExistsW never fire.
+type EtcdConn struct {
+	url    string
+	client *http.Client
+}
+
+// connectEtcd connects to the first endpoint only since the gateway does
+// not fail over.
+func connectEtcd(endpoints []string) (ZKConn, error) {
+	url := endpoints[0]
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		url = "http://" + url
+	}
+	return &EtcdConn{url: strings.TrimRight(url, "/"), client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+type etcdKV struct {
+	Key            []byte `json:"key"`
+	Value          []byte `json:"value"`
+	CreateRevision int64  `json:"create_revision,string"`
+	ModRevision    int64  `json:"mod_revision,string"`
+	Version        int64  `json:"version,string"`
+}
+
+type etcdRangeRequest struct {
+	Key       []byte `json:"key"`
+	RangeEnd  []byte `json:"range_end,omitempty"`
+	KeysOnly  bool   `json:"keys_only,omitempty"`
+	CountOnly bool   `json:"count_only,omitempty"`
+}
+
+type etcdRangeResponse struct {
+	Kvs   []etcdKV `json:"kvs"`
+	Count int64    `json:"count,string"`
+}
+
+type etcdPutRequest struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type etcdDeleteRequest struct {
+	Key []byte `json:"key"`
+}
+
+// etcdCompare leaves out zero targets since they are one of a union on the
+// server side, which treats a missing create_revision as 0.
+type etcdCompare struct {
+	Target         string `json:"target"`
+	Result         string `json:"result"`
+	Key            []byte `json:"key"`
+	Version        int64  `json:"version,omitempty,string"`
+	CreateRevision int64  `json:"create_revision,omitempty,string"`
+}
+
+type etcdRequestOp struct {
+	RequestPut         *etcdPutRequest    `json:"request_put,omitempty"`
+	RequestDeleteRange *etcdDeleteRequest `json:"request_delete_range,omitempty"`
+}
+
+type etcdTxnRequest struct {
+	Compare []etcdCompare   `json:"compare"`
+	Success []etcdRequestOp `json:"success"`
+}
+
+type etcdTxnResponse struct {
+	Succeeded bool `json:"succeeded"`
+}
+
+// call posts req as JSON to the gateway method and decodes the reply into
+// res. Connection failures are reported as zk.ErrNoServer so the benchmark
+// treats them like a lost ZooKeeper server.
+func (self *EtcdConn) call(method string, req interface{}, res interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := self.client.Post(self.url+"/v3/"+method, "application/json", bytes.NewReader(body))
+	if err != nil {
+		if _, ok := err.(net.Error); ok {
+			return zk.ErrNoServer
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("etcd %s failed with %s: %s", method, resp.Status, e.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+// prefixEnd returns the range end covering all keys with the given prefix.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	end[len(end)-1]++
+	return end
+}
+
+func etcdStat(kv *etcdKV) *zk.Stat {
+	return &zk.Stat{
+		Czxid:      kv.CreateRevision,
+		Mzxid:      kv.ModRevision,
+		Version:    int32(kv.Version - 1),
+		DataLength: int32(len(kv.Value)),
+	}
+}
+
+func (self *EtcdConn) get(path string) (*etcdKV, error) {
+	var res etcdRangeResponse
+	if err := self.call("kv/range", &etcdRangeRequest{Key: []byte(path)}, &res); err != nil {
+		return nil, err
+	}
+	if len(res.Kvs) == 0 {
+		return nil, nil
+	}
+	return &res.Kvs[0], nil
+}
+
+func (self *EtcdConn) Get(path string) ([]byte, *zk.Stat, error) {
+	kv, err := self.get(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if kv == nil {
+		return nil, nil, zk.ErrNoNode
+	}
+	return kv.Value, etcdStat(kv), nil
+}
+
+func (self *EtcdConn) GetW(path string) ([]byte, *zk.Stat, <-chan zk.Event, error) {
+	data, stat, err := self.Get(path)
+	return data, stat, make(chan zk.Event), err
+}
+
+// versionCompare guards a txn on the znode existing and, unless version is
+// -1, having the given ZooKeeper version.
+func versionCompare(path string, version int32) etcdCompare {
+	if version == -1 {
+		return etcdCompare{Target: "CREATE", Result: "GREATER", Key: []byte(path), CreateRevision: 0}
+	}
+	return etcdCompare{Target: "VERSION", Result: "EQUAL", Key: []byte(path), Version: int64(version) + 1}
+}
+
+// txnError tells why a txn guarded by versionCompare on path failed.
+func (self *EtcdConn) txnError(path string) error {
+	kv, err := self.get(path)
+	if err != nil {
+		return err
+	}
+	if kv == nil {
+		return zk.ErrNoNode
+	}
+	return zk.ErrBadVersion
+}
+
+func (self *EtcdConn) Set(path string, data []byte, version int32) (*zk.Stat, error) {
+	var res etcdTxnResponse
+	err := self.call("kv/txn", &etcdTxnRequest{
+		Compare: []etcdCompare{versionCompare(path, version)},
+		Success: []etcdRequestOp{{RequestPut: &etcdPutRequest{Key: []byte(path), Value: data}}},
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	if !res.Succeeded {
+		return nil, self.txnError(path)
+	}
+	return &zk.Stat{Version: version + 1, DataLength: int32(len(data))}, nil
+}
+
+func (self *EtcdConn) Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	if flags&zk.FlagSequence != 0 {
+		return "", zk.ErrAPIError // sequential nodes have no etcd counterpart
+	}
+	var res etcdTxnResponse
+	err := self.call("kv/txn", &etcdTxnRequest{
+		Compare: []etcdCompare{{Target: "CREATE", Result: "EQUAL", Key: []byte(path), CreateRevision: 0}},
+		Success: []etcdRequestOp{{RequestPut: &etcdPutRequest{Key: []byte(path), Value: data}}},
+	}, &res)
+	if err != nil {
+		return "", err
+	}
+	if !res.Succeeded {
+		return "", zk.ErrNodeExists
+	}
+	return path, nil
+}
+
+func (self *EtcdConn) Delete(path string, version int32) error {
+	var res etcdRangeResponse
+	err := self.call("kv/range", &etcdRangeRequest{Key: []byte(path + "/"), RangeEnd: prefixEnd(path + "/"), CountOnly: true}, &res)
+	if err != nil {
+		return err
+	}
+	if res.Count > 0 {
+		return zk.ErrNotEmpty
+	}
+	var txn etcdTxnResponse
+	err = self.call("kv/txn", &etcdTxnRequest{
+		Compare: []etcdCompare{versionCompare(path, version)},
+		Success: []etcdRequestOp{{RequestDeleteRange: &etcdDeleteRequest{Key: []byte(path)}}},
+	}, &txn)
+	if err != nil {
+		return err
+	}
+	if !txn.Succeeded {
+		return self.txnError(path)
+	}
+	return nil
+}
+
+func (self *EtcdConn) Children(path string) ([]string, *zk.Stat, error) {
+	kv, err := self.get(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if kv == nil {
+		return nil, nil, zk.ErrNoNode
+	}
+	prefix := strings.TrimRight(path, "/") + "/"
+	var res etcdRangeResponse
+	err = self.call("kv/range", &etcdRangeRequest{Key: []byte(prefix), RangeEnd: prefixEnd(prefix), KeysOnly: true}, &res)
+	if err != nil {
+		return nil, nil, err
+	}
+	seen := make(map[string]bool)
+	var children []string
+	for _, child := range res.Kvs {
+		name := strings.SplitN(strings.TrimPrefix(string(child.Key), prefix), "/", 2)[0]
+		if !seen[name] {
+			seen[name] = true
+			children = append(children, name)
+		}
+	}
+	sort.Strings(children)
+	stat := etcdStat(kv)
+	stat.NumChildren = int32(len(children))
+	return children, stat, nil
+}
+
+func (self *EtcdConn) ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	children, stat, err := self.Children(path)
+	return children, stat, make(chan zk.Event), err
+}
+
+func (self *EtcdConn) Exists(path string) (bool, *zk.Stat, error) {
+	kv, err := self.get(path)
+	if err != nil || kv == nil {
+		return false, nil, err
+	}
+	return true, etcdStat(kv), nil
+}
+
+func (self *EtcdConn) ExistsW(path string) (bool, *zk.Stat, <-chan zk.Event, error) {
+	exists, stat, err := self.Exists(path)
+	return exists, stat, make(chan zk.Event), err
+}
+
+// Sync is a no-op since etcd reads are linearizable by default.
+func (self *EtcdConn) Sync(path string) (string, error) {
+	return path, nil
+}
+
+func (self *EtcdConn) AddAuth(scheme string, auth []byte) error {
+	return fmt.Errorf("auth scheme %s is not supported by the etcd backend", scheme)
+}
+
+// Multi runs all ops in one etcd txn. When it fails, the error does not
+// tell which op was at fault.
+func (self *EtcdConn) Multi(ops ...interface{}) ([]zk.MultiResponse, error) {
+	var txn etcdTxnRequest
+	for _, op := range ops {
+		switch req := op.(type) {
+		case *zk.CreateRequest:
+			txn.Compare = append(txn.Compare, etcdCompare{Target: "CREATE", Result: "EQUAL", Key: []byte(req.Path)})
+			txn.Success = append(txn.Success, etcdRequestOp{RequestPut: &etcdPutRequest{Key: []byte(req.Path), Value: req.Data}})
+		case *zk.SetDataRequest:
+			txn.Compare = append(txn.Compare, versionCompare(req.Path, req.Version))
+			txn.Success = append(txn.Success, etcdRequestOp{RequestPut: &etcdPutRequest{Key: []byte(req.Path), Value: req.Data}})
+		case *zk.DeleteRequest:
+			txn.Compare = append(txn.Compare, versionCompare(req.Path, req.Version))
+			txn.Success = append(txn.Success, etcdRequestOp{RequestDeleteRange: &etcdDeleteRequest{Key: []byte(req.Path)}})
+		case *zk.CheckVersionRequest:
+			txn.Compare = append(txn.Compare, versionCompare(req.Path, req.Version))
+		default:
+			return nil, fmt.Errorf("unknown operation type %T", op)
+		}
+	}
+	var res etcdTxnResponse
+	if err := self.call("kv/txn", &txn, &res); err != nil {
+		return nil, err
+	}
+	if !res.Succeeded {
+		return nil, zk.ErrBadVersion
+	}
+	return make([]zk.MultiResponse, len(ops)), nil
+}
+
+func (self *EtcdConn) Close() {
+	self.client.CloseIdleConnections()
+}
@@ -0,0 +1,233 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FORMATS lists the formats the summary and raw results can be written in.
+// CSV goes to .dat files with a header line, JSON to .json files with one
+// object per line.
+var FORMATS = []string{"csv", "json"}
+
+// CheckFormat makes sure format is one of FORMATS.
+func CheckFormat(format string) error {
+	for _, f := range FORMATS {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown format '%s', must be one of %s\n", format, strings.Join(FORMATS, "|"))
+}
+
+// summaryRecord is a summary row in the JSON format. All latencies and
+// times are in nanoseconds.
+type summaryRecord struct {
+	ClientID           interface{}      `json:"client_id"` // int, or "ALL"
+	BenchType          string           `json:"bench_type"`
+	Run                int              `json:"run"`
+	Operations         int64            `json:"operations"`
+	Errors             int64            `json:"errors"`
+	AverageLatency     int64            `json:"average_latency"`
+	MinLatency         int64            `json:"min_latency"`
+	MaxLatency         int64            `json:"max_latency"`
+	P99Latency         int64            `json:"99th_latency"`
+	TotalLatency       int64            `json:"total_latency"`
+	Throughput         float64          `json:"throughput"`
+	GroupStartTime     string           `json:"group_start_time"`
+	ThroughputEverySec []int            `json:"throughput_every_sec,omitempty"`
+	Percentiles        map[string]int64 `json:"percentiles,omitempty"`
+	BytesSent          int64            `json:"bytes_sent"`
+	BytesReceived      int64            `json:"bytes_received"`
+	MBPerSec           float64          `json:"mb_per_sec"`
+	InjectedDelay      int64            `json:"injected_delay"`
+	MeanThinkTime      int64            `json:"mean_think_time"`
+	Jitter             int64            `json:"jitter"`
+	ServiceThroughput  float64          `json:"service_time_throughput"`
+	StdDevLatency      int64            `json:"stddev_latency"`
+	CVLatency          float64          `json:"cv_latency"`
+	Server             string           `json:"server"`
+	Endpoint           string           `json:"endpoint"`
+	Timeouts           int64            `json:"timeouts"`
+	SubType            string           `json:"sub_type,omitempty"`
+	Disconnects        int64            `json:"disconnects"`
+	Expirations        int64            `json:"expirations"`
+	IntendedRequests   *int64           `json:"intended_requests,omitempty"`
+	ErrAverageLatency  int64            `json:"err_average_latency"`
+	ErrMinLatency      int64            `json:"err_min_latency"`
+	ErrMaxLatency      int64            `json:"err_max_latency"`
+	ErrP99Latency      int64            `json:"err_99th_latency"`
+	AchievedRate       *float64         `json:"achieved_rate_percent,omitempty"`
+}
+
+// rawRecord is a raw per-request record in the JSON format.
+type rawRecord struct {
+	ClientID   int     `json:"client_id"`
+	BenchType  string  `json:"bench_type"`
+	Run        int     `json:"run"`
+	Time       string  `json:"time"`
+	OpID       int64   `json:"op_id"`
+	Error      bool    `json:"error"`
+	Timeout    bool    `json:"timeout"`
+	Latency    int64   `json:"latency"`
+	MonoOffset int64   `json:"mono_offset"`
+	Server     string  `json:"server"`
+	Corrected  int64   `json:"corrected_latency"`
+	Key        *string `json:"key,omitempty"`
+	ValueBytes *int64  `json:"value_bytes,omitempty"`
+	Warmup     *bool   `json:"warmup,omitempty"`
+}
+
+// rawJSON formats one raw record as a line of JSON, see rawRow.
+func rawJSON(cid int, btype BenchType, run int, opid int64, latency BenchLatency, keys bool, warmup bool) string {
+	rec := rawRecord{
+		ClientID:   cid,
+		BenchType:  btype.String(),
+		Run:        run,
+		Time:       latency.Start.UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		OpID:       opid,
+		Error:      latency.Latency < 0,
+		Timeout:    latency.TimedOut,
+		Latency:    latency.Elapsed().Nanoseconds(),
+		MonoOffset: latency.Start.Sub(clockBase).Nanoseconds(),
+		Server:     latency.Server,
+		Corrected:  (latency.Elapsed() + latency.Queued).Nanoseconds(),
+	}
+	if keys {
+		rec.Key, rec.ValueBytes = &latency.Key, &latency.ValueBytes
+	}
+	if warmup {
+		rec.Warmup = &latency.Warmup
+	}
+	line, _ := json.Marshal(rec)
+	return string(line) + "\n"
+}
+
+// completedPerSecond maps every second since groupStartTime to the number
+// of requests of a stat that completed in it. The result must not be
+// modified, as it may be the running stats of the stat.
+func completedPerSecond(stat *BenchStat, groupStartTime time.Time) map[int]int {
+	if running := stat.running; running != nil {
+		shift := int(running.origin.Sub(groupStartTime).Seconds())
+		if shift == 0 {
+			return running.seconds
+		}
+		secondMap := make(map[int]int, len(running.seconds))
+		for second, n := range running.seconds {
+			secondMap[second+shift] += n
+		}
+		return secondMap
+	}
+	secondMap := make(map[int]int)
+	for _, latency := range stat.Latencies {
+		second := int(latency.Start.Add(latency.Latency).Sub(groupStartTime).Seconds())
+		secondMap[second] += 1
+	}
+	return secondMap
+}
+
+// secondCounts returns the number of requests of a stat that completed in
+// every second since groupStartTime.
+func secondCounts(stat *BenchStat, groupStartTime time.Time) []int {
+	secondMap := completedPerSecond(stat, groupStartTime)
+	seconds := make([]int, 0, len(secondMap))
+	for second := range secondMap {
+		seconds = append(seconds, second)
+	}
+	sort.Ints(seconds)
+	var counts []int
+	for _, second := range seconds {
+		for len(counts) < second {
+			counts = append(counts, 0)
+		}
+		counts = append(counts, secondMap[second])
+	}
+	return counts
+}
+
+// jsonClientID returns the client id of a JSON summary record, the
+// string "ALL" for the rows that merge all clients.
+func jsonClientID(id int) interface{} {
+	if id == ALL_CLIENTS {
+		return clientCol(id)
+	}
+	return id
+}
+
+// writeSummaryJSON writes the summary of a stat as a line of JSON with the
+// fields of the CSV summary. perSec is the throughput in every second,
+// nil for stats without one.
+func (self *Benchmark) writeSummaryJSON(statf *os.File, id int, server string, btype string, run int, stat *BenchStat,
+	groupStartTime time.Time, delay time.Duration, perSec []int) {
+	var mbps float64
+	if elapsed := stat.EndTime.Sub(stat.StartTime); elapsed > 0 {
+		mbps = float64(stat.BytesSent+stat.BytesReceived) / 1e6 / elapsed.Seconds()
+	}
+	var think time.Duration
+	if stat.Ops > 0 {
+		think = stat.ThinkTime / time.Duration(stat.Ops)
+	}
+	rec := summaryRecord{
+		ClientID:           jsonClientID(id),
+		BenchType:          btype,
+		Run:                run,
+		Operations:         stat.Ops,
+		Errors:             stat.Errors,
+		AverageLatency:     stat.AvgLatency.Nanoseconds(),
+		MinLatency:         stat.MinLatency.Nanoseconds(),
+		MaxLatency:         stat.MaxLatency.Nanoseconds(),
+		P99Latency:         stat.NinetyNinethLatency,
+		TotalLatency:       stat.TotalLatency.Nanoseconds(),
+		Throughput:         stat.Throughput,
+		GroupStartTime:     groupStartTime.UTC().Format("2006-01-02T15:04:05.999999Z"),
+		ThroughputEverySec: perSec,
+		BytesSent:          stat.BytesSent,
+		BytesReceived:      stat.BytesReceived,
+		MBPerSec:           mbps,
+		InjectedDelay:      delay.Nanoseconds(),
+		MeanThinkTime:      think.Nanoseconds(),
+		Jitter:             stat.Jitter().Nanoseconds(),
+		ServiceThroughput:  stat.ServiceTimeThroughput,
+		StdDevLatency:      stat.StdDevLatency.Nanoseconds(),
+		CVLatency:          stat.CVLatency(),
+		Server:             server,
+		Timeouts:           stat.Timeouts,
+		Disconnects:        stat.Disconnects,
+		Expirations:        stat.Expirations,
+		ErrAverageLatency:  stat.ErrAvgLatency.Nanoseconds(),
+		ErrMinLatency:      stat.ErrMinLatency.Nanoseconds(),
+		ErrMaxLatency:      stat.ErrMaxLatency.Nanoseconds(),
+		ErrP99Latency:      stat.ErrP99Latency.Nanoseconds(),
+	}
+	if stat.SubType != 0 {
+		rec.SubType = stat.SubType.String()
+	}
+	if stat.IntendedOps > 0 {
+		rate := achievedRate(stat.IntendedOps, stat.Ops)
+		rec.IntendedRequests, rec.AchievedRate = &stat.IntendedOps, &rate
+	}
+	if server != ALL_SERVERS {
+		rec.Endpoint = stat.MajorityServer()
+	}
+	if len(self.Percentiles) > 0 {
+		ps := make([]float64, len(self.Percentiles))
+		for i, p := range self.Percentiles {
+			ps[i] = p / 100
+		}
+		rec.Percentiles = make(map[string]int64)
+		for i, v := range stat.percentiles(ps) {
+			rec.Percentiles["p"+strconv.FormatFloat(self.Percentiles[i], 'f', -1, 64)] = v.Nanoseconds()
+		}
+		if self.ClientRate > 0 {
+			for i, v := range stat.correctedPercentiles(ps) {
+				rec.Percentiles["corrected_p"+strconv.FormatFloat(self.Percentiles[i], 'f', -1, 64)] = v.Nanoseconds()
+			}
+		}
+	}
+	json.NewEncoder(statf).Encode(rec)
+}
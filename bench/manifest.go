@@ -0,0 +1,141 @@
+package bench
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"time"
+)
+
+// Version is the build version of zkbench, set at build time with
+// -ldflags "-X github.com/OrderLab/zkbench/bench.Version=v1.2.3".
+var Version = "dev"
+
+const (
+	MANIFEST_RUNNING   = "running"
+	MANIFEST_COMPLETED = "completed"
+	MANIFEST_ABORTED   = "aborted"
+)
+
+// ManifestServer is a configured server with the addresses its endpoint
+// resolved to at the start of the run.
+type ManifestServer struct {
+	Name      string   `json:"name"`
+	Endpoint  string   `json:"endpoint"`
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// Manifest records how a run was set up, so that its results can be
+// reproduced and audited. It is written when the run starts and amended
+// when it ends, so a run that is killed is left with the status running.
+type Manifest struct {
+	Version     string           `json:"version"`
+	VCSRevision string           `json:"vcs_revision,omitempty"`
+	GoVersion   string           `json:"go_version"`
+	Hostname    string           `json:"hostname"`
+	Args        []string         `json:"args"`
+	Servers     []ManifestServer `json:"servers"`
+	Config      *BenchConfig     `json:"config"`
+	StartTime   time.Time        `json:"start_time"`
+	EndTime     *time.Time       `json:"end_time,omitempty"`
+	Status      string           `json:"status"`
+	ExitCode    *int             `json:"exit_code,omitempty"`
+}
+
+// newManifest captures the config and the environment of a run starting
+// now, resolving the endpoints of the servers.
+func newManifest(config *BenchConfig) *Manifest {
+	hostname, _ := os.Hostname()
+	m := &Manifest{
+		Version:     Version,
+		VCSRevision: vcsRevision(),
+		GoVersion:   runtime.Version(),
+		Hostname:    hostname,
+		Args:        os.Args,
+		Config:      config,
+		StartTime:   time.Now(),
+		Status:      MANIFEST_RUNNING,
+	}
+	for i, endpoint := range config.Endpoints {
+		server := ManifestServer{Endpoint: endpoint}
+		if i < len(config.Servers) {
+			server.Name = config.Servers[i]
+		}
+		if host, _, err := net.SplitHostPort(endpoint); err == nil {
+			server.Addresses, _ = net.LookupHost(host)
+		}
+		m.Servers = append(m.Servers, server)
+	}
+	return m
+}
+
+// LoadManifest reads the manifest at path.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := new(Manifest)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Write replaces the manifest at path. The file is renamed into place, so
+// an abort in the middle leaves the previous version.
+func (self *Manifest) Write(path string) error {
+	data, err := json.MarshalIndent(self, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// finish records the end of the run with its status.
+func (self *Manifest) finish(status string) {
+	now := time.Now()
+	self.EndTime = &now
+	self.Status = status
+}
+
+// SetManifestExitCode amends the manifest at path with the exit status of
+// the process, once all of its runs are done.
+func SetManifestExitCode(path string, code int) error {
+	m, err := LoadManifest(path)
+	if err != nil {
+		return err
+	}
+	m.ExitCode = &code
+	return m.Write(path)
+}
+
+// startManifest writes the manifest of a run to outprefix+"manifest.json".
+// Non-stop iterations after the first amend the manifest of the first.
+func (self *Benchmark) startManifest(fresh bool) *Manifest {
+	path := self.outprefix + "manifest.json"
+	m := newManifest(&self.BenchConfig)
+	if !fresh {
+		if prev, err := LoadManifest(path); err == nil {
+			m.StartTime = prev.StartTime
+		}
+	}
+	if err := m.Write(path); err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// finishManifest amends the manifest of a run with its end.
+func (self *Benchmark) finishManifest(m *Manifest, status string) {
+	m.finish(status)
+	if err := m.Write(self.outprefix + "manifest.json"); err != nil {
+		log.Printf("[Bench]: failed to write the manifest: %v\n", err)
+	}
+}
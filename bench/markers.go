@@ -0,0 +1,46 @@
+package bench
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// MarkerLog records phase boundaries signalled from outside the benchmark,
+// e.g. the start and end of an ensemble reconfig triggered by a script, so
+// the latency timeline of the raw and summary files can be annotated with
+// the window in which it happened.
+type MarkerLog struct {
+	mutex sync.Mutex
+	f     *os.File
+}
+
+func OpenMarkerLog(path string) (*MarkerLog, error) {
+	// an invocation replaces the markers of an earlier one
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		f.WriteString("time,event\n")
+	}
+	return &MarkerLog{f: f}, nil
+}
+
+// Mark records that event happened now. The time has the format of the
+// raw file's time column.
+func (self *MarkerLog) Mark(event string) {
+	now := time.Now()
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.f.WriteString(fmt.Sprintf("%s,%s\n", now.UTC().Format("2006-01-02T15:04:05.000Z07:00"), event))
+	log.Printf("[Bench]: marker %s\n", event)
+}
+
+func (self *MarkerLog) Close() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.f.Close()
+}
@@ -0,0 +1,388 @@
+package bench
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// MemStore is a minimal in-memory ZooKeeper data tree. Connections opened
+// with Connect share the tree like sessions of one ensemble do. It is meant
+// for exercising the benchmark logic without a real ensemble, not for
+// measuring anything.
+type MemStore struct {
+	mutex   sync.Mutex
+	nodes   map[string]*memNode
+	zxid    int64
+	nextSeq map[string]int32
+	// exists watches set by ExistsW on znodes that do not exist yet
+	pending map[string][]chan zk.Event
+	session int64 // id of the last session
+}
+
+type memNode struct {
+	data     []byte
+	stat     zk.Stat
+	children map[string]bool
+	watches  []chan zk.Event // data watches set by GetW/ExistsW
+	cwatches []chan zk.Event // child watches set by ChildrenW
+}
+
+func NewMemStore() *MemStore {
+	store := &MemStore{
+		nodes:   make(map[string]*memNode),
+		nextSeq: make(map[string]int32),
+		pending: make(map[string][]chan zk.Event),
+	}
+	store.nodes["/"] = &memNode{children: make(map[string]bool)}
+	return store
+}
+
+// Connect opens a new session on the store.
+func (self *MemStore) Connect() *MemConn {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.session++
+	return &MemConn{store: self, session: self.session}
+}
+
+// MemConn is a session on a MemStore. It implements ZKConn.
+type MemConn struct {
+	store   *MemStore
+	session int64
+	closed  bool
+}
+
+func fireWatches(watches []chan zk.Event, etype zk.EventType, p string) {
+	for _, ch := range watches {
+		ch <- zk.Event{Type: etype, State: zk.StateHasSession, Path: p}
+		close(ch)
+	}
+}
+
+func newWatch() chan zk.Event {
+	return make(chan zk.Event, 1)
+}
+
+func (self *MemConn) lock() error {
+	self.store.mutex.Lock()
+	if self.closed {
+		self.store.mutex.Unlock()
+		return zk.ErrConnectionClosed
+	}
+	return nil
+}
+
+func (self *MemConn) Get(p string) ([]byte, *zk.Stat, error) {
+	data, stat, _, err := self.get(p, false)
+	return data, stat, err
+}
+
+func (self *MemConn) GetW(p string) ([]byte, *zk.Stat, <-chan zk.Event, error) {
+	return self.get(p, true)
+}
+
+func (self *MemConn) get(p string, watch bool) ([]byte, *zk.Stat, <-chan zk.Event, error) {
+	if err := self.lock(); err != nil {
+		return nil, nil, nil, err
+	}
+	defer self.store.mutex.Unlock()
+	node, ok := self.store.nodes[p]
+	if !ok {
+		return nil, nil, nil, zk.ErrNoNode
+	}
+	var ch chan zk.Event
+	if watch {
+		ch = newWatch()
+		node.watches = append(node.watches, ch)
+	}
+	stat := node.stat
+	return append([]byte{}, node.data...), &stat, ch, nil
+}
+
+func (self *MemConn) Set(p string, data []byte, version int32) (*zk.Stat, error) {
+	if err := self.lock(); err != nil {
+		return nil, err
+	}
+	defer self.store.mutex.Unlock()
+	return self.store.set(p, data, version)
+}
+
+func (self *MemStore) set(p string, data []byte, version int32) (*zk.Stat, error) {
+	node, ok := self.nodes[p]
+	if !ok {
+		return nil, zk.ErrNoNode
+	}
+	if version != -1 && version != node.stat.Version {
+		return nil, zk.ErrBadVersion
+	}
+	self.zxid++
+	node.data = append([]byte{}, data...)
+	node.stat.Version++
+	node.stat.Mzxid = self.zxid
+	node.stat.Mtime = time.Now().UnixNano() / int64(time.Millisecond)
+	node.stat.DataLength = int32(len(data))
+	fireWatches(node.watches, zk.EventNodeDataChanged, p)
+	node.watches = nil
+	stat := node.stat
+	return &stat, nil
+}
+
+func (self *MemConn) Create(p string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	if err := self.lock(); err != nil {
+		return "", err
+	}
+	defer self.store.mutex.Unlock()
+	return self.store.create(p, data, flags, self.session)
+}
+
+// create adds a znode, which is owned by session if it is ephemeral.
+func (self *MemStore) create(p string, data []byte, flags int32, session int64) (string, error) {
+	if !memPath(p) || p == "/" {
+		return "", zk.ErrInvalidPath
+	}
+	parent, ok := self.nodes[path.Dir(p)]
+	if !ok {
+		return "", zk.ErrNoNode
+	}
+	if flags&zk.FlagSequence != 0 {
+		seq := self.nextSeq[path.Dir(p)]
+		self.nextSeq[path.Dir(p)] = seq + 1
+		p = fmt.Sprintf("%s%010d", p, seq)
+	}
+	if _, ok := self.nodes[p]; ok {
+		return "", zk.ErrNodeExists
+	}
+	self.zxid++
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	node := &memNode{data: append([]byte{}, data...), children: make(map[string]bool)}
+	node.stat = zk.Stat{Czxid: self.zxid, Mzxid: self.zxid, Ctime: now, Mtime: now,
+		DataLength: int32(len(data))}
+	if flags&zk.FlagEphemeral != 0 {
+		node.stat.EphemeralOwner = session
+	}
+	self.nodes[p] = node
+	fireWatches(self.pending[p], zk.EventNodeCreated, p)
+	delete(self.pending, p)
+	parent.children[path.Base(p)] = true
+	parent.stat.NumChildren++
+	parent.stat.Cversion++
+	fireWatches(parent.cwatches, zk.EventNodeChildrenChanged, path.Dir(p))
+	parent.cwatches = nil
+	return p, nil
+}
+
+func (self *MemConn) Delete(p string, version int32) error {
+	if err := self.lock(); err != nil {
+		return err
+	}
+	defer self.store.mutex.Unlock()
+	return self.store.delete(p, version)
+}
+
+func (self *MemStore) delete(p string, version int32) error {
+	node, ok := self.nodes[p]
+	if !ok || p == "/" {
+		return zk.ErrNoNode
+	}
+	if version != -1 && version != node.stat.Version {
+		return zk.ErrBadVersion
+	}
+	if len(node.children) > 0 {
+		return zk.ErrNotEmpty
+	}
+	self.zxid++
+	delete(self.nodes, p)
+	parent := self.nodes[path.Dir(p)]
+	delete(parent.children, path.Base(p))
+	parent.stat.NumChildren--
+	parent.stat.Cversion++
+	fireWatches(node.watches, zk.EventNodeDeleted, p)
+	fireWatches(node.cwatches, zk.EventNodeDeleted, p)
+	fireWatches(parent.cwatches, zk.EventNodeChildrenChanged, path.Dir(p))
+	parent.cwatches = nil
+	return nil
+}
+
+func (self *MemConn) Children(p string) ([]string, *zk.Stat, error) {
+	children, stat, _, err := self.children(p, false)
+	return children, stat, err
+}
+
+func (self *MemConn) ChildrenW(p string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	return self.children(p, true)
+}
+
+func (self *MemConn) children(p string, watch bool) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	if err := self.lock(); err != nil {
+		return nil, nil, nil, err
+	}
+	defer self.store.mutex.Unlock()
+	node, ok := self.store.nodes[p]
+	if !ok {
+		return nil, nil, nil, zk.ErrNoNode
+	}
+	var ch chan zk.Event
+	if watch {
+		ch = newWatch()
+		node.cwatches = append(node.cwatches, ch)
+	}
+	children := make([]string, 0, len(node.children))
+	for child := range node.children {
+		children = append(children, child)
+	}
+	sort.Strings(children)
+	stat := node.stat
+	return children, &stat, ch, nil
+}
+
+func (self *MemConn) Exists(p string) (bool, *zk.Stat, error) {
+	if err := self.lock(); err != nil {
+		return false, nil, err
+	}
+	defer self.store.mutex.Unlock()
+	node, ok := self.store.nodes[p]
+	if !ok {
+		return false, nil, nil
+	}
+	stat := node.stat
+	return true, &stat, nil
+}
+
+// ExistsW sets a watch that fires when the znode is created if it does not
+// exist, or else when its data changes or it is deleted.
+func (self *MemConn) ExistsW(p string) (bool, *zk.Stat, <-chan zk.Event, error) {
+	if err := self.lock(); err != nil {
+		return false, nil, nil, err
+	}
+	defer self.store.mutex.Unlock()
+	ch := newWatch()
+	node, ok := self.store.nodes[p]
+	if !ok {
+		self.store.pending[p] = append(self.store.pending[p], ch)
+		return false, nil, ch, nil
+	}
+	node.watches = append(node.watches, ch)
+	stat := node.stat
+	return true, &stat, ch, nil
+}
+
+func (self *MemConn) Sync(p string) (string, error) {
+	if err := self.lock(); err != nil {
+		return "", err
+	}
+	defer self.store.mutex.Unlock()
+	if _, ok := self.store.nodes[p]; !ok {
+		return "", zk.ErrNoNode
+	}
+	return p, nil
+}
+
+func (self *MemConn) AddAuth(scheme string, auth []byte) error {
+	if err := self.lock(); err != nil {
+		return err
+	}
+	self.store.mutex.Unlock()
+	return nil
+}
+
+// Multi applies all ops or, if one of them fails, none of them.
+func (self *MemConn) Multi(ops ...interface{}) ([]zk.MultiResponse, error) {
+	if err := self.lock(); err != nil {
+		return nil, err
+	}
+	defer self.store.mutex.Unlock()
+	snapshot := self.store.snapshot()
+	res := make([]zk.MultiResponse, len(ops))
+	for i, op := range ops {
+		var err error
+		switch req := op.(type) {
+		case *zk.CreateRequest:
+			res[i].String, err = self.store.create(req.Path, req.Data, req.Flags, self.session)
+		case *zk.SetDataRequest:
+			res[i].Stat, err = self.store.set(req.Path, req.Data, req.Version)
+		case *zk.DeleteRequest:
+			err = self.store.delete(req.Path, req.Version)
+		case *zk.CheckVersionRequest:
+			node, ok := self.store.nodes[req.Path]
+			if !ok {
+				err = zk.ErrNoNode
+			} else if req.Version != -1 && req.Version != node.stat.Version {
+				err = zk.ErrBadVersion
+			}
+		default:
+			return nil, fmt.Errorf("unknown operation type %T", op)
+		}
+		if err != nil {
+			res[i].Error = err
+			self.store.restore(snapshot)
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// Close ends the session and removes its ephemeral znodes.
+func (self *MemConn) Close() {
+	self.store.mutex.Lock()
+	defer self.store.mutex.Unlock()
+	if self.closed {
+		return
+	}
+	self.closed = true
+	for p, node := range self.store.nodes {
+		if node.stat.EphemeralOwner == self.session {
+			self.store.delete(p, -1)
+		}
+	}
+}
+
+type memSnapshot struct {
+	nodes   map[string]memNode
+	zxid    int64
+	nextSeq map[string]int32
+}
+
+// snapshot copies the tree so a failed multi can be rolled back. Pending
+// watches are not part of it, so watches fired by a rolled back op stay
+// fired.
+func (self *MemStore) snapshot() *memSnapshot {
+	snap := &memSnapshot{
+		nodes:   make(map[string]memNode, len(self.nodes)),
+		zxid:    self.zxid,
+		nextSeq: make(map[string]int32, len(self.nextSeq)),
+	}
+	for p, node := range self.nodes {
+		copied := *node
+		copied.children = make(map[string]bool, len(node.children))
+		for child := range node.children {
+			copied.children[child] = true
+		}
+		snap.nodes[p] = copied
+	}
+	for p, seq := range self.nextSeq {
+		snap.nextSeq[p] = seq
+	}
+	return snap
+}
+
+func (self *MemStore) restore(snap *memSnapshot) {
+	self.nodes = make(map[string]*memNode, len(snap.nodes))
+	for p, node := range snap.nodes {
+		node := node
+		node.watches, node.cwatches = nil, nil
+		self.nodes[p] = &node
+	}
+	self.zxid = snap.zxid
+	self.nextSeq = snap.nextSeq
+}
+
+// memPath reports whether p is a well-formed absolute znode path.
+func memPath(p string) bool {
+	return strings.HasPrefix(p, "/") && (p == "/" || !strings.HasSuffix(p, "/"))
+}
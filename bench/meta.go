@@ -0,0 +1,57 @@
+package bench
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"runtime"
+	"time"
+)
+
+// RunMeta describes where and how a set of results was produced, so that
+// archived results stay self-describing.
+type RunMeta struct {
+	Hostname   string    `json:"hostname"`
+	OS         string    `json:"os"`
+	Arch       string    `json:"arch"`
+	NumCPU     int       `json:"num_cpu"`
+	GoVersion  string    `json:"go_version"`
+	Args       []string  `json:"args"`
+	ConfigPath string    `json:"config_path"`
+	ConfigHash string    `json:"config_hash"` // sha256 of the resolved config
+	StartTime  time.Time `json:"start_time"`
+	EndTime    time.Time `json:"end_time"`
+	Duration   string    `json:"duration"`
+}
+
+// NewRunMeta captures the host and runtime info at the start of a run.
+func NewRunMeta(path string, config *BenchConfig) *RunMeta {
+	hostname, _ := os.Hostname()
+	meta := &RunMeta{
+		Hostname:   hostname,
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		NumCPU:     runtime.NumCPU(),
+		GoVersion:  runtime.Version(),
+		Args:       os.Args,
+		ConfigPath: path,
+		StartTime:  time.Now(),
+	}
+	if data, err := json.Marshal(config); err == nil {
+		sum := sha256.Sum256(data)
+		meta.ConfigHash = hex.EncodeToString(sum[:])
+	}
+	return meta
+}
+
+// Write records the end of the run and writes the metadata as JSON.
+func (self *RunMeta) Write(path string) error {
+	self.EndTime = time.Now()
+	self.Duration = self.EndTime.Sub(self.StartTime).String()
+	data, err := json.MarshalIndent(self, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,162 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metricCounters are the live counters of the requests of one client in
+// one bench type. They are updated with atomics from the request loop.
+type metricCounters struct {
+	ops       int64
+	errors    int64
+	latencyNs int64 // summed latency of the successful requests
+}
+
+type metricKey struct {
+	client int
+	btype  string
+}
+
+// metricsServer exposes the live counters of a benchmark in the Prometheus
+// text format on /metrics while it runs. The throughput over all clients
+// is sampled every second.
+type metricsServer struct {
+	mutex      sync.Mutex
+	counters   map[metricKey]*metricCounters
+	throughput uint64 // float64 bits, requests per second
+	server     *http.Server
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// startMetrics listens on addr and serves /metrics until Close.
+func startMetrics(addr string) (*metricsServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	self := &metricsServer{
+		counters: make(map[metricKey]*metricCounters),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", self.serve)
+	self.server = &http.Server{Handler: mux}
+	go func() {
+		if err := self.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("[Bench]: metrics server failed: %v\n", err)
+		}
+	}()
+	go self.sample()
+	log.Printf("[Bench]: serving metrics on http://%s/metrics\n", listener.Addr())
+	return self, nil
+}
+
+// of returns the counters of a client in a bench type, for the request
+// loop to look up once rather than for every request.
+func (self *metricsServer) of(client int, btype BenchType) *metricCounters {
+	key := metricKey{client, btype.String()}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	c, ok := self.counters[key]
+	if !ok {
+		c = &metricCounters{}
+		self.counters[key] = c
+	}
+	return c
+}
+
+func (self *metricCounters) add(d time.Duration, err error) {
+	atomic.AddInt64(&self.ops, 1)
+	if err != nil {
+		atomic.AddInt64(&self.errors, 1)
+	} else {
+		atomic.AddInt64(&self.latencyNs, d.Nanoseconds())
+	}
+}
+
+func (self *metricsServer) totalOps() int64 {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	var ops int64
+	for _, c := range self.counters {
+		ops += atomic.LoadInt64(&c.ops)
+	}
+	return ops
+}
+
+func (self *metricsServer) sample() {
+	defer close(self.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	last, lastTime := self.totalOps(), time.Now()
+	for {
+		select {
+		case <-self.stop:
+			return
+		case now := <-ticker.C:
+			ops := self.totalOps()
+			rate := float64(ops-last) / now.Sub(lastTime).Seconds()
+			atomic.StoreUint64(&self.throughput, math.Float64bits(rate))
+			last, lastTime = ops, now
+		}
+	}
+}
+
+func (self *metricsServer) serve(w http.ResponseWriter, r *http.Request) {
+	self.mutex.Lock()
+	keys := make([]metricKey, 0, len(self.counters))
+	for key := range self.counters {
+		keys = append(keys, key)
+	}
+	counters := make([]*metricCounters, len(keys))
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].btype != keys[j].btype {
+			return keys[i].btype < keys[j].btype
+		}
+		return keys[i].client < keys[j].client
+	})
+	for i, key := range keys {
+		counters[i] = self.counters[key]
+	}
+	self.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP zkbench_requests_total Requests issued.\n# TYPE zkbench_requests_total counter\n")
+	for i, key := range keys {
+		fmt.Fprintf(w, "zkbench_requests_total{client=\"%d\",bench_type=\"%s\"} %d\n", key.client, key.btype, atomic.LoadInt64(&counters[i].ops))
+	}
+	fmt.Fprintf(w, "# HELP zkbench_errors_total Requests that failed.\n# TYPE zkbench_errors_total counter\n")
+	for i, key := range keys {
+		fmt.Fprintf(w, "zkbench_errors_total{client=\"%d\",bench_type=\"%s\"} %d\n", key.client, key.btype, atomic.LoadInt64(&counters[i].errors))
+	}
+	fmt.Fprintf(w, "# HELP zkbench_latency_seconds Latency of the successful requests.\n# TYPE zkbench_latency_seconds summary\n")
+	for i, key := range keys {
+		ok := atomic.LoadInt64(&counters[i].ops) - atomic.LoadInt64(&counters[i].errors)
+		fmt.Fprintf(w, "zkbench_latency_seconds_sum{client=\"%d\",bench_type=\"%s\"} %g\n", key.client, key.btype,
+			time.Duration(atomic.LoadInt64(&counters[i].latencyNs)).Seconds())
+		fmt.Fprintf(w, "zkbench_latency_seconds_count{client=\"%d\",bench_type=\"%s\"} %d\n", key.client, key.btype, ok)
+	}
+	fmt.Fprintf(w, "# HELP zkbench_throughput Requests per second over all clients in the last second.\n# TYPE zkbench_throughput gauge\n")
+	fmt.Fprintf(w, "zkbench_throughput %g\n", math.Float64frombits(atomic.LoadUint64(&self.throughput)))
+}
+
+// Close stops sampling and shuts down the server, waiting up to a second
+// for scrapes in progress.
+func (self *metricsServer) Close() error {
+	close(self.stop)
+	<-self.done
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return self.server.Shutdown(ctx)
+}
@@ -0,0 +1,61 @@
+package bench
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// modelGap is the ratio of achieved to model throughput below which a run
+// is flagged: the clients then spend a noticeable share of the time outside
+// of requests, i.e. the load generator rather than the server is the limit.
+const modelGap = 0.8
+
+// dumpModel compares the achieved throughput of a bench run with the one
+// Little's law predicts for a closed loop: every client keeps outstanding
+// requests in flight, so it completes outstanding/(latency+delay) requests
+// per second. With a client_rate, the model is only an upper bound.
+func (self *Benchmark) dumpModel(btype BenchType, run int, outstanding int) {
+	var ops, errors int64
+	var start, end time.Time
+	var model float64
+	var latency time.Duration
+	for _, client := range self.clients {
+		stat := client.Stat
+		if stat == nil || stat.Ops == 0 {
+			continue
+		}
+		if ops == 0 || stat.StartTime.Before(start) {
+			start = stat.StartTime
+		}
+		if stat.EndTime.After(end) {
+			end = stat.EndTime
+		}
+		latency += time.Duration(stat.Ops) * stat.AvgLatency
+		ops += stat.Ops
+		errors += stat.Errors
+		if r := stat.AvgLatency + client.Delay; r > 0 {
+			model += float64(outstanding) / r.Seconds()
+		}
+	}
+	if ops == 0 || !end.After(start) || model == 0 {
+		return
+	}
+	achieved := float64(ops-errors) / end.Sub(start).Seconds()
+	ratio := achieved / model
+
+	mf, err := self.openOutput("model.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer mf.Close()
+	if info, err := mf.Stat(); err == nil && info.Size() == 0 {
+		mf.WriteString("bench_type,run,clients,outstanding_per_client,average_latency,achieved_throughput,model_throughput,ratio\n")
+	}
+	mf.WriteString(fmt.Sprintf("%s,%d,%d,%d,%d,%f,%f,%f\n", btype.String(), run, len(self.clients), outstanding,
+		(latency / time.Duration(ops)).Nanoseconds(), achieved, model, ratio))
+	if ratio < modelGap {
+		log.Printf("[Bench]: %s.%d achieved %f req/s, only %.0f%% of the %f req/s its latency allows; the clients may be the bottleneck\n",
+			btype.String(), run, achieved, ratio*100, model)
+	}
+}
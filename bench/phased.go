@@ -0,0 +1,253 @@
+package bench
+
+import (
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// MixPhase is one segment of a phased operation mix: for Duration after the
+// previous segment ends, MIXED requests are reads with probability ReadRatio
+// and writes otherwise.
+type MixPhase struct {
+	Duration  time.Duration
+	ReadRatio float64
+}
+
+// parsePhasedMix parses a phased mix spec of the form
+// "<duration>:<read_ratio>,<duration>:<read_ratio>,...", e.g. "30s:0.1,60s:0.9".
+func parsePhasedMix(spec string) ([]MixPhase, error) {
+	var phases []MixPhase
+	for _, seg := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(seg), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Phased mix segment '%s' must be <duration>:<read_ratio>\n", seg)
+		}
+		d, err := time.ParseDuration(parts[0])
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("Invalid duration in phased mix segment '%s'\n", seg)
+		}
+		ratio, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("Read ratio in phased mix segment '%s' must be within [0, 1]\n", seg)
+		}
+		phases = append(phases, MixPhase{Duration: d, ReadRatio: ratio})
+	}
+	return phases, nil
+}
+
+// phaseAt returns the index of the segment active after elapsed time since
+// the start of the run, or -1 if all segments are over.
+func phaseAt(phases []MixPhase, elapsed time.Duration) int {
+	var end time.Duration
+	for i, phase := range phases {
+		end += phase.Duration
+		if elapsed < end {
+			return i
+		}
+	}
+	return -1
+}
+
+// runPhasedMix runs the MIXED benchmark with an operation mix that changes
+// over time according to PhasedMix. Every client issues requests until the
+// last segment ends, and each request picks read or write by the ratio of
+// the segment it starts in. Per-segment stats go to the segments file.
+// The requests are accounted and reported like those of processRequests,
+// with the warm-up counted from the start of the run over all segments.
+func (self *Benchmark) runPhasedMix(run int, statf *os.File) {
+	ctx := self.context()
+	var wg sync.WaitGroup
+
+	src := mrand.NewSource(time.Now().UnixNano())
+	key := sameKey(self.KeySizeBytes)
+	val := randBytes(src, self.ValueSizeBytes)
+	workers := self.Parallelism
+
+	// segstats[client][segment] holds a READ and a WRITE stat
+	segstats := make([][][2]*BenchStat, len(self.clients))
+	groupStartTime := time.Now()
+	self.runStart = groupStartTime
+	defer func() { self.runStart = time.Time{} }()
+	for i, client := range self.clients {
+		client.Stat = nil
+		segstats[i] = make([][2]*BenchStat, len(self.PhasedMix))
+		for s := range self.PhasedMix {
+			segstats[i][s][0] = &BenchStat{OpType: fmt.Sprintf("MIXED.SEG%d.READ.%d", s+1, run), BenchType: MIXED, SubType: READ, Run: run}
+			segstats[i][s][1] = &BenchStat{OpType: fmt.Sprintf("MIXED.SEG%d.WRITE.%d", s+1, run), BenchType: MIXED, SubType: WRITE, Run: run}
+			for _, stat := range segstats[i][s] {
+				self.prepareStat(stat, 0)
+			}
+		}
+		if workers > 1 {
+			client.AddChildren(workers)
+		}
+		wg.Add(1)
+		go func(client *Client, stats [][2]*BenchStat) {
+			defer wg.Done()
+			var mutex sync.Mutex
+			var cwg sync.WaitGroup
+			// only tracks the bytes and, for the warm-up, the requests of
+			// all segments
+			var traffic BenchStat
+			client.Log("start bench MIXED.%d with %d phases", run, len(self.PhasedMix))
+			start := time.Now()
+			for w := 0; w < workers; w++ {
+				c := client
+				if workers > 1 {
+					if c = client.GetChild(w); c == nil {
+						c = client
+					}
+				}
+				cwg.Add(1)
+				go func(c *Client, w int) {
+					defer cwg.Done()
+					sent, received := c.BytesTransferred()
+					defer func() {
+						s, r := c.BytesTransferred()
+						mutex.Lock()
+						traffic.addBytes(s-sent, r-received, 0, 0)
+						mutex.Unlock()
+					}()
+					rd := mrand.New(mrand.NewSource(time.Now().UnixNano() + int64(w)))
+					reporter := self.newRequestReporter(c, MIXED, run)
+					// with a rate limit, the workers of a client share its
+					// rate and issue requests on a fixed schedule
+					var interval time.Duration
+					if self.ClientRate > 0 {
+						interval = time.Duration(float64(time.Second) * float64(workers) / self.ClientRate)
+					}
+					for iter, n := int64(w), 0; ; iter, n = iter+int64(workers), n+1 {
+						var scheduled time.Time
+						if interval > 0 {
+							scheduled = start.Add(time.Duration(n) * interval)
+							time.Sleep(time.Until(scheduled))
+						}
+						seg := phaseAt(self.PhasedMix, time.Since(start))
+						if seg < 0 {
+							break
+						}
+						rkey := key
+						if !self.SameKey {
+							rkey = self.keyAt(iter % self.NRequests)
+						}
+						op := 1
+						if rd.Float64() < self.PhasedMix[seg].ReadRatio {
+							op = 0
+						}
+						rval := val
+						if op == 1 && self.RegenerateValues {
+							rval = randBytes(rd, self.ValueSizeBytes)
+						}
+						if c.Delay > 0 {
+							time.Sleep(c.Delay) // simulated network delay, not measured
+						}
+						var err error
+						var size int
+						begin := time.Now()
+						if op == 0 {
+							var data []byte
+							var stat *zk.Stat
+							data, stat, err = c.Read(ctx, rkey)
+							size = len(data)
+							if err == nil && self.zxids != nil {
+								self.zxids.sample(c, MIXED, run, rkey, stat)
+							}
+						} else {
+							err = c.Write(ctx, rkey, rval)
+							size = len(rval)
+						}
+						d := time.Since(begin)
+						out := &requestOutcome{key: rkey, valueBytes: int64(size), scheduled: scheduled, begin: begin, d: d, err: err}
+						mutex.Lock()
+						stat := stats[seg][op]
+						// the warm-up is counted over the segments
+						warm := self.warmingUp(&traffic, begin)
+						if warm {
+							traffic.WarmupOps++
+						} else {
+							traffic.Ops++
+							if stat.StartTime.IsZero() || begin.Before(stat.StartTime) {
+								stat.StartTime = begin
+							}
+							if end := begin.Add(d); end.After(stat.EndTime) {
+								stat.EndTime = end
+							}
+						}
+						latency := self.recordRequest(stat, c, -1, warm, out)
+						mutex.Unlock()
+						reporter.report(iter, statLabel(MIXED, stat.SubType, run), latency, err)
+					}
+				}(c, w)
+			}
+			cwg.Wait()
+			if workers > 1 {
+				client.CloseChildren()
+			}
+			for s := range stats {
+				for _, stat := range stats[s] {
+					stat.excludeWarmup()
+					stat.finish()
+					if stat.Ops == 0 {
+						continue
+					}
+					if client.Stat == nil {
+						merged := *stat
+						merged.SubType = 0
+						merged.OpType = merged.Label()
+						client.Stat = &merged
+					} else {
+						client.Stat.Merge(stat)
+					}
+				}
+			}
+			if client.Stat == nil {
+				client.Stat = &BenchStat{OpType: statLabel(MIXED, 0, run), BenchType: MIXED, Run: run}
+			}
+			if self.ClientRate > 0 {
+				client.Stat.IntendedOps = intendedOps(self.ClientRate, time.Since(start))
+			}
+			client.Stat.addBytes(traffic.BytesSent, traffic.BytesReceived, client.Stat.Ops, self.ProtocolOverhead)
+			client.Log("done bench MIXED.%d", run)
+		}(client, segstats[i])
+	}
+	wg.Wait()
+
+	self.dumpStats(MIXED, run, groupStartTime, statf)
+	self.dumpSegmentStats(run, segstats)
+}
+
+// dumpSegmentStats appends the per-segment stats of a phased mix run to
+// the segments file, truncated by the first run of a fresh Run. Failing to
+// open it only loses the segments, the summary has the run already.
+func (self *Benchmark) dumpSegmentStats(run int, segstats [][][2]*BenchStat) {
+	segf, err := self.openOutput("segments.dat")
+	if err != nil {
+		log.Printf("[Bench]: fail to open segments file: %v\n", err)
+		return
+	}
+	defer segf.Close()
+	if info, err := segf.Stat(); err == nil && info.Size() == 0 {
+		segf.WriteString("client_id,run,segment,segment_duration,read_ratio,op_type,operations,errors,average_latency,99th_latency,throughput\n")
+	}
+	for i, client := range self.clients {
+		for s, phase := range self.PhasedMix {
+			for op, stat := range segstats[i][s] {
+				var optype BenchType = READ
+				if op == 1 {
+					optype = WRITE
+				}
+				segf.WriteString(fmt.Sprintf("%d,%d,%d,%s,%f,%s,%d,%d,%d,%d,%f\n", client.Id, run, s+1,
+					phase.Duration.String(), phase.ReadRatio, optype.String(), stat.Ops, stat.Errors,
+					stat.AvgLatency.Nanoseconds(), stat.NinetyNinethLatency, stat.Throughput))
+			}
+		}
+	}
+}
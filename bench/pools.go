@@ -0,0 +1,66 @@
+package bench
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+// readPoolSize returns how many clients form the read pool when MIXED runs
+// with asymmetric pools. The first clients read and the rest write; each
+// pool keeps at least one client if there are two or more.
+func (self *Benchmark) readPoolSize() int {
+	n := len(self.clients)
+	readers := int(math.Round(self.ReadPoolFraction * float64(n)))
+	if n >= 2 {
+		if readers < 1 {
+			readers = 1
+		}
+		if readers > n-1 {
+			readers = n - 1
+		}
+	}
+	return readers
+}
+
+// dumpPoolStats appends the combined stats of the read and the write pool
+// of a MIXED run to the pools file. The throughput is that of the whole
+// pool over its wall-clock time.
+func (self *Benchmark) dumpPoolStats(run int, readers int) {
+	pf, err := self.openOutput("pools.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer pf.Close()
+	if info, err := pf.Stat(); err == nil && info.Size() == 0 {
+		pf.WriteString("run,pool,clients,operations,errors,average_latency,max_latency,99th_latency,throughput" + self.percentileHeader() + "\n")
+	}
+	pools := [][]*Client{self.clients[:readers], self.clients[readers:]}
+	for i, name := range []string{"READ", "WRITE"} {
+		var pool BenchStat
+		for _, client := range pools[i] {
+			if client.Stat == nil || client.Stat.Ops == 0 {
+				continue
+			}
+			if pool.Ops == 0 {
+				pool = *client.Stat
+				pool.Latencies = append([]BenchLatency(nil), client.Stat.Latencies...)
+			} else {
+				pool.Merge(client.Stat)
+			}
+		}
+		if pool.Ops == 0 {
+			continue
+		}
+		pool.ComputePercentiles()
+		var throughput float64
+		if elapsed := pool.EndTime.Sub(pool.StartTime); elapsed > 0 {
+			throughput = float64(pool.Ops-pool.Errors) / elapsed.Seconds()
+		}
+		pf.WriteString(fmt.Sprintf("%d,%s,%d,%d,%d,%d,%d,%d,%f%s\n", run, name, len(pools[i]), pool.Ops, pool.Errors,
+			pool.AvgLatency.Nanoseconds(), pool.MaxLatency.Nanoseconds(), pool.NinetyNinethLatency, throughput,
+			self.percentileCols(&pool)))
+		log.Printf("[Bench]: MIXED.%d %s pool of %d clients: avg latency %s, throughput %f req/s\n",
+			run, name, len(pools[i]), pool.AvgLatency, throughput)
+	}
+}
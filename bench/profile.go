@@ -0,0 +1,125 @@
+package bench
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	zkc "github.com/OrderLab/zkbench/config"
+)
+
+// Profile is a named benchmark target with its own servers, e.g. a
+// standalone server and a 3-node ensemble, configured in a section like
+//
+//	[profile.ensemble]
+//	server.0 = node0:2181
+//	server.1 = node1:2181
+type Profile struct {
+	Name      string
+	Servers   []string
+	Endpoints []string
+}
+
+// RunResult is the cluster-wide result of one bench run, merged across all
+// clients. Latencies are dropped after the percentile is computed.
+type RunResult struct {
+	Type       BenchType
+	Run        int
+	Clients    int
+	Stat       BenchStat
+	Throughput float64 // sum of the client throughputs
+}
+
+func parseProfiles(config *zkc.Config) ([]Profile, error) {
+	names := make(map[string]bool)
+	for _, key := range config.GetKeys("profile.") {
+		parts := strings.SplitN(key, ".", 3)
+		if len(parts) == 3 {
+			names[parts[1]] = true
+		}
+	}
+	var profiles []Profile
+	for name := range names {
+		prefix := "profile." + name + "."
+		servers := config.GetKeys(prefix + "server")
+		if len(servers) == 0 {
+			return nil, fmt.Errorf("Profile '%s' has no servers\n", name)
+		}
+		sort.Strings(servers)
+		profile := Profile{Name: name}
+		for _, server := range servers {
+			endpoint, _ := config.GetString(server)
+			profile.Servers = append(profile.Servers, strings.TrimPrefix(server, prefix))
+			profile.Endpoints = append(profile.Endpoints, endpoint)
+		}
+		if err := checkEndpoints(prefix+"server.", servers, profile.Endpoints); err != nil {
+			return nil, fmt.Errorf("Profile '%s': %v", name, err)
+		}
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles, nil
+}
+
+// recordResult merges the client stats of a bench run into a RunResult.
+func (self *Benchmark) recordResult(btype BenchType, run int) {
+	result := RunResult{Type: btype, Run: run}
+	var latencies []BenchLatency
+	for _, client := range self.clients {
+		stat := client.Stat
+		if stat == nil || stat.Ops == 0 {
+			continue
+		}
+		if result.Clients == 0 {
+			result.Stat = *stat
+		} else {
+			result.Stat.Merge(stat)
+		}
+		result.Stat.Latencies = nil
+		latencies = append(latencies, stat.Latencies...)
+		result.Throughput += stat.Throughput
+		result.Clients++
+	}
+	// the latencies are only kept until the percentiles are computed
+	result.Stat.Latencies = latencies
+	result.Stat.ComputePercentiles()
+	result.Stat.Latencies = nil
+	self.results = append(self.results, result)
+}
+
+// Results returns the cluster-wide results of all bench runs so far.
+func (self *Benchmark) Results() []RunResult {
+	return self.results
+}
+
+// WriteProfileReport writes the results of the same workload run against
+// several profiles side by side. Throughput and latency are also given
+// relative to the first profile by name, e.g. to quantify the replication cost of an
+// ensemble compared to a standalone server.
+func WriteProfileReport(path string, profiles []Profile, results [][]RunResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	f.WriteString("profile,servers,bench_type,run,clients,operations,errors,average_latency,99th_latency,throughput,throughput_vs_first,latency_vs_first\n")
+	for i, profile := range profiles {
+		for j, result := range results[i] {
+			tratio, lratio := 0.0, 0.0
+			if j < len(results[0]) && results[0][j].Type == result.Type && results[0][j].Run == result.Run {
+				first := results[0][j]
+				if first.Throughput > 0 {
+					tratio = result.Throughput / first.Throughput
+				}
+				if first.Stat.AvgLatency > 0 {
+					lratio = float64(result.Stat.AvgLatency) / float64(first.Stat.AvgLatency)
+				}
+			}
+			f.WriteString(fmt.Sprintf("%s,%d,%s,%d,%d,%d,%d,%d,%d,%f,%f,%f\n", profile.Name, len(profile.Servers),
+				result.Type.String(), result.Run, result.Clients, result.Stat.Ops, result.Stat.Errors,
+				result.Stat.AvgLatency.Nanoseconds(), result.Stat.NinetyNinethLatency, result.Throughput, tratio, lratio))
+		}
+	}
+	return nil
+}
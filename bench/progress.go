@@ -0,0 +1,88 @@
+package bench
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// progressReporter logs the progress of a bench run every interval, so a
+// long run can be told apart from a wedged one. The request loops count
+// their requests with atomics, the reporter never takes the stats mutex.
+type progressReporter struct {
+	name     string
+	target   int64 // requests expected in the run
+	ops      int64
+	errors   int64
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// startProgress starts reporting the progress of a bench run, nil without
+// a ProgressInterval. The requests expected are announced with expect.
+func (self *Benchmark) startProgress(name string) *progressReporter {
+	if self.ProgressInterval <= 0 {
+		return nil
+	}
+	p := &progressReporter{
+		name:     name,
+		interval: self.ProgressInterval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go p.loop()
+	return p
+}
+
+// expect adds n requests to the target of the run.
+func (self *progressReporter) expect(n int64) {
+	if self != nil {
+		atomic.AddInt64(&self.target, n)
+	}
+}
+
+// add counts a completed request.
+func (self *progressReporter) add(err error) {
+	if self == nil {
+		return
+	}
+	atomic.AddInt64(&self.ops, 1)
+	if err != nil {
+		atomic.AddInt64(&self.errors, 1)
+	}
+}
+
+func (self *progressReporter) loop() {
+	defer close(self.done)
+	ticker := time.NewTicker(self.interval)
+	defer ticker.Stop()
+	var last int64
+	lastTime := time.Now()
+	for {
+		select {
+		case <-self.stop:
+			return
+		case now := <-ticker.C:
+			ops := atomic.LoadInt64(&self.ops)
+			target := atomic.LoadInt64(&self.target)
+			var percent float64
+			if target > 0 {
+				percent = float64(ops) / float64(target) * 100
+			}
+			rate := float64(ops-last) / now.Sub(lastTime).Seconds()
+			log.Printf("[Bench]: %s: %d/%d ops (%.1f%%), %d errors, %.1f req/s\n", self.name, ops, target,
+				percent, atomic.LoadInt64(&self.errors), rate)
+			last, lastTime = ops, now
+		}
+	}
+}
+
+// Close stops the reporting once the run is done.
+func (self *progressReporter) Close() {
+	if self == nil {
+		return
+	}
+	close(self.stop)
+	<-self.done
+}
@@ -0,0 +1,47 @@
+package bench
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// SATURATION_THRESHOLD is the fraction of the requested rate below which
+// the load generator is considered saturated, i.e. the clients could not
+// issue the requests the rate called for.
+const SATURATION_THRESHOLD = 0.9
+
+// intendedOps returns the requests a rate calls for over elapsed.
+func intendedOps(rate float64, elapsed time.Duration) int64 {
+	return int64(math.Round(rate * elapsed.Seconds()))
+}
+
+// achievedRate returns the issued requests in percent of the intended
+// ones, 0 without any intended.
+func achievedRate(intended, issued int64) float64 {
+	if intended <= 0 {
+		return 0
+	}
+	return float64(issued) / float64(intended) * 100
+}
+
+// rateCols returns the requests the rate limit called for and the
+// percentage of them that were issued, empty without a rate limit.
+func rateCols(stat *BenchStat) string {
+	if stat.IntendedOps == 0 {
+		return ",,"
+	}
+	return fmt.Sprintf(",%d,%.1f", stat.IntendedOps, achievedRate(stat.IntendedOps, stat.Ops))
+}
+
+// warnSaturation warns if a rate limited run issued less than the
+// SATURATION_THRESHOLD of the intended requests. Its latencies then
+// understate the load the servers were meant to get, since the clients,
+// not the servers, fell behind.
+func warnSaturation(label string, intended, issued int64) {
+	if intended > 0 && float64(issued) < SATURATION_THRESHOLD*float64(intended) {
+		log.Printf("[Bench]: warning: %s issued %d of the %d requests of its rate (%.1f%%), the clients may be saturated\n",
+			label, issued, intended, achievedRate(intended, issued))
+	}
+}
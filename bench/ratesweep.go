@@ -0,0 +1,129 @@
+package bench
+
+import (
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// parseRates parses a comma-separated list of positive request rates.
+func parseRates(spec string) ([]float64, error) {
+	var rates []float64
+	for _, s := range strings.Split(spec, ",") {
+		rate, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("Invalid rate '%s': must be a positive number\n", s)
+		}
+		rates = append(rates, rate)
+	}
+	return rates, nil
+}
+
+// runRateSweep measures write latency at each offered rate of RateSweep.
+// Unlike the closed-loop benchmarks, writes are dispatched on a fixed
+// schedule without waiting for earlier ones to complete, so at higher rates
+// more writes are outstanding at once and the server can group them into a
+// single transaction log fsync. The per-op latency vs offered rate curve
+// reveals where this batching kicks in.
+//
+// To keep a saturated server from piling up unbounded outstanding writes,
+// MaxInflight caps them across all clients. At the cap, the dispatcher
+// either drops the write and counts it as rejected or, with the "block"
+// policy, waits for a slot, which turns the sweep closed-loop.
+func (self *Benchmark) runRateSweep() {
+	ctx := self.context()
+	sweepf, err := self.openOutput("ratesweep.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer sweepf.Close()
+	if info, err := sweepf.Stat(); err == nil && info.Size() == 0 {
+		sweepf.WriteString("offered_rate,step_duration,operations,errors,average_latency,min_latency,max_latency,99th_latency,achieved_throughput,rejected,intended_requests,issued_requests,achieved_rate_percent\n")
+	}
+
+	src := mrand.NewSource(time.Now().UnixNano())
+	key := sameKey(self.KeySizeBytes)
+	val := randBytes(src, self.ValueSizeBytes)
+	for _, rate := range self.RateSweep {
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		var stat BenchStat
+		var rejected int64
+		var slots chan struct{}
+		if self.MaxInflight > 0 {
+			slots = make(chan struct{}, self.MaxInflight)
+		}
+		stat.OpType = fmt.Sprintf("RATE_SWEEP.%g", rate)
+		// every client offers an even share of the rate
+		interval := time.Duration(float64(time.Second) * float64(len(self.clients)) / rate)
+		log.Printf("[Bench]: start write rate sweep step at %g req/s for %s\n", rate, self.RateSweepStep)
+		for _, client := range self.clients {
+			wg.Add(1)
+			go func(client *Client) {
+				defer wg.Done()
+				var inflight sync.WaitGroup
+				start := time.Now()
+				for i := int64(0); ; i++ {
+					next := start.Add(time.Duration(i) * interval)
+					if next.Sub(start) >= self.RateSweepStep {
+						break
+					}
+					time.Sleep(time.Until(next))
+					rkey := key
+					if !self.SameKey {
+						rkey = self.keyAt(i % self.NRequests)
+					}
+					if slots != nil {
+						if self.InflightPolicy == "block" {
+							slots <- struct{}{}
+						} else {
+							select {
+							case slots <- struct{}{}:
+							default:
+								atomic.AddInt64(&rejected, 1)
+								continue
+							}
+						}
+					}
+					inflight.Add(1)
+					go func() {
+						defer inflight.Done()
+						if slots != nil {
+							defer func() { <-slots }()
+						}
+						begin := time.Now()
+						err := client.Write(ctx, rkey, val)
+						d := time.Since(begin)
+						if err != nil {
+							client.Log("error in processing %s request for key %s: %v", stat.OpType, rkey, err)
+						}
+						mutex.Lock()
+						stat.add(client.ServerAddr(), begin, d, err)
+						mutex.Unlock()
+					}()
+				}
+				inflight.Wait()
+			}(client)
+		}
+		wg.Wait()
+		stat.finish()
+		var achieved float64
+		if elapsed := stat.EndTime.Sub(stat.StartTime); elapsed > 0 {
+			achieved = float64(stat.Ops-stat.Errors) / elapsed.Seconds()
+		}
+		// requests dropped at the max_inflight cap were never issued
+		intended := intendedOps(rate, self.RateSweepStep)
+		sweepf.WriteString(fmt.Sprintf("%f,%s,%d,%d,%d,%d,%d,%d,%f,%d,%d,%d,%.1f\n", rate, self.RateSweepStep.String(),
+			stat.Ops, stat.Errors, stat.AvgLatency.Nanoseconds(), stat.MinLatency.Nanoseconds(),
+			stat.MaxLatency.Nanoseconds(), stat.NinetyNinethLatency, achieved, rejected, intended, stat.Ops,
+			achievedRate(intended, stat.Ops)))
+		log.Printf("[Bench]: done write rate sweep step at %g req/s: avg latency %s, achieved %f req/s, %d rejected\n",
+			rate, stat.AvgLatency, achieved, rejected)
+		warnSaturation(stat.OpType, intended-rejected, stat.Ops)
+	}
+}
@@ -0,0 +1,338 @@
+package bench
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const rawHeader = "client_id,bench_type,run,time,op_id,error,latency,mono_offset,server,corrected_latency\n"
+
+// rawHeaderOf returns the header of raw files with the key and warm-up
+// columns as requested.
+func rawHeaderOf(keys bool, warmup bool) string {
+	header := strings.TrimSuffix(rawHeader, "\n")
+	if keys {
+		header += ",key,value_bytes"
+	}
+	if warmup {
+		header += ",warmup"
+	}
+	return header + "\n"
+}
+
+// rawFlushInterval is how often streamed raw records are flushed to disk,
+// bounding what a killed benchmark loses
+const rawFlushInterval = time.Second
+
+// rawRow formats one raw per-request record. Besides the wall-clock start
+// time, it has the start as monotonic offset from clockBase, the server
+// the request went to, and the latency from the scheduled start. The error
+// column is 1 for a failed request and 2 for one that timed out. With
+// keys, the key and value size of the request follow, and with warmup
+// whether it was excluded from the stats as warm-up.
+func rawRow(cid int, btype BenchType, run int, opid int64, latency BenchLatency, keys bool, warmup bool) string {
+	latency_error := 0
+	if latency.TimedOut {
+		latency_error = 2
+	} else if latency.Latency < 0 {
+		latency_error = 1
+	}
+	// failed requests have the time until they failed, flagged by the error
+	elapsed := latency.Elapsed()
+	row := fmt.Sprintf("%d,%s,%d,%s,%d,%d,%d,%d,%s,%d", cid, btype.String(), run,
+		latency.Start.UTC().Format("2006-01-02T15:04:05.000Z07:00"), opid, latency_error, elapsed.Nanoseconds(),
+		latency.Start.Sub(clockBase).Nanoseconds(), latency.Server, (elapsed + latency.Queued).Nanoseconds())
+	if keys {
+		row += fmt.Sprintf(",%s,%d", csvField(latency.Key), latency.ValueBytes)
+	}
+	if warmup {
+		warm := 0
+		if latency.Warmup {
+			warm = 1
+		}
+		row += fmt.Sprintf(",%d", warm)
+	}
+	return row + "\n"
+}
+
+// csvField quotes s for a CSV field if it contains a separator, quote or
+// line break, e.g. a key of a key list.
+func csvField(s string) string {
+	if !strings.ContainsAny(s, ",\"\r\n") {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// rawFile is the buffered raw output. Without rotation it is the single
+// outprefix+"raw.dat". With a rotation size or interval it is split into
+// numbered chunks outprefix+"raw.0.dat", "raw.1.dat", ... each starting
+// with the header, and a new chunk is started at a record boundary once
+// the current one reaches the size or has been open for the interval.
+// In the JSON format, the files end in .json instead and have no header.
+// Compressed files are gzipped and end in an extra .gz, their rotation size
+// counts the uncompressed records. With keys, the records include the key
+// and value size of the requests, with warmup the warm-up marker.
+type rawFile struct {
+	outprefix string
+	json      bool
+	compress  bool
+	keys      bool
+	warmup    bool
+	header    string
+	ext       string
+	rotate    bool
+	maxBytes  int64
+	interval  time.Duration
+	chunk     int
+	size      int64
+	opened    time.Time
+	f         *os.File
+	gz        *gzip.Writer
+	w         *bufio.Writer
+}
+
+// openRawFile opens the raw output for appending. Without rotation, the
+// file is truncated and the header written only if header is set. With
+// rotation, the chunks of an earlier run are removed if header is set,
+// otherwise appending resumes at the last existing chunk so nonstop
+// iterations continue the numbering, and the header goes to every new
+// chunk.
+func openRawFile(outprefix string, maxBytes int64, interval time.Duration, header bool, json bool, compress bool,
+	keys bool, warmup bool) (*rawFile, error) {
+	self := &rawFile{
+		outprefix: outprefix,
+		json:      json,
+		compress:  compress,
+		keys:      keys,
+		warmup:    warmup,
+		header:    rawHeaderOf(keys, warmup),
+		ext:       "dat",
+		rotate:    maxBytes > 0 || interval > 0,
+		maxBytes:  maxBytes,
+		interval:  interval,
+	}
+	if json {
+		self.header, self.ext = "", "json"
+	}
+	if compress {
+		self.ext += ".gz"
+	}
+	if !self.rotate {
+		flags := os.O_APPEND | os.O_CREATE | os.O_RDWR
+		if header {
+			flags |= os.O_TRUNC // a fresh file, drop records of an earlier run
+		}
+		f, err := os.OpenFile(outprefix+"raw."+self.ext, flags, 0644)
+		if err != nil {
+			return nil, err
+		}
+		self.setFile(f)
+		if header {
+			self.w.WriteString(self.header)
+		}
+		return self, nil
+	}
+	if header {
+		// a fresh run, drop the chunks of an earlier run
+		for chunk := 0; ; chunk++ {
+			if err := os.Remove(self.chunkPath(chunk)); err != nil {
+				break
+			}
+		}
+		return self, self.openChunk()
+	}
+	for {
+		if _, err := os.Stat(self.chunkPath(self.chunk + 1)); err != nil {
+			break
+		}
+		self.chunk++
+	}
+	return self, self.openChunk()
+}
+
+func (self *rawFile) chunkPath(chunk int) string {
+	return fmt.Sprintf("%sraw.%d.%s", self.outprefix, chunk, self.ext)
+}
+
+func (self *rawFile) openChunk() error {
+	f, err := os.OpenFile(self.chunkPath(self.chunk), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	self.setFile(f)
+	self.size = info.Size()
+	self.opened = time.Now()
+	if self.size == 0 {
+		self.write(self.header)
+	}
+	return nil
+}
+
+// setFile buffers the writes to f, through a gzip stream if compressed.
+// Appending to a compressed file adds a gzip member, which zcat reads as
+// the continuation of the earlier ones.
+func (self *rawFile) setFile(f *os.File) {
+	self.f = f
+	if self.compress {
+		self.gz = gzip.NewWriter(f)
+		self.w = bufio.NewWriterSize(self.gz, 1<<20)
+	} else {
+		self.gz = nil
+		self.w = bufio.NewWriterSize(f, 1<<20)
+	}
+}
+
+func (self *rawFile) write(s string) (int, error) {
+	// flush whole records only, so the file never ends in a partial one
+	if self.w.Buffered() > 0 && self.w.Available() < len(s) {
+		if err := self.w.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := self.w.WriteString(s)
+	self.size += int64(n)
+	return n, err
+}
+
+// WriteString writes one raw record, rotating to the next chunk first if
+// the current one is full.
+func (self *rawFile) WriteString(row string) (int, error) {
+	if self.rotate && self.size > int64(len(self.header)) &&
+		((self.maxBytes > 0 && self.size+int64(len(row)) > self.maxBytes) ||
+			(self.interval > 0 && time.Since(self.opened) >= self.interval)) {
+		if err := self.Close(); err != nil {
+			return 0, err
+		}
+		self.chunk++
+		if err := self.openChunk(); err != nil {
+			return 0, err
+		}
+	}
+	return self.write(row)
+}
+
+// format formats one raw record in the format of the file.
+func (self *rawFile) format(cid int, btype BenchType, run int, opid int64, latency BenchLatency) string {
+	if self.json {
+		return rawJSON(cid, btype, run, opid, latency, self.keys, self.warmup)
+	}
+	return rawRow(cid, btype, run, opid, latency, self.keys, self.warmup)
+}
+
+// WriteRecord writes one raw record in the format of the file.
+func (self *rawFile) WriteRecord(cid int, btype BenchType, run int, opid int64, latency BenchLatency) (int, error) {
+	return self.WriteString(self.format(cid, btype, run, opid, latency))
+}
+
+func (self *rawFile) Flush() error {
+	if err := self.w.Flush(); err != nil {
+		return err
+	}
+	if self.gz != nil {
+		return self.gz.Flush()
+	}
+	return nil
+}
+
+// Close flushes the records and, if compressed, ends the gzip stream
+// before closing the file.
+func (self *rawFile) Close() error {
+	err := self.w.Flush()
+	if self.gz != nil {
+		if gzerr := self.gz.Close(); err == nil {
+			err = gzerr
+		}
+	}
+	if ferr := self.f.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// rawEntry is one raw record sent to a rawWriter. A failed request has a
+// latency of -1. A non-nil flushed asks for a flush instead.
+type rawEntry struct {
+	cid     int
+	btype   BenchType
+	run     int
+	opid    int64
+	latency BenchLatency
+	flushed chan error
+}
+
+// rawWriter streams raw records to the raw file as requests complete
+// instead of dumping them at the end of a bench run. The records of all
+// request groups go over a channel to a single goroutine, which appends
+// them to the file and flushes it every rawFlushInterval.
+type rawWriter struct {
+	f       *rawFile
+	entries chan rawEntry
+	done    chan struct{}
+}
+
+func newRawWriter(f *rawFile) *rawWriter {
+	self := &rawWriter{
+		f:       f,
+		entries: make(chan rawEntry, 4096),
+		done:    make(chan struct{}),
+	}
+	go self.loop()
+	return self
+}
+
+func (self *rawWriter) loop() {
+	defer close(self.done)
+	ticker := time.NewTicker(rawFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case entry, ok := <-self.entries:
+			if !ok {
+				return
+			}
+			if entry.flushed != nil {
+				entry.flushed <- self.f.Flush()
+				continue
+			}
+			if _, err := self.f.WriteRecord(entry.cid, entry.btype, entry.run, entry.opid, entry.latency); err != nil {
+				log.Printf("[Bench]: failed to write raw record: %v\n", err)
+			}
+		case <-ticker.C:
+			if err := self.f.Flush(); err != nil {
+				log.Printf("[Bench]: failed to flush raw records: %v\n", err)
+			}
+		}
+	}
+}
+
+// Write queues one raw record. It is safe for concurrent use by parallel
+// request groups, but not after Close.
+func (self *rawWriter) Write(cid int, btype BenchType, run int, opid int64, latency BenchLatency) {
+	self.entries <- rawEntry{cid: cid, btype: btype, run: run, opid: opid, latency: latency}
+}
+
+// Flush writes out all records queued so far.
+func (self *rawWriter) Flush() error {
+	flushed := make(chan error)
+	self.entries <- rawEntry{flushed: flushed}
+	return <-flushed
+}
+
+// Close writes out the queued records and stops the writer goroutine. The
+// raw file itself stays open.
+func (self *rawWriter) Close() error {
+	close(self.entries)
+	<-self.done
+	return self.f.Flush()
+}
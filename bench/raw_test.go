@@ -0,0 +1,149 @@
+package bench
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRawColumns(t *testing.T) {
+	latency := BenchLatency{Start: time.Now(), Latency: 1500, Server: "server.0", Key: "a,b", ValueBytes: 64}
+	for _, keys := range []bool{false, true} {
+		for _, warmup := range []bool{false, true} {
+			want := len(strings.Split(rawHeaderOf(keys, warmup), ","))
+			for _, btype := range []BenchType{READ, WRITE, MIXED} {
+				row := rawRow(1, btype, 1, 0, latency, keys, warmup)
+				fields, err := csv.NewReader(strings.NewReader(row)).Read()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(fields) != want {
+					t.Errorf("%s row with keys %v warmup %v: %d columns, want %d of the header",
+						btype, keys, warmup, len(fields), want)
+				}
+			}
+		}
+	}
+}
+
+func TestRawRow(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		latency   BenchLatency
+		errcol    string
+		latcol    string
+		corrected string
+	}{
+		{"success", BenchLatency{Start: start, Latency: 1500, Queued: 100}, "0", "1500", "1600"},
+		{"error", BenchLatency{Start: start, Latency: -1, ErrLatency: 700}, "1", "700", "700"},
+		{"timeout", BenchLatency{Start: start, Latency: -1, ErrLatency: 9000, TimedOut: true}, "2", "9000", "9000"},
+	}
+	header := strings.Split(strings.TrimSpace(rawHeader), ",")
+	column := func(name string) int {
+		for i, col := range header {
+			if col == name {
+				return i
+			}
+		}
+		t.Fatalf("no column %s in the raw header", name)
+		return -1
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			row := strings.Split(strings.TrimSpace(rawRow(3, READ, 1, 42, test.latency, false, false)), ",")
+			if len(row) != len(header) {
+				t.Fatalf("%d columns, want %d of the header", len(row), len(header))
+			}
+			if got := row[column("error")]; got != test.errcol {
+				t.Errorf("error %s, want %s", got, test.errcol)
+			}
+			if got := row[column("latency")]; got != test.latcol {
+				t.Errorf("latency %s, want %s", got, test.latcol)
+			}
+			if got := row[column("corrected_latency")]; got != test.corrected {
+				t.Errorf("corrected latency %s, want %s", got, test.corrected)
+			}
+			if got := row[column("op_id")]; got != "42" {
+				t.Errorf("op_id %s, want 42", got)
+			}
+		})
+	}
+}
+
+func TestRawRowOptionalColumns(t *testing.T) {
+	latency := BenchLatency{Latency: 1, Key: "a,b", ValueBytes: 64, Warmup: true}
+	tests := []struct {
+		name   string
+		keys   bool
+		warmup bool
+		suffix string
+	}{
+		{"none", false, false, ",1\n"},
+		{"keys", true, false, `,"a,b",64` + "\n"},
+		{"warmup", false, true, ",1,1\n"},
+		{"both", true, true, `,"a,b",64,1` + "\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			row := rawRow(1, WRITE, 1, 0, latency, test.keys, test.warmup)
+			if !strings.HasSuffix(row, test.suffix) {
+				t.Errorf("row %q does not end in %q", row, test.suffix)
+			}
+		})
+	}
+}
+
+// TestRawKeys checks that the raw records of a self test with raw_keys
+// line up with the header and carry the value sizes.
+func TestRawKeys(t *testing.T) {
+	_, prefix := runSelfTest(t, t.TempDir(), selfTestConf+"raw_keys = true\n", "csv")
+	f, err := os.Open(prefix + "raw.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	column := make(map[string]int)
+	for i, col := range header {
+		column[col] = i
+	}
+	counted := make(map[string]int)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		btype := record[column["bench_type"]]
+		counted[btype]++
+		if len(record) != len(header) {
+			t.Fatalf("%s record with %d columns, want %d", btype, len(record), len(header))
+		}
+		if btype != "READ" && btype != "WRITE" && btype != "MIXED" {
+			continue
+		}
+		if record[column["key"]] == "" {
+			t.Errorf("%s record without a key", btype)
+		}
+		size, err := strconv.Atoi(record[column["value_bytes"]])
+		if err != nil || btype == "WRITE" && size != 16 {
+			t.Errorf("%s record of %s value bytes", btype, record[column["value_bytes"]])
+		}
+	}
+	for _, btype := range []string{"READ", "WRITE", "MIXED"} {
+		if counted[btype] == 0 {
+			t.Errorf("no raw %s records", btype)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package bench
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// opRecorder records the key of every request the bench runs issue, so the
+// exact sequence, including the draws of random access, can be replayed.
+type opRecorder struct {
+	mutex sync.Mutex
+	w     *bufio.Writer
+	f     *os.File
+}
+
+// newOpRecorder opens the record file, replacing an earlier recording
+// unless fresh is false, i.e. for later non-stop iterations.
+func newOpRecorder(path string, fresh bool) (*opRecorder, error) {
+	flags := os.O_APPEND | os.O_CREATE | os.O_RDWR
+	if fresh {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriterSize(f, 1<<20)
+	if fresh {
+		w.WriteString("client_id,op_type,op_id,key\n")
+	}
+	return &opRecorder{w: w, f: f}, nil
+}
+
+func (self *opRecorder) record(cid int, optype string, opid int64, key string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.w.WriteString(fmt.Sprintf("%d,%s,%d,%s\n", cid, optype, opid, key))
+}
+
+func (self *opRecorder) Close() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if err := self.w.Flush(); err != nil {
+		self.f.Close()
+		return err
+	}
+	return self.f.Close()
+}
+
+// opReplay holds a recorded sequence of keys by client and op type.
+type opReplay struct {
+	keys map[string][]string
+}
+
+func replayKey(cid int, optype string) string {
+	return strconv.Itoa(cid) + "," + optype
+}
+
+// loadReplay reads a file written by opRecorder.
+func loadReplay(path string) (*opReplay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	replay := &opReplay{keys: make(map[string][]string)}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if line == 1 {
+			continue // header
+		}
+		// the key goes last since it may contain commas
+		cols := strings.SplitN(scanner.Text(), ",", 4)
+		if len(cols) != 4 {
+			return nil, fmt.Errorf("%s:%d: expecting client_id,op_type,op_id,key", path, line)
+		}
+		cid, err1 := strconv.Atoi(cols[0])
+		opid, err2 := strconv.ParseInt(cols[2], 10, 64)
+		if err1 != nil || err2 != nil || opid < 0 {
+			return nil, fmt.Errorf("%s:%d: invalid client or op id", path, line)
+		}
+		k := replayKey(cid, cols[1])
+		keys := replay.keys[k]
+		for int64(len(keys)) <= opid {
+			keys = append(keys, "")
+		}
+		keys[opid] = cols[3]
+		replay.keys[k] = keys
+	}
+	return replay, scanner.Err()
+}
+
+// request returns req with the recorded key of the request, or req itself
+// if nothing was recorded for it.
+func (self *opReplay) request(cid int, optype string, opid int64, req *Request) *Request {
+	keys := self.keys[replayKey(cid, optype)]
+	if opid >= int64(len(keys)) || keys[opid] == "" || keys[opid] == req.key {
+		return req
+	}
+	replayed := *req
+	replayed.key = keys[opid]
+	return &replayed
+}
@@ -0,0 +1,156 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// metric is a summary column compared between two runs.
+type metric struct {
+	column       string
+	higherBetter bool
+}
+
+var compared = []metric{
+	{"throughput", true},
+	{"average_latency", false},
+	{"99th_latency", false},
+	{"errors", false},
+}
+
+// Delta is the change of one metric of a summary row between two runs.
+type Delta struct {
+	Key        string // bench type, run, client and server of the row
+	Metric     string
+	A, B       float64
+	Percent    float64 // of A, +Inf if A is 0 and B is not
+	Regression bool
+}
+
+// rowKey identifies a summary row across runs.
+func rowKey(r row) string {
+	key := r["bench_type"] + " run " + r["run"] + " client " + r["client_id"]
+	if server := r["server"]; server != "" {
+		key += " server " + server
+	}
+	return key
+}
+
+// keyed maps the rows of a summary by rowKey, failing on duplicates, e.g.
+// of the iterations of a non-stop run.
+func keyed(summary *table, prefix string) (map[string]row, error) {
+	rows := make(map[string]row)
+	for _, r := range summary.Rows {
+		key := rowKey(r)
+		if _, ok := rows[key]; ok {
+			return nil, fmt.Errorf("Summary of %s has several rows for %s\n", prefix, key)
+		}
+		rows[key] = r
+	}
+	return rows, nil
+}
+
+// Compare matches the summary rows of the runs with prefixes a and b by
+// bench type, run, client and server, and returns the deltas of the
+// throughput, average and p99 latency and errors of every row in the
+// order of the summary of a. A change for the worse of more than
+// threshold percent is a regression. Runs whose rows do not match, e.g.
+// with different bench types or numbers of clients, are an error.
+func Compare(a, b string, threshold float64) ([]Delta, error) {
+	sa, err := readSummary(a)
+	if err != nil {
+		return nil, err
+	}
+	sb, err := readSummary(b)
+	if err != nil {
+		return nil, err
+	}
+	ra, err := keyed(sa, a)
+	if err != nil {
+		return nil, err
+	}
+	rb, err := keyed(sb, b)
+	if err != nil {
+		return nil, err
+	}
+	var onlyA, onlyB []string
+	for key := range ra {
+		if _, ok := rb[key]; !ok {
+			onlyA = append(onlyA, key)
+		}
+	}
+	for key := range rb {
+		if _, ok := ra[key]; !ok {
+			onlyB = append(onlyB, key)
+		}
+	}
+	if len(onlyA) > 0 || len(onlyB) > 0 {
+		sort.Strings(onlyA)
+		sort.Strings(onlyB)
+		return nil, fmt.Errorf("Runs do not match, only in %s: [%s], only in %s: [%s]\n",
+			a, strings.Join(onlyA, "; "), b, strings.Join(onlyB, "; "))
+	}
+	var deltas []Delta
+	for _, r := range sa.Rows {
+		key := rowKey(r)
+		for _, m := range compared {
+			d := Delta{Key: key, Metric: m.column, A: num(r, m.column), B: num(rb[key], m.column)}
+			if d.A != 0 {
+				d.Percent = (d.B - d.A) / d.A * 100
+			} else if d.B != 0 {
+				d.Percent = math.Inf(1)
+			}
+			if m.higherBetter {
+				d.Regression = d.Percent < -threshold
+			} else {
+				d.Regression = d.Percent > threshold
+			}
+			deltas = append(deltas, d)
+		}
+	}
+	return deltas, nil
+}
+
+// Regressions returns how many of the deltas are regressions.
+func Regressions(deltas []Delta) int {
+	n := 0
+	for _, d := range deltas {
+		if d.Regression {
+			n++
+		}
+	}
+	return n
+}
+
+// WriteComparison writes the deltas as CSV to path.
+func WriteComparison(path string, deltas []Delta) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(f, "row,metric,a,b,delta,delta_percent,regression\n")
+	for _, d := range deltas {
+		fmt.Fprintf(f, "%s,%s,%.2f,%.2f,%.2f,%.2f,%t\n", d.Key, d.Metric, d.A, d.B, d.B-d.A, d.Percent, d.Regression)
+	}
+	return f.Close()
+}
+
+// PrintComparison prints the deltas as an aligned table, marking the
+// regressions.
+func PrintComparison(w io.Writer, deltas []Delta) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "row\tmetric\ta\tb\tdelta\tdelta %%\t\n")
+	for _, d := range deltas {
+		mark := ""
+		if d.Regression {
+			mark = "REGRESSION"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.2f\t%.2f\t%+.2f\t%+.2f\t%s\n", d.Key, d.Metric, d.A, d.B, d.B-d.A, d.Percent, mark)
+	}
+	tw.Flush()
+}
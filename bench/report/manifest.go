@@ -0,0 +1,126 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// readManifest reads the manifest of the run with the file prefix as a
+// table of its fields, with the nested ones flattened to dotted names
+// such as config.Clients. A run without a manifest is returned as nil.
+func readManifest(prefix string) (*table, error) {
+	data, err := os.ReadFile(prefix + "manifest.json")
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("Fail to parse manifest of %s: %v\n", prefix, err)
+	}
+	fields := make(map[string]string)
+	flatten("", m, fields)
+	t := &table{Columns: []string{"field", "value"}}
+	for _, name := range sortedFields(fields) {
+		t.Rows = append(t.Rows, row{"field": name, "value": fields[name]})
+	}
+	return t, nil
+}
+
+// flatten adds the leaves of a decoded JSON value to fields.
+func flatten(name string, v interface{}, fields map[string]string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if name != "" {
+				k = name + "." + k
+			}
+			flatten(k, e, fields)
+		}
+	case []interface{}:
+		for i, e := range v {
+			flatten(name+"."+strconv.Itoa(i), e, fields)
+		}
+	case float64:
+		fields[name] = strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		fields[name] = ""
+	default:
+		fields[name] = fmt.Sprint(v)
+	}
+}
+
+// sortedFields returns the names of fields with the run info first and
+// the config last.
+func sortedFields(fields map[string]string) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ci, cj := strings.HasPrefix(names[i], "config."), strings.HasPrefix(names[j], "config.")
+		if ci != cj {
+			return cj
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// manifestSection lists the fields of the manifest of a run.
+func manifestSection(manifest *table) section {
+	return section{Title: "Manifest", Table: manifest}
+}
+
+// PrintManifests prints the manifests of the runs with prefixes a and b
+// side by side, marking the fields that differ. Runs without a manifest,
+// e.g. of older versions, show empty values.
+func PrintManifests(w io.Writer, a, b string) error {
+	ma, err := readManifest(a)
+	if err != nil {
+		return err
+	}
+	mb, err := readManifest(b)
+	if err != nil {
+		return err
+	}
+	if ma == nil && mb == nil {
+		return nil
+	}
+	fields := make(map[string]string)
+	va, vb := manifestValues(ma), manifestValues(mb)
+	for name := range va {
+		fields[name] = ""
+	}
+	for name := range vb {
+		fields[name] = ""
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "field\ta\tb\t\n")
+	for _, name := range sortedFields(fields) {
+		mark := ""
+		if va[name] != vb[name] {
+			mark = "DIFFERS"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", name, va[name], vb[name], mark)
+	}
+	return tw.Flush()
+}
+
+// manifestValues maps the fields of a manifest table to their values.
+func manifestValues(manifest *table) map[string]string {
+	values := make(map[string]string)
+	if manifest != nil {
+		for _, r := range manifest.Rows {
+			values[r["field"]] = r["value"]
+		}
+	}
+	return values
+}
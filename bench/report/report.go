@@ -0,0 +1,539 @@
+// Package report renders the result files of a benchmark run into a single
+// self-contained HTML page with tables and inline SVG charts.
+package report
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// row is one record of a result file by column name.
+type row map[string]string
+
+// table is a result file with its columns in file order.
+type table struct {
+	Columns []string
+	Rows    []row
+}
+
+// readCSV reads a result file with a header line. A file that does not
+// exist is returned as nil without an error.
+func readCSV(path string) (*table, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	reader := csv.NewReader(bufio.NewReader(r))
+	reader.FieldsPerRecord = -1 // rows of older runs may have fewer columns
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	t := &table{Columns: header}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			// a run killed mid-write leaves a partial last line
+			if len(t.Rows) > 0 {
+				break
+			}
+			return nil, err
+		}
+		r := make(row)
+		for i, v := range record {
+			if i < len(header) {
+				r[header[i]] = v
+			}
+		}
+		t.Rows = append(t.Rows, r)
+	}
+	return t, nil
+}
+
+// readJSONSummary reads the summary of a run with -format json into the
+// columns of the CSV summary.
+func readJSONSummary(path string) (*table, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t := &table{}
+	seen := make(map[string]bool)
+	dec := json.NewDecoder(f)
+	for {
+		var rec map[string]interface{}
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		r := make(row)
+		for k, v := range rec {
+			switch v := v.(type) {
+			case map[string]interface{}: // the percentiles
+				for p, lat := range v {
+					r[p+"_latency"] = fmt.Sprint(lat)
+				}
+			case []interface{}:
+				continue
+			case float64:
+				r[k] = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				r[k] = fmt.Sprint(v)
+			}
+		}
+		for k := range r {
+			if !seen[k] {
+				seen[k] = true
+				t.Columns = append(t.Columns, k)
+			}
+		}
+		t.Rows = append(t.Rows, r)
+	}
+	sort.Strings(t.Columns)
+	return t, nil
+}
+
+// readSummary reads the summary of the run with the file prefix in either
+// format.
+func readSummary(prefix string) (*table, error) {
+	summary, err := readCSV(prefix + "summary.dat")
+	if err != nil {
+		return nil, err
+	}
+	if summary == nil {
+		if summary, err = readJSONSummary(prefix + "summary.json"); err != nil {
+			return nil, err
+		}
+	}
+	if summary == nil {
+		return nil, fmt.Errorf("No summary found for prefix %s\n", prefix)
+	}
+	return summary, nil
+}
+
+// firstTable reads the first of paths that exists.
+func firstTable(paths ...string) (*table, error) {
+	for _, path := range paths {
+		t, err := readCSV(path)
+		if t != nil || err != nil {
+			return t, err
+		}
+	}
+	return nil, nil
+}
+
+func num(r row, col string) float64 {
+	v, _ := strconv.ParseFloat(r[col], 64)
+	return v
+}
+
+// group is the bench type and run of a summary row.
+func group(r row) string {
+	return r["bench_type"] + " " + r["run"]
+}
+
+// series is a named list of values of a chart.
+type series struct {
+	Name   string
+	Values []float64
+}
+
+var colors = []string{"#4e79a7", "#f28e2b", "#e15759", "#76b7b2", "#59a14f", "#edc948", "#b07aa1", "#ff9da7", "#9c755f", "#bab0ac"}
+
+const (
+	chartWidth  = 900
+	chartHeight = 320
+	chartMargin = 60
+)
+
+// legend returns the SVG legend of the series.
+func legend(ss []series) string {
+	var b strings.Builder
+	for i, s := range ss {
+		y := 20 + 16*i
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/><text x="%d" y="%d" font-size="11">%s</text>`,
+			chartWidth-150, y-9, colors[i%len(colors)], chartWidth-135, y, html.EscapeString(s.Name))
+	}
+	return b.String()
+}
+
+// axes returns the SVG axes of a chart up to max with a y label.
+func axes(max float64, ylabel string) string {
+	var b strings.Builder
+	left, bottom := chartMargin, chartHeight-chartMargin
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#333"/>`, left, 10, left, bottom)
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#333"/>`, left, bottom, chartWidth-160, bottom)
+	for i := 0; i <= 4; i++ {
+		y := float64(bottom) - float64(bottom-10)*float64(i)/4
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" font-size="10" text-anchor="end">%s</text>`, left-4, y+3, strconv.FormatFloat(max*float64(i)/4, 'g', 4, 64))
+	}
+	fmt.Fprintf(&b, `<text x="12" y="%d" font-size="11" transform="rotate(-90 12 %d)" text-anchor="middle">%s</text>`,
+		bottom/2, bottom/2, html.EscapeString(ylabel))
+	return b.String()
+}
+
+func maxOf(ss []series) float64 {
+	max := 0.0
+	for _, s := range ss {
+		for _, v := range s.Values {
+			if v > max {
+				max = v
+			}
+		}
+	}
+	if max == 0 {
+		max = 1
+	}
+	return max
+}
+
+// barChart renders grouped bars, one group per label and one bar per
+// series in every group.
+func barChart(labels []string, ss []series, ylabel string) template.HTML {
+	if len(labels) == 0 || len(ss) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">`, chartWidth, chartHeight)
+	max := maxOf(ss)
+	b.WriteString(axes(max, ylabel))
+	bottom := float64(chartHeight - chartMargin)
+	groupWidth := float64(chartWidth-160-chartMargin) / float64(len(labels))
+	barWidth := groupWidth * 0.8 / float64(len(ss))
+	for g, label := range labels {
+		x0 := float64(chartMargin) + groupWidth*float64(g) + groupWidth*0.1
+		for i, s := range ss {
+			if g >= len(s.Values) {
+				continue
+			}
+			h := (bottom - 10) * s.Values[g] / max
+			fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s: %g</title></rect>`,
+				x0+barWidth*float64(i), bottom-h, barWidth, h, colors[i%len(colors)], html.EscapeString(s.Name), s.Values[g])
+		}
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" font-size="10" text-anchor="middle">%s</text>`,
+			x0+groupWidth*0.4, bottom+14, html.EscapeString(label))
+	}
+	b.WriteString(legend(ss))
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// lineChart renders every series as a line over x.
+func lineChart(x []float64, ss []series, xlabel, ylabel string) template.HTML {
+	if len(x) < 2 || len(ss) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">`, chartWidth, chartHeight)
+	max := maxOf(ss)
+	b.WriteString(axes(max, ylabel))
+	bottom := float64(chartHeight - chartMargin)
+	xmax := x[len(x)-1]
+	if xmax == 0 {
+		xmax = 1
+	}
+	width := float64(chartWidth - 160 - chartMargin)
+	for i, s := range ss {
+		var points []string
+		for k, v := range s.Values {
+			if k < len(x) {
+				points = append(points, fmt.Sprintf("%.1f,%.1f", float64(chartMargin)+width*x[k]/xmax, bottom-(bottom-10)*v/max))
+			}
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"/>`, colors[i%len(colors)], strings.Join(points, " "))
+	}
+	fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" font-size="10" text-anchor="end">%s</text>`, float64(chartMargin)+width, bottom+14, strconv.FormatFloat(xmax, 'g', 4, 64))
+	fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" font-size="11" text-anchor="middle">%s</text>`, float64(chartMargin)+width/2, bottom+30, html.EscapeString(xlabel))
+	b.WriteString(legend(ss))
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// section is a titled part of the report with a chart or a table.
+type section struct {
+	Title string
+	Note  string
+	Chart template.HTML
+	Table *table
+}
+
+// throughputChart plots the throughput of every client in every bench run.
+func throughputChart(summary *table) section {
+	var labels []string
+	index := make(map[string]int)
+	clients := make(map[string][]float64)
+	var order []string
+	for _, r := range summary.Rows {
+		if r["client_id"] == "ALL" {
+			continue
+		}
+		g := group(r)
+		if _, ok := index[g]; !ok {
+			index[g] = len(labels)
+			labels = append(labels, g)
+		}
+		c := "client " + r["client_id"]
+		if _, ok := clients[c]; !ok {
+			order = append(order, c)
+		}
+		for len(clients[c]) <= index[g] {
+			clients[c] = append(clients[c], 0)
+		}
+		clients[c][index[g]] = num(r, "throughput")
+	}
+	var ss []series
+	for _, c := range order {
+		ss = append(ss, series{c, clients[c]})
+	}
+	return section{Title: "Throughput per client", Chart: barChart(labels, ss, "requests/s")}
+}
+
+// totals returns the rows of a summary that cover all clients: the ALL
+// rows of all servers, or with -aggregate-only the rows of client 0.
+func totals(summary *table) []row {
+	var rows []row
+	for _, r := range summary.Rows {
+		if (r["client_id"] == "ALL" && (r["server"] == "" || r["server"] == "ALL")) || r["client_id"] == "0" {
+			rows = append(rows, r)
+		}
+	}
+	return rows
+}
+
+// percentileChart plots the latency percentiles of every bench run over
+// all clients.
+func percentileChart(summary *table) section {
+	var cols []string
+	for _, c := range summary.Columns {
+		if strings.HasPrefix(c, "p") && strings.HasSuffix(c, "_latency") {
+			cols = append(cols, c)
+		}
+	}
+	var labels []string
+	ss := make([]series, len(cols))
+	for i, c := range cols {
+		ss[i].Name = strings.TrimSuffix(c, "_latency")
+	}
+	for _, r := range totals(summary) {
+		labels = append(labels, group(r))
+		for i, c := range cols {
+			ss[i].Values = append(ss[i].Values, num(r, c)/1e6)
+		}
+	}
+	s := section{Title: "Latency percentiles over all clients", Chart: barChart(labels, ss, "latency (ms)")}
+	if len(cols) == 0 || len(labels) == 0 {
+		s.Note = "The summary has no percentile columns or rows over all clients."
+	}
+	return s
+}
+
+// errorTable lists the error rate of every bench run over all clients.
+func errorTable(summary *table) section {
+	t := &table{Columns: []string{"bench_type", "run", "operations", "errors", "error_rate"}}
+	for _, r := range totals(summary) {
+		rate := 0.0
+		if ops := num(r, "operations"); ops > 0 {
+			rate = num(r, "errors") / ops
+		}
+		t.Rows = append(t.Rows, row{"bench_type": r["bench_type"], "run": r["run"], "operations": r["operations"],
+			"errors": r["errors"], "error_rate": strconv.FormatFloat(rate, 'f', 4, 64)})
+	}
+	return section{Title: "Error rates", Table: t}
+}
+
+// rawChart plots the p99 latency of every second of every bench type from
+// the raw records.
+func rawChart(raw *table) section {
+	type second struct {
+		btype string
+		sec   int
+	}
+	var first time.Time
+	lats := make(map[second][]float64)
+	var btypes []string
+	seenType := make(map[string]bool)
+	last := 0
+	for _, r := range raw.Rows {
+		if r["error"] != "0" {
+			continue
+		}
+		t, err := time.Parse("2006-01-02T15:04:05.000Z07:00", r["time"])
+		if err != nil {
+			continue
+		}
+		if first.IsZero() {
+			first = t
+		}
+		s := second{r["bench_type"], int(t.Sub(first).Seconds())}
+		if s.sec < 0 {
+			continue
+		}
+		if s.sec > last {
+			last = s.sec
+		}
+		if !seenType[s.btype] {
+			seenType[s.btype] = true
+			btypes = append(btypes, s.btype)
+		}
+		lats[s] = append(lats[s], num(r, "latency")/1e6)
+	}
+	x := make([]float64, last+1)
+	for i := range x {
+		x[i] = float64(i)
+	}
+	var ss []series
+	for _, btype := range btypes {
+		values := make([]float64, last+1)
+		for sec := range values {
+			l := lats[second{btype, sec}]
+			if len(l) == 0 {
+				continue
+			}
+			sort.Float64s(l)
+			values[sec] = l[(len(l)*99+99)/100-1]
+		}
+		ss = append(ss, series{btype, values})
+	}
+	return section{Title: "p99 latency per second", Chart: lineChart(x, ss, "seconds since the first request", "latency (ms)")}
+}
+
+// bucketChart plots the p99 latency of the buckets of every client.
+func bucketChart(buckets *table) section {
+	lines := make(map[string][]float64)
+	var order []string
+	var x []float64
+	for _, r := range buckets.Rows {
+		name := r["bench_type"] + " run " + r["run"] + " client " + r["client_id"]
+		if r["bench_type"] == "" {
+			name = r["bench_test"] + " client " + r["client_id"] // before bench_type and run
+		}
+		if _, ok := lines[name]; !ok {
+			order = append(order, name)
+		}
+		lines[name] = append(lines[name], num(r, "p99_ns")/1e6)
+		if k := len(lines[name]) - 1; k >= len(x) {
+			x = append(x, num(r, "bucket_start_offset")/1e9)
+		}
+	}
+	if len(order) > len(colors) {
+		order = order[:len(colors)] // more lines would be unreadable
+	}
+	var ss []series
+	for _, name := range order {
+		ss = append(ss, series{name, lines[name]})
+	}
+	return section{Title: "p99 latency per bucket", Chart: lineChart(x, ss, "seconds since the start of the run", "latency (ms)")}
+}
+
+var page = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>zkbench {{.Prefix}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; font-size: 12px; margin-bottom: 1em; }
+td, th { border: 1px solid #ccc; padding: 2px 6px; text-align: right; }
+.note { color: #777; }
+</style></head>
+<body>
+<h1>zkbench {{.Prefix}}</h1>
+{{range .Sections}}<h2>{{.Title}}</h2>
+{{if .Note}}<p class="note">{{.Note}}</p>{{end}}
+{{if .Chart}}{{.Chart}}{{end}}
+{{with .Table}}{{$cols := .Columns}}<table><tr>{{range $cols}}<th>{{.}}</th>{{end}}</tr>
+{{range $r := .Rows}}<tr>{{range $cols}}<td>{{index $r .}}</td>{{end}}</tr>
+{{end}}</table>{{end}}
+{{end}}</body></html>
+`))
+
+// Render writes the report of the run with the file prefix to w. Only the
+// summary is required; the charts of the raw, buckets and markers files
+// and the manifest are left out when those files do not exist.
+func Render(prefix string, w io.Writer) error {
+	summary, err := readSummary(prefix)
+	if err != nil {
+		return err
+	}
+	sections := []section{throughputChart(summary), percentileChart(summary), errorTable(summary)}
+	raw, err := firstTable(prefix+"raw.dat", prefix+"raw.dat.gz")
+	if err != nil {
+		return err
+	}
+	if raw != nil {
+		sections = append(sections, rawChart(raw))
+	} else {
+		sections = append(sections, section{Title: "p99 latency per second", Note: "No raw file, run with -rawstat for this chart."})
+	}
+	buckets, err := readCSV(prefix + "buckets.dat")
+	if err != nil {
+		return err
+	}
+	if buckets != nil {
+		sections = append(sections, bucketChart(buckets))
+	}
+	markers, err := readCSV(prefix + "markers.dat")
+	if err != nil {
+		return err
+	}
+	if markers != nil {
+		sections = append(sections, section{Title: "Phase markers", Table: markers})
+	}
+	manifest, err := readManifest(prefix)
+	if err != nil {
+		return err
+	}
+	if manifest != nil {
+		sections = append(sections, manifestSection(manifest))
+	}
+	sections = append(sections, section{Title: "Summary", Table: summary})
+	var data = struct {
+		Prefix   string
+		Sections []section
+	}{prefix, sections}
+	return page.Execute(w, data)
+}
+
+// Write renders the report of the run with the file prefix to
+// prefix+"report.html" and returns its path.
+func Write(prefix string) (string, error) {
+	path := prefix + "report.html"
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := Render(prefix, f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", err
+	}
+	return path, f.Close()
+}
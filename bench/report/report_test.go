@@ -0,0 +1,87 @@
+package report
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const summaryFixture = `client_id,bench_type,run,operations,errors,average_latency,throughput,p50_latency,p99_latency,server
+1,READ,1,100,0,1000000,500.5,900000,2000000,server.0
+2,READ,1,100,2,1200000,480,1000000,3000000,server.0
+ALL,READ,1,200,2,1100000,980,950000,3000000,server.0
+ALL,READ,1,200,2,1100000,980,950000,3000000,ALL
+`
+
+const rawFixture = `client_id,bench_type,run,time,op_id,error,latency
+1,READ,1,2020-01-01T00:00:00.000Z,0,0,1000000
+1,READ,1,2020-01-01T00:00:00.500Z,1,0,2000000
+1,READ,1,2020-01-01T00:00:01.000Z,2,0,3000000
+1,READ,1,2020-01-01T00:00:01.500Z,3,1,-1
+`
+
+func TestRender(t *testing.T) {
+	sections := []string{"<h2>Throughput per client</h2>", "<h2>Latency percentiles over all clients</h2>",
+		"<h2>Error rates</h2>", "<h2>p99 latency per second</h2>", "<h2>Summary</h2>"}
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string
+		skip  []string // must not be in the report
+	}{
+		{
+			name:  "summary only",
+			files: map[string]string{"summary.dat": summaryFixture},
+			want: append(sections, "<title>client 1: 500.5</title>", "<title>client 2: 480</title>",
+				"<title>p50: 0.95</title>", "<title>p99: 3</title>", "<td>0.0100</td>", "No raw file"),
+			skip: []string{"client ALL", "<polyline"},
+		},
+		{
+			name:  "with raw",
+			files: map[string]string{"summary.dat": summaryFixture, "raw.dat": rawFixture},
+			want:  append(sections, "<polyline", "<title>client 1: 500.5</title>"),
+			skip:  []string{"No raw file"},
+		},
+		{
+			name: "json summary",
+			files: map[string]string{"summary.json": `{"client_id":"ALL","bench_type":"READ","run":1,"operations":200,"errors":2,` +
+				`"throughput":980,"percentiles":{"p99":3000000},"server":"ALL"}` + "\n"},
+			want: append(sections, "<title>p99: 3</title>", "<td>0.0100</td>"),
+			skip: []string{"client ALL"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prefix := filepath.Join(t.TempDir(), "zkresult-")
+			for name, content := range test.files {
+				if err := os.WriteFile(prefix+name, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var b bytes.Buffer
+			if err := Render(prefix, &b); err != nil {
+				t.Fatal(err)
+			}
+			report := b.String()
+			for _, s := range test.want {
+				if !strings.Contains(report, s) {
+					t.Errorf("no %q in the report", s)
+				}
+			}
+			for _, s := range test.skip {
+				if strings.Contains(report, s) {
+					t.Errorf("%q in the report", s)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderWithoutSummary(t *testing.T) {
+	var b bytes.Buffer
+	if err := Render(filepath.Join(t.TempDir(), "zkresult-"), &b); err == nil {
+		t.Error("rendered a report without a summary")
+	}
+}
@@ -0,0 +1,146 @@
+package bench
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// requestOutcome is a request of a bench run as it completed.
+type requestOutcome struct {
+	key        string
+	valueBytes int64     // the data sent, or for a read the data returned
+	scheduled  time.Time // the start the rate limit called for, zero without one
+	begin      time.Time
+	d          time.Duration
+	err        error
+}
+
+// prepareStat sets up how stat keeps its requests: the latencies of
+// nrequests requests, which may grow beyond, if keepLatencies, and running
+// stats otherwise, plus a digest if the percentiles are estimated.
+func (self *Benchmark) prepareStat(stat *BenchStat, nrequests int64) {
+	keep := self.keepLatencies()
+	if keep {
+		stat.Latencies = make([]BenchLatency, nrequests)
+	} else {
+		origin := self.runStart
+		if origin.IsZero() {
+			origin = time.Now()
+		}
+		stat.running = newRunningStats(origin, self.ClientRate > 0)
+	}
+	if self.LatencyMode == "tdigest" || !keep {
+		stat.digest = newTDigest(TDIGEST_COMPRESSION)
+	}
+}
+
+// recordRequest accounts a request of client in stat, whose lock the
+// caller holds if it has one, and returns the record of the request. A
+// warm-up request only counts in WarmupOps, its record is only streamed
+// to the raw output. The record is kept as the j-th latency of stat,
+// appended for a negative j, or added to the running stats of a stat
+// without latencies.
+func (self *Benchmark) recordRequest(stat *BenchStat, client *Client, j int64, warm bool, out *requestOutcome) BenchLatency {
+	if warm {
+		stat.WarmupOps++
+	} else {
+		stat.Ops++
+	}
+	latency := BenchLatency{Warmup: warm, Start: out.begin, Server: client.ServerAddr()}
+	if !out.scheduled.IsZero() && out.begin.After(out.scheduled.Add(client.Delay)) {
+		// behind schedule, the injected delay aside
+		latency.Queued = out.begin.Sub(out.scheduled) - client.Delay
+	}
+	if self.RawKeys {
+		latency.Key, latency.ValueBytes = out.key, out.valueBytes
+	}
+	d := out.d
+	if out.err != nil {
+		client.Log("error in processing %s request for key %s: %v", stat.OpType, out.key, out.err)
+		latency.Latency = -1
+		latency.ErrLatency = d
+		latency.TimedOut = out.err == ErrOpTimeout
+		if !warm {
+			stat.Errors++
+			if out.err == ErrOpTimeout {
+				stat.Timeouts++
+			}
+			stat.observeError(d)
+		}
+	} else {
+		latency.Latency = d
+		if ok := stat.Ops - stat.Errors; !warm {
+			if ok == 1 || d < stat.MinLatency {
+				stat.MinLatency = d
+			}
+			if ok == 1 || d > stat.MaxLatency {
+				stat.MaxLatency = d
+			}
+			stat.TotalLatency += d
+			stat.observe(ok, d)
+			stat.digest.add(d)
+		}
+	}
+	if stat.running != nil {
+		if !warm {
+			stat.running.add(latency)
+		}
+	} else if j >= 0 && j < int64(len(stat.Latencies)) {
+		stat.Latencies[j] = latency
+	} else {
+		stat.Latencies = append(stat.Latencies, latency)
+	}
+	return latency
+}
+
+// requestReporter reports the requests of a client in a bench run as they
+// complete, to the raw stream, the live metrics, the metric sink and the
+// progress, and reconnects the client when a request found no server.
+type requestReporter struct {
+	bench    *Benchmark
+	client   *Client
+	cid      string
+	btype    BenchType
+	run      int
+	live     *metricCounters
+	sink     MetricSink
+	progress *progressReporter
+}
+
+func (self *Benchmark) newRequestReporter(client *Client, btype BenchType, run int) *requestReporter {
+	reporter := &requestReporter{
+		bench:    self,
+		client:   client,
+		cid:      strconv.Itoa(client.Id),
+		btype:    btype,
+		run:      run,
+		sink:     self.metricSink(),
+		progress: self.progress,
+	}
+	if self.metrics != nil {
+		reporter.live = self.metrics.of(client.Id, btype)
+	}
+	return reporter
+}
+
+// report reports the j-th request of the run, of optype, with its record
+// from recordRequest. It is called outside the lock of the stat, since the
+// reconnect may back off for a while.
+func (self *requestReporter) report(j int64, optype string, latency BenchLatency, err error) {
+	if err == zk.ErrNoServer {
+		self.client.Reconnect()
+	} else if err == nil {
+		self.client.ResetBackoff()
+	}
+	if rawstream := self.bench.rawstream; rawstream != nil && (!latency.Warmup || self.bench.RawWarmup) {
+		rawstream.Write(self.client.Id, self.btype, self.run, j, latency)
+	}
+	d := latency.Elapsed()
+	if self.live != nil {
+		self.live.add(d, err)
+	}
+	self.sink.RecordOp(self.cid, optype, d, err)
+	self.progress.add(err)
+}
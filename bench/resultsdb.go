@@ -0,0 +1,148 @@
+package bench
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // pure Go, so no cgo is needed
+)
+
+const resultsSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	start_time   TEXT NOT NULL,
+	end_time     TEXT,
+	prefix       TEXT NOT NULL,
+	hostname     TEXT,
+	args         TEXT,
+	go_version   TEXT,
+	vcs_revision TEXT,
+	config       TEXT
+);
+CREATE TABLE IF NOT EXISTS summaries (
+	run_id          INTEGER NOT NULL REFERENCES runs(id),
+	client_id       TEXT NOT NULL,
+	server          TEXT NOT NULL,
+	bench_type      TEXT NOT NULL,
+	run             INTEGER NOT NULL,
+	operations      INTEGER,
+	errors          INTEGER,
+	average_latency INTEGER,
+	min_latency     INTEGER,
+	max_latency     INTEGER,
+	p50_latency     INTEGER,
+	p90_latency     INTEGER,
+	p95_latency     INTEGER,
+	p99_latency     INTEGER,
+	p999_latency    INTEGER,
+	stddev_latency  INTEGER,
+	throughput      REAL,
+	bytes_sent      INTEGER,
+	bytes_received  INTEGER
+);
+CREATE INDEX IF NOT EXISTS summaries_run ON summaries(run_id);
+`
+
+// resultsDB stores the summary rows of a benchmark run in a SQLite
+// database next to the CSV files. A run and its rows are written in one
+// transaction that only commits once the run is done, so a crashed run
+// leaves no partial rows behind.
+type resultsDB struct {
+	db    *sql.DB
+	tx    *sql.Tx
+	runID int64
+}
+
+// openResultsDB creates the schema of the database at path if needed and
+// starts the run with the file prefix.
+func openResultsDB(path string, prefix string, config *BenchConfig) (*resultsDB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(resultsSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	var snapshot []byte
+	if snapshot, err = json.Marshal(config); err != nil {
+		tx.Rollback()
+		db.Close()
+		return nil, err
+	}
+	res, err := tx.Exec(`INSERT INTO runs (start_time, prefix, hostname, args, go_version, vcs_revision, config)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, time.Now().UTC().Format(time.RFC3339Nano), prefix, hostname,
+		strings.Join(os.Args, " "), runtime.Version(), vcsRevision(), string(snapshot))
+	if err != nil {
+		tx.Rollback()
+		db.Close()
+		return nil, err
+	}
+	self := &resultsDB{db: db, tx: tx}
+	if self.runID, err = res.LastInsertId(); err != nil {
+		self.Close(false)
+		return nil, err
+	}
+	return self, nil
+}
+
+// vcsRevision returns the commit the binary was built from, with a
+// "-dirty" suffix for local changes, or "" if unknown.
+func vcsRevision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value
+		}
+	}
+	if revision != "" && modified == "true" {
+		revision += "-dirty"
+	}
+	return revision
+}
+
+// add stores a summary row. The percentiles of stat must be computed.
+func (self *resultsDB) add(id int, server string, btype string, run int, stat *BenchStat) error {
+	_, err := self.tx.Exec(`INSERT INTO summaries (run_id, client_id, server, bench_type, run, operations, errors,
+		average_latency, min_latency, max_latency, p50_latency, p90_latency, p95_latency, p99_latency, p999_latency,
+		stddev_latency, throughput, bytes_sent, bytes_received) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		self.runID, clientCol(id), server, btype, run, stat.Ops, stat.Errors,
+		stat.AvgLatency.Nanoseconds(), stat.MinLatency.Nanoseconds(), stat.MaxLatency.Nanoseconds(),
+		stat.P50Latency.Nanoseconds(), stat.P90Latency.Nanoseconds(), stat.P95Latency.Nanoseconds(),
+		stat.P99Latency.Nanoseconds(), stat.P999Latency.Nanoseconds(), stat.StdDevLatency.Nanoseconds(),
+		stat.Throughput, stat.BytesSent, stat.BytesReceived)
+	return err
+}
+
+// Close records the end of the run and commits it, or with commit unset
+// discards it.
+func (self *resultsDB) Close(commit bool) error {
+	defer self.db.Close()
+	if !commit {
+		return self.tx.Rollback()
+	}
+	if _, err := self.tx.Exec(`UPDATE runs SET end_time = ? WHERE id = ?`,
+		time.Now().UTC().Format(time.RFC3339Nano), self.runID); err != nil {
+		self.tx.Rollback()
+		return err
+	}
+	return self.tx.Commit()
+}
@@ -0,0 +1,160 @@
+package bench
+
+import (
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"sync"
+	"time"
+)
+
+// routingAlpha is the weight of a new sample in the per-endpoint latency
+// estimate of latency-aware routing.
+const routingAlpha = 0.2
+
+// router picks the endpoint of the next read of one client, either in turn
+// or at random weighted by the inverse of the estimated endpoint latency.
+type router struct {
+	adaptive bool
+	next     int
+	ewma     []float64 // estimated latency per endpoint in ns
+	rand     *mrand.Rand
+}
+
+func (self *router) pick() int {
+	if !self.adaptive {
+		i := self.next
+		self.next = (self.next + 1) % len(self.ewma)
+		return i
+	}
+	var total float64
+	for _, l := range self.ewma {
+		total += 1 / l
+	}
+	x := self.rand.Float64() * total
+	for i, l := range self.ewma {
+		x -= 1 / l
+		if x < 0 {
+			return i
+		}
+	}
+	return len(self.ewma) - 1
+}
+
+// observe updates the latency estimate of endpoint i.
+func (self *router) observe(i int, d time.Duration) {
+	l := float64(d.Nanoseconds())
+	if l <= 0 {
+		l = 1
+	}
+	if self.ewma[i] == 0 {
+		self.ewma[i] = l
+	} else {
+		self.ewma[i] = routingAlpha*l + (1-routingAlpha)*self.ewma[i]
+	}
+}
+
+// runRouting compares naive round-robin against latency-aware routing of
+// reads across the ensemble. Every client connects to all endpoints and
+// sends NRequests reads. With latency-aware routing, the latency of every
+// endpoint is probed every RoutingProbe reads and, together with the reads
+// themselves, biases the choice toward the faster endpoints. The resulting
+// distribution of reads across servers is written to the routing file.
+func (self *Benchmark) runRouting() {
+	ctx := self.context()
+	rf, err := self.openOutput("routing.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer rf.Close()
+	if info, err := rf.Stat(); err == nil && info.Size() == 0 {
+		rf.WriteString("mode,server,operations,errors,share,average_latency,99th_latency,throughput\n")
+	}
+	if len(self.Endpoints) < 2 {
+		log.Printf("[Bench]: skip latency-aware routing since it needs at least two servers\n")
+		return
+	}
+
+	for _, mode := range []string{"ROUND_ROBIN", "LATENCY"} {
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		stats := make([]BenchStat, len(self.Endpoints))
+		var total BenchStat
+		log.Printf("[Bench]: start %s routing of reads\n", mode)
+		for _, client := range self.clients {
+			wg.Add(1)
+			go func(client *Client) {
+				defer wg.Done()
+				routes := make([]*Client, len(self.Endpoints))
+				for i := range self.Endpoints {
+					// no failover, the router picks the server
+					route, err := NewClient(client.Id, client.Name, self.Servers[i], []string{self.Endpoints[i]}, client.Namespace)
+					if err != nil {
+						client.Log("failed to connect to %s: %v", self.Endpoints[i], err)
+						return
+					}
+					defer route.Conn.Close()
+					route.names = client.names // reads find sequential keys
+					routes[i] = route
+				}
+				r := &router{
+					adaptive: mode == "LATENCY",
+					ewma:     make([]float64, len(routes)),
+					rand:     mrand.New(mrand.NewSource(time.Now().UnixNano() + int64(client.Id))),
+				}
+				probe := func() {
+					for i, route := range routes {
+						begin := time.Now()
+						route.Read(ctx, self.keyAt(0))
+						r.observe(i, time.Since(begin))
+					}
+				}
+				probe()
+				for n := int64(0); n < self.NRequests; n++ {
+					if n > 0 && n%int64(self.RoutingProbe) == 0 && r.adaptive {
+						probe()
+					}
+					i := r.pick()
+					key := self.keyAt(n)
+					if self.RandomAccess {
+						key = self.keyAt(r.rand.Int63n(self.NRequests))
+					}
+					begin := time.Now()
+					_, _, err := routes[i].Read(ctx, key)
+					d := time.Since(begin)
+					if err == nil {
+						r.observe(i, d)
+					}
+					mutex.Lock()
+					stats[i].add(self.Endpoints[i], begin, d, err)
+					total.add(self.Endpoints[i], begin, d, err)
+					mutex.Unlock()
+				}
+			}(client)
+		}
+		wg.Wait()
+
+		total.finish()
+		for i := range stats {
+			stats[i].finish()
+		}
+		rows := append(stats, total)
+		for i := range rows {
+			stat := &rows[i]
+			server := "ALL"
+			if i < len(stats) {
+				server = self.Endpoints[i]
+			}
+			var share, throughput float64
+			if total.Ops > 0 {
+				share = float64(stat.Ops) / float64(total.Ops)
+			}
+			if elapsed := stat.EndTime.Sub(stat.StartTime); elapsed > 0 {
+				throughput = float64(stat.Ops-stat.Errors) / elapsed.Seconds()
+			}
+			rf.WriteString(fmt.Sprintf("%s,%s,%d,%d,%f,%d,%d,%f\n", mode, server, stat.Ops, stat.Errors, share,
+				stat.AvgLatency.Nanoseconds(), stat.NinetyNinethLatency, throughput))
+		}
+		log.Printf("[Bench]: done %s routing of reads: avg latency %s\n", mode, total.AvgLatency)
+	}
+}
@@ -0,0 +1,106 @@
+package bench
+
+import "time"
+
+// runningStats stands in for the latencies of a stat that does not keep
+// them, e.g. while its raw records are streamed. It keeps, as requests are
+// added, what the summary otherwise derives from the latencies; the
+// percentiles of the successful requests come from the digest of the stat.
+type runningStats struct {
+	origin    time.Time        // of the per-second counts
+	seconds   map[int]int      // requests completed in every second since origin
+	servers   map[string]int64 // requests per server
+	errors    *tdigest         // of the time until the failed requests failed
+	corrected *tdigest         // of the corrected latencies, nil without a client_rate
+	jitter    time.Duration    // summed differences of consecutive latencies
+	pairs     int64            // of consecutive latencies summed in jitter
+	last      time.Duration    // latency of the last successful request, -1 before it
+}
+
+func newRunningStats(origin time.Time, corrected bool) *runningStats {
+	running := &runningStats{
+		origin:  origin,
+		seconds: make(map[int]int),
+		servers: make(map[string]int64),
+		errors:  newTDigest(TDIGEST_COMPRESSION),
+		last:    -1,
+	}
+	if corrected {
+		running.corrected = newTDigest(TDIGEST_COMPRESSION)
+	}
+	return running
+}
+
+// add records a measured request, i.e. one that is not part of the warm-up.
+// The jitter is taken in the order the requests complete, which for the
+// parallel request groups of a client approximates the order they started.
+func (self *runningStats) add(latency BenchLatency) {
+	self.seconds[int(latency.Start.Add(latency.Latency).Sub(self.origin).Seconds())]++
+	self.servers[latency.Server]++
+	if latency.Latency < 0 {
+		self.errors.add(latency.ErrLatency)
+		return
+	}
+	self.corrected.add(latency.Corrected())
+	if self.last >= 0 {
+		d := latency.Latency - self.last
+		if d < 0 {
+			d = -d
+		}
+		self.jitter += d
+		self.pairs++
+	}
+	self.last = latency.Latency
+}
+
+// mergeRunningStats returns new running stats of the requests of both,
+// which are left as they are since they may be shared by copies of a stat.
+// The per-second counts are those of a, whose origin is kept, plus those
+// of b moved to it. Either may be nil, of a stat without requests.
+func mergeRunningStats(a, b *runningStats) *runningStats {
+	if a == nil {
+		return b
+	} else if b == nil {
+		return a
+	}
+	merged := newRunningStats(a.origin, a.corrected != nil && b.corrected != nil)
+	shift := int(b.origin.Sub(a.origin).Seconds())
+	for _, r := range []*runningStats{a, b} {
+		for second, n := range r.seconds {
+			if r == b {
+				second += shift
+			}
+			merged.seconds[second] += n
+		}
+		for server, n := range r.servers {
+			merged.servers[server] += n
+		}
+		merged.jitter += r.jitter
+		merged.pairs += r.pairs
+	}
+	merged.errors = mergeTDigests(a.errors, b.errors)
+	if merged.corrected != nil {
+		merged.corrected = mergeTDigests(a.corrected, b.corrected)
+	}
+	return merged
+}
+
+// meanJitter returns the mean difference between consecutive latencies.
+func (self *runningStats) meanJitter() time.Duration {
+	if self.pairs == 0 {
+		return 0
+	}
+	return self.jitter / time.Duration(self.pairs)
+}
+
+// majorityServer returns the server most requests went to, the first in
+// order on a tie, or "" without requests.
+func (self *runningStats) majorityServer() string {
+	var majority string
+	for server, n := range self.servers {
+		if n > self.servers[majority] || (n == self.servers[majority] && server < majority) {
+			majority = server
+		}
+	}
+	return majority
+}
@@ -0,0 +1,78 @@
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"sync"
+	"time"
+)
+
+const rywKey = "ryw"
+
+// runReadYourWrites checks the read-your-writes guarantee of a session.
+// Every client repeatedly writes a new value to its own znode and
+// immediately reads it back on the same connection. The latency covers the
+// write plus the read, and a read that does not return the value just
+// written counts as a violation.
+func (self *Benchmark) runReadYourWrites() {
+	ctx := self.context()
+	rf, err := self.openOutput("ryw.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer rf.Close()
+	if info, err := rf.Stat(); err == nil && info.Size() == 0 {
+		rf.WriteString("client_id,samples,errors,violations,average_write_latency,average_latency,99th_latency,max_latency\n")
+	}
+	stats := make([]BenchStat, len(self.clients))
+	writes := make([]BenchStat, len(self.clients))
+	violations := make([]int, len(self.clients))
+	var wg sync.WaitGroup
+	log.Printf("[Bench]: start read-your-writes for %d samples per client\n", self.RYWSamples)
+	for i, client := range self.clients {
+		wg.Add(1)
+		go func(i int, client *Client) {
+			defer wg.Done()
+			key := fmt.Sprintf("%s.%d", rywKey, client.Id)
+			if _, err := client.CreateIfNotExist(key, nil); err != nil {
+				client.Log("error in creating %s: %v", key, err)
+				return
+			}
+			defer client.Delete(ctx, key)
+			payload := randBytes(mrand.NewSource(time.Now().UnixNano()+int64(i)), self.ValueSizeBytes)
+			for n := 0; n < self.RYWSamples; n++ {
+				// the sequence number keeps consecutive values distinct
+				val := append([]byte(fmt.Sprintf("%d:", n)), payload...)
+				begin := time.Now()
+				err := client.Write(ctx, key, val)
+				writes[i].add(client.ServerAddr(), begin, time.Since(begin), err)
+				if err == nil {
+					var data []byte
+					data, _, err = client.Read(ctx, key)
+					if err == nil && !bytes.Equal(data, val) {
+						violations[i]++
+						client.Log("read-your-writes violation on %s at sample %d", key, n)
+					}
+				}
+				stats[i].add(client.ServerAddr(), begin, time.Since(begin), err)
+			}
+		}(i, client)
+	}
+	wg.Wait()
+	total := 0
+	for i, client := range self.clients {
+		stat := &stats[i]
+		stat.finish()
+		writes[i].finish()
+		total += violations[i]
+		rf.WriteString(fmt.Sprintf("%d,%d,%d,%d,%d,%d,%d,%d\n", client.Id, stat.Ops, stat.Errors, violations[i],
+			writes[i].AvgLatency.Nanoseconds(), stat.AvgLatency.Nanoseconds(), stat.NinetyNinethLatency,
+			stat.MaxLatency.Nanoseconds()))
+	}
+	if total > 0 {
+		log.Printf("[Bench]: WARNING: %d read-your-writes violations\n", total)
+	}
+	log.Printf("[Bench]: done read-your-writes\n")
+}
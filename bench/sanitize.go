@@ -0,0 +1,105 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sanitizedPrefix is added to the file names of sanitized copies.
+const sanitizedPrefix = "sanitized-"
+
+// replacements maps the endpoints of config, the addresses they resolve to
+// and the namespace to placeholders: server0, server1, ... by the position of
+// the endpoint, and /namespace.
+func replacements(config *BenchConfig) map[string]string {
+	repl := make(map[string]string)
+	endpoints := config.Endpoints
+	for _, profile := range config.Profiles {
+		endpoints = append(endpoints, profile.Endpoints...)
+	}
+	n := 0
+	for _, endpoint := range endpoints {
+		if _, ok := repl[endpoint]; ok {
+			continue
+		}
+		placeholder := fmt.Sprintf("server%d", n)
+		n++
+		repl[endpoint] = placeholder
+		host, port, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			continue
+		}
+		// connections report the address the endpoint resolved to
+		if addrs, err := net.LookupHost(host); err == nil {
+			for _, addr := range addrs {
+				repl[net.JoinHostPort(addr, port)] = placeholder
+			}
+		}
+		if _, ok := repl[host]; !ok {
+			repl[host] = placeholder
+		}
+	}
+	if config.Namespace != "" {
+		repl[config.Namespace] = "/namespace"
+	}
+	return repl
+}
+
+// Sanitize writes a copy of every result file of prefix, named with the
+// sanitized- prefix, in which the endpoints of config and the addresses they
+// resolve to are replaced with serverN placeholders and the namespace with
+// /namespace. The run metadata additionally drops the hostname, command
+// line and config path. All numbers are left as they are.
+func Sanitize(prefix string, config *BenchConfig) error {
+	files, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return err
+	}
+	repl := replacements(config)
+	// replace longer strings first, so an endpoint wins over its host
+	var olds []string
+	for old := range repl {
+		olds = append(olds, old)
+	}
+	sort.Slice(olds, func(i, j int) bool { return len(olds[i]) > len(olds[j]) })
+	var pairs []string
+	for _, old := range olds {
+		pairs = append(pairs, old, repl[old])
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	for _, file := range files {
+		dir, name := filepath.Split(file)
+		if strings.HasPrefix(name, sanitizedPrefix) {
+			continue
+		}
+		if info, err := os.Stat(file); err != nil || info.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(name, "meta.json") {
+			var meta RunMeta
+			if err := json.Unmarshal(data, &meta); err != nil {
+				return fmt.Errorf("%s: %v", file, err)
+			}
+			meta.Hostname, meta.Args, meta.ConfigPath = "", nil, ""
+			if data, err = json.MarshalIndent(&meta, "", "  "); err != nil {
+				return err
+			}
+			data = append(data, '\n')
+		}
+		out := filepath.Join(dir, sanitizedPrefix+name)
+		if err := os.WriteFile(out, []byte(replacer.Replace(string(data))), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
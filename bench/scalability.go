@@ -0,0 +1,103 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"sync"
+	"time"
+)
+
+// sweepCounts returns the client counts of the scalability sweep: the powers
+// of two below n, then n itself.
+func sweepCounts(n int) []int {
+	var counts []int
+	for c := 1; c < n; c *= 2 {
+		counts = append(counts, c)
+	}
+	return append(counts, n)
+}
+
+// runClientSweep runs the ClientSweep workload with the first 1, 2, 4, ...
+// clients up to all of them, each client issuing NRequests requests, and
+// writes the aggregate throughput and latency of every client count to the
+// scalability file. Efficiency is the throughput relative to linear scaling
+// from a single client, so the count where it drops marks the onset of
+// contention.
+func (self *Benchmark) runClientSweep() {
+	sf, err := self.openOutput("scalability.csv")
+	if err != nil {
+		panic(err)
+	}
+	defer sf.Close()
+	if info, err := sf.Stat(); err == nil && info.Size() == 0 {
+		sf.WriteString("bench_type,clients,operations,errors,average_latency,99th_latency,throughput,per_client_throughput,efficiency\n")
+	}
+
+	src := mrand.NewSource(time.Now().UnixNano())
+	val := randBytes(src, self.ValueSizeBytes)
+	btype := self.ClientSweep
+	var generator ReqGenerator
+	var handler ReqHandler
+	if btype == WRITE {
+		generator = func(iter int64) *Request { return &Request{self.keyAt(iter), val} }
+		handler = func(ctx context.Context, c *Client, r *Request) error {
+			return c.Write(ctx, r.key, r.value)
+		}
+	} else {
+		generator = func(iter int64) *Request { return &Request{self.keyAt(iter), nil} }
+		handler = func(ctx context.Context, c *Client, r *Request) error {
+			_, _, err := c.Read(ctx, r.key)
+			return err
+		}
+	}
+	var single float64
+	for _, n := range sweepCounts(len(self.clients)) {
+		var wg sync.WaitGroup
+		optype := fmt.Sprintf("SCALE.%s.%d", btype.String(), n)
+		clients := self.clients[:n]
+		for _, client := range clients {
+			client.Stat = nil
+			wg.Add(1)
+			go func(client *Client) {
+				defer wg.Done()
+				client.Log("start bench %s", optype)
+				self.processRequests(client, btype, 0, 1, optype, self.NRequests, 1, self.RandomAccess, false, generator, handler)
+				client.Log("done bench %s", optype)
+			}(client)
+		}
+		wg.Wait()
+
+		var total BenchStat
+		for _, client := range clients {
+			if client.Stat == nil || client.Stat.Ops == 0 {
+				continue
+			}
+			if total.Ops == 0 {
+				total = *client.Stat
+				total.Latencies = append([]BenchLatency(nil), client.Stat.Latencies...)
+			} else {
+				total.Merge(client.Stat)
+			}
+		}
+		if total.Ops == 0 {
+			continue
+		}
+		total.ComputePercentiles()
+		var throughput float64
+		if elapsed := total.EndTime.Sub(total.StartTime); elapsed > 0 {
+			throughput = float64(total.Ops-total.Errors) / elapsed.Seconds()
+		}
+		if n == 1 {
+			single = throughput
+		}
+		efficiency := 1.0
+		if single > 0 {
+			efficiency = throughput / (single * float64(n))
+		}
+		sf.WriteString(fmt.Sprintf("%s,%d,%d,%d,%d,%d,%f,%f,%f\n", btype.String(), n, total.Ops, total.Errors,
+			total.AvgLatency.Nanoseconds(), total.NinetyNinethLatency, throughput, throughput/float64(n), efficiency))
+		log.Printf("[Bench]: %s: avg latency %s, throughput %f req/s\n", optype, total.AvgLatency, throughput)
+	}
+}
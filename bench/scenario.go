@@ -0,0 +1,249 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"gopkg.in/yaml.v3"
+)
+
+// ScenarioPhase is one step of a scenario file, which describes a
+// multi-stage experiment as an ordered list of phases, e.g.
+//
+//	phases:
+//	  - name: fill
+//	    type: create
+//	    requests: 10000
+//	  - name: burst
+//	    type: mixed
+//	    read_ratio: 0.9
+//	    duration: 30s
+//	    rate: 5000
+//	    clients: 8
+//	    distribution: zipf
+//
+// A phase runs until every client has issued its requests or the duration
+// has passed, whichever comes first; at least one of them must be given.
+type ScenarioPhase struct {
+	Name string `yaml:"name"`
+	// Type is one of create, fill, read, write, mixed and delete
+	Type      string        `yaml:"type"`
+	Duration  time.Duration `yaml:"duration"`
+	Requests  int64         `yaml:"requests"` // per client
+	Rate      float64       `yaml:"rate"`     // aggregate req/s, 0 means closed loop
+	Clients   int           `yaml:"clients"`  // the first N clients, 0 means all
+	ReadRatio float64       `yaml:"read_ratio"`
+	// Distribution picks the keys, one of sequential (default), uniform,
+	// zipf and same
+	Distribution string `yaml:"distribution"`
+	Keys         int64  `yaml:"keys"` // key space, 0 means requests in the config
+
+	btype BenchType
+}
+
+var SCENARIO_TYPES = map[string]BenchType{
+	"create": CREATE,
+	"fill":   FILL,
+	"read":   READ,
+	"write":  WRITE,
+	"mixed":  MIXED,
+	"delete": DELETE,
+}
+
+// loadScenario reads and validates the phases of a YAML scenario file.
+func loadScenario(path string) ([]ScenarioPhase, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to read scenario: %v\n", err)
+	}
+	var scenario struct {
+		Phases []ScenarioPhase `yaml:"phases"`
+	}
+	if err := yaml.Unmarshal(data, &scenario); err != nil {
+		return nil, fmt.Errorf("Fail to parse scenario: %v\n", err)
+	}
+	if len(scenario.Phases) == 0 {
+		return nil, fmt.Errorf("Scenario %s has no phases\n", path)
+	}
+	for i := range scenario.Phases {
+		phase := &scenario.Phases[i]
+		if phase.Name == "" {
+			phase.Name = fmt.Sprintf("phase%d", i+1)
+		}
+		btype, ok := SCENARIO_TYPES[phase.Type]
+		if !ok {
+			return nil, fmt.Errorf("Scenario phase '%s' has unknown type '%s'\n", phase.Name, phase.Type)
+		}
+		phase.btype = btype
+		if phase.Duration <= 0 && phase.Requests <= 0 {
+			return nil, fmt.Errorf("Scenario phase '%s' needs a positive duration or requests\n", phase.Name)
+		}
+		if phase.Rate < 0 || phase.Clients < 0 || phase.Keys < 0 {
+			return nil, fmt.Errorf("Scenario phase '%s' has a negative rate, clients or keys\n", phase.Name)
+		}
+		if btype == MIXED && (phase.ReadRatio < 0 || phase.ReadRatio > 1) {
+			return nil, fmt.Errorf("Scenario phase '%s' must have a read_ratio within [0, 1]\n", phase.Name)
+		}
+		switch phase.Distribution {
+		case "":
+			phase.Distribution = "sequential"
+		case "sequential", "uniform", "zipf", "same":
+		default:
+			return nil, fmt.Errorf("Scenario phase '%s' has unknown distribution '%s'\n", phase.Name, phase.Distribution)
+		}
+	}
+	return scenario.Phases, nil
+}
+
+// scenarioOp sends a request of btype and returns the bytes of data it
+// wrote or read.
+func (self *Benchmark) scenarioOp(ctx context.Context, c *Client, btype BenchType, key string, val []byte) (int, error) {
+	switch btype {
+	case CREATE:
+		return len(val), c.Create(ctx, key, val)
+	case READ:
+		data, _, err := c.Read(ctx, key)
+		return len(data), err
+	case DELETE:
+		return 0, c.Delete(ctx, key)
+	default:
+		return len(val), c.Write(ctx, key, val)
+	}
+}
+
+// runScenario executes the scenario phases in order and appends a report
+// per phase to the scenario file.
+func (self *Benchmark) runScenario() {
+	sf, err := self.openOutput("scenario.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer sf.Close()
+	if info, err := sf.Stat(); err == nil && info.Size() == 0 {
+		sf.WriteString("phase,name,type,clients,elapsed,operations,errors,average_latency,99th_latency,throughput" + self.percentileHeader() + ",intended_requests,achieved_rate_percent\n")
+	}
+
+	val := randBytes(mrand.NewSource(time.Now().UnixNano()), self.ValueSizeBytes)
+	for i, phase := range self.Scenario {
+		clients := self.clients
+		if phase.Clients > 0 && phase.Clients < len(clients) {
+			clients = clients[:phase.Clients]
+		}
+		keys := phase.Keys
+		if keys == 0 {
+			keys = self.NRequests
+		}
+		var interval time.Duration
+		if phase.Rate > 0 {
+			// every client offers an even share of the rate
+			interval = time.Duration(float64(time.Second) * float64(len(clients)) / phase.Rate)
+		}
+		log.Printf("[Bench]: start scenario phase %s (%s) with %d clients\n", phase.Name, phase.Type, len(clients))
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		var total BenchStat
+		start := time.Now()
+		// requests still outstanding at the end of a timed phase are
+		// abandoned rather than holding up the next phase
+		ctx, cancel := context.WithCancel(self.context())
+		if phase.Duration > 0 {
+			ctx, cancel = context.WithDeadline(self.context(), start.Add(phase.Duration))
+		}
+		for _, client := range clients {
+			wg.Add(1)
+			go func(client *Client, seed int64) {
+				defer wg.Done()
+				rd := mrand.New(mrand.NewSource(seed))
+				var zipf *mrand.Zipf
+				if phase.Distribution == "zipf" {
+					zipf = mrand.NewZipf(rd, ZIPF_SKEW, 1.0, uint64(keys-1))
+				}
+				stat := BenchStat{OpType: fmt.Sprintf("%s.%s", phase.Name, phase.btype.String())}
+				for n := int64(0); phase.Requests <= 0 || n < phase.Requests; n++ {
+					if interval > 0 {
+						time.Sleep(time.Until(start.Add(time.Duration(n) * interval)))
+					}
+					if phase.Duration > 0 && time.Since(start) >= phase.Duration {
+						break
+					}
+					var key string
+					switch phase.Distribution {
+					case "uniform":
+						key = self.keyAt(rd.Int63n(keys))
+					case "zipf":
+						key = self.keyAt(int64(zipf.Uint64()))
+					case "same":
+						key = sameKey(self.KeySizeBytes)
+					default:
+						key = self.keyAt(n % keys)
+					}
+					op := phase.btype
+					if op == MIXED {
+						op = WRITE
+						if rd.Float64() < phase.ReadRatio {
+							op = READ
+						}
+					}
+					begin := time.Now()
+					size, err := self.scenarioOp(ctx, client, op, key, val)
+					d := time.Since(begin)
+					if err != nil && err == ctx.Err() {
+						break // cut off by the end of the phase
+					}
+					stat.add(client.ServerAddr(), begin, d, err)
+					if self.RawKeys {
+						stat.Latencies[n].Key, stat.Latencies[n].ValueBytes = key, int64(size)
+					}
+					if err != nil {
+						client.Log("error in processing %s request for key %s: %v", stat.OpType, key, err)
+						if err == zk.ErrNoServer {
+							client.Reconnect()
+						}
+					} else {
+						client.ResetBackoff()
+					}
+					if self.rawstream != nil {
+						self.rawstream.Write(client.Id, phase.btype, i+1, n, stat.Latencies[n])
+					}
+				}
+				stat.finish()
+				if stat.Ops == 0 {
+					return
+				}
+				mutex.Lock()
+				defer mutex.Unlock()
+				if total.Ops == 0 {
+					total = stat
+				} else {
+					total.Merge(&stat)
+				}
+			}(client, time.Now().UnixNano()+int64(client.Id))
+		}
+		wg.Wait()
+		cancel()
+		elapsed := time.Since(start)
+		var throughput float64
+		if total.Ops > 0 {
+			total.ComputePercentiles()
+			throughput = float64(total.Ops-total.Errors) / elapsed.Seconds()
+		}
+		if phase.Rate > 0 {
+			total.IntendedOps = intendedOps(phase.Rate, elapsed)
+			if max := phase.Requests * int64(len(clients)); phase.Requests > 0 && total.IntendedOps > max {
+				total.IntendedOps = max
+			}
+		}
+		sf.WriteString(fmt.Sprintf("%d,%s,%s,%d,%s,%d,%d,%d,%d,%f%s%s\n", i+1, phase.Name, phase.Type, len(clients),
+			elapsed, total.Ops, total.Errors, total.AvgLatency.Nanoseconds(), total.NinetyNinethLatency, throughput,
+			self.percentileCols(&total), rateCols(&total)))
+		log.Printf("[Bench]: done scenario phase %s: %d ops, avg latency %s, throughput %f req/s\n",
+			phase.Name, total.Ops, total.AvgLatency, throughput)
+		warnSaturation("scenario phase "+phase.Name, total.IntendedOps, total.Ops)
+	}
+}
@@ -0,0 +1,117 @@
+package bench
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// sessionConn is a ZooKeeper connection with the channel of its session
+// events, which go-zookeeper closes once the connection is closed.
+type sessionConn struct {
+	*zk.Conn
+	events <-chan zk.Event
+	closed int32 // set by Close, the events after are not failures
+}
+
+func (self *sessionConn) Close() {
+	atomic.StoreInt32(&self.closed, 1)
+	self.Conn.Close()
+}
+
+// sessionLog appends the session events of all clients to the
+// session_events file of the current run, if any.
+type sessionLog struct {
+	mutex sync.Mutex
+	path  string
+}
+
+var sessionEvents sessionLog
+
+// open starts a new session events file at path, or continues it.
+func (self *sessionLog) open(path string, fresh bool) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.path = path
+	if fresh {
+		os.Remove(path)
+	}
+}
+
+// record appends an event of a client connected to endpoint.
+func (self *sessionLog) record(t time.Time, id int, endpoint string, event string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.path == "" {
+		return
+	}
+	sf, err := os.OpenFile(self.path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		log.Printf("[Bench]: fail to open session events file: %v\n", err)
+		return
+	}
+	defer sf.Close()
+	if info, err := sf.Stat(); err == nil && info.Size() == 0 {
+		sf.WriteString("time,client_id,endpoint,event\n")
+	}
+	sf.WriteString(fmt.Sprintf("%s,%d,%s,%s\n", t.UTC().Format("2006-01-02T15:04:05.000Z07:00"), id, endpoint, event))
+}
+
+// sessionEventName returns the name of a session state in the session
+// events file, e.g. Disconnected or HasSession.
+func sessionEventName(state zk.State) string {
+	return strings.TrimPrefix(state.String(), "State")
+}
+
+// watchSession logs the state changes of the session of conn and counts
+// the disconnects and expirations, until the connection is closed. Other
+// backends have no session events to watch.
+func (self *Client) watchSession(conn ZKConn) {
+	sc, ok := conn.(*sessionConn)
+	if !ok {
+		return
+	}
+	go self.sessionLoop(sc.events, func() bool { return atomic.LoadInt32(&sc.closed) != 0 })
+}
+
+// sessionLoop handles the session events until the channel is closed.
+// The events once closed returns true come from closing the connection on
+// purpose and are ignored.
+func (self *Client) sessionLoop(events <-chan zk.Event, closed func() bool) {
+	for ev := range events {
+		if ev.Type != zk.EventSession || closed() {
+			continue
+		}
+		switch ev.State {
+		case zk.StateDisconnected:
+			atomic.AddInt64(&self.disconnects, 1)
+		case zk.StateExpired:
+			atomic.AddInt64(&self.expirations, 1)
+		}
+		endpoint := ev.Server
+		if endpoint == "" {
+			endpoint = self.EndPoint
+		}
+		name := sessionEventName(ev.State)
+		self.Log("session %s on %s", name, endpoint)
+		sessionEvents.record(time.Now(), self.Id, endpoint, name)
+	}
+}
+
+// sessionCounts returns the disconnects and expirations of the sessions
+// of the client and its children so far.
+func (self *Client) sessionCounts() (int64, int64) {
+	disconnects, expirations := atomic.LoadInt64(&self.disconnects), atomic.LoadInt64(&self.expirations)
+	for _, child := range self.Children {
+		d, e := child.sessionCounts()
+		disconnects += d
+		expirations += e
+	}
+	return disconnects, expirations
+}
@@ -0,0 +1,72 @@
+package bench
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// TestSessionLoop feeds a fake event channel to the session watcher of a
+// client and checks the counters, the session events file and that the
+// watcher returns once the channel is closed.
+func TestSessionLoop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session_events.csv")
+	sessionEvents.open(path, true)
+	defer sessionEvents.open("", false)
+
+	client := &Client{Id: 7, EndPoint: "localhost:2181"}
+	events := make(chan zk.Event)
+	var closed int32
+	done := make(chan struct{})
+	go func() {
+		client.sessionLoop(events, func() bool { return atomic.LoadInt32(&closed) != 0 })
+		close(done)
+	}()
+	for _, ev := range []zk.Event{
+		{Type: zk.EventSession, State: zk.StateDisconnected, Server: "localhost:2182"},
+		{Type: zk.EventSession, State: zk.StateHasSession},
+		{Type: zk.EventNodeCreated, State: zk.StateDisconnected}, // not a session event
+		{Type: zk.EventSession, State: zk.StateExpired},
+		{Type: zk.EventSession, State: zk.StateDisconnected},
+	} {
+		events <- ev
+	}
+	// the loop takes another event only once it handled the last one
+	events <- zk.Event{Type: zk.EventNodeCreated}
+	// closing the connection on purpose disconnects it
+	atomic.StoreInt32(&closed, 1)
+	events <- zk.Event{Type: zk.EventSession, State: zk.StateDisconnected}
+	close(events)
+	<-done
+
+	if disconnects, expirations := client.sessionCounts(); disconnects != 2 || expirations != 1 {
+		t.Errorf("%d disconnects and %d expirations, want 2 and 1", disconnects, expirations)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	want := []string{
+		"time,client_id,endpoint,event",
+		",7,localhost:2182,Disconnected",
+		",7,localhost:2181,HasSession",
+		",7,localhost:2181,Expired",
+		",7,localhost:2181,Disconnected",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("%d lines in the session events file, want %d:\n%s", len(lines), len(want), data)
+	}
+	for i, line := range lines {
+		if i > 0 {
+			line = line[strings.Index(line, ","):] // without the time
+		}
+		if line != want[i] {
+			t.Errorf("line %d %q, want %q", i, line, want[i])
+		}
+	}
+}
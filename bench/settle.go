@@ -0,0 +1,99 @@
+package bench
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	drainTimeout  = 30 * time.Second       // longest wait for the servers to drain
+	drainInterval = 100 * time.Millisecond // between two polls of the servers
+)
+
+// outstandingRequests asks a ZooKeeper server for its number of queued
+// requests with the mntr four letter word. The command has to be in the
+// server's 4lw.commands.whitelist.
+func outstandingRequests(endpoint string) (int64, error) {
+	conn, err := net.DialTimeout("tcp", endpoint, time.Second)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte("mntr")); err != nil {
+		return 0, err
+	}
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "zk_outstanding_requests" {
+			return strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no zk_outstanding_requests in mntr of %s", endpoint)
+}
+
+// drain polls the servers until none has outstanding requests left or
+// drainTimeout passed. Returns the outstanding requests summed over the
+// servers at the last poll, or -1 if the servers cannot be polled.
+func (self *Benchmark) drain() int64 {
+	deadline := time.Now().Add(drainTimeout)
+	for {
+		var total int64
+		for _, endpoint := range self.Endpoints {
+			n, err := outstandingRequests(endpoint)
+			if err != nil {
+				log.Printf("[Bench]: cannot poll outstanding requests of %s, stop draining: %v\n", endpoint, err)
+				return -1
+			}
+			total += n
+		}
+		if total == 0 || time.Now().After(deadline) {
+			return total
+		}
+		time.Sleep(drainInterval)
+	}
+}
+
+// settle pauses before the bench type btype so the servers can catch up
+// on the requests of the previous one, e.g. the writes of FILL before
+// READ. It waits at least PhaseDelay and then, with PhaseDrain, until the
+// servers report no outstanding requests. The time spent is written to
+// settle.dat.
+func (self *Benchmark) settle(btype BenchType, run int) {
+	if self.PhaseDelay == 0 && !self.PhaseDrain {
+		return
+	}
+	sf, err := self.openOutput("settle.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer sf.Close()
+	if info, err := sf.Stat(); err == nil && info.Size() == 0 {
+		sf.WriteString("bench_type,run,delay,drain_time,settle_time,outstanding\n")
+	}
+	start := time.Now()
+	time.Sleep(self.PhaseDelay)
+	var outstanding int64 = -1
+	drainStart := time.Now()
+	if self.PhaseDrain {
+		if self.Backend == "zookeeper" {
+			outstanding = self.drain()
+		} else {
+			log.Printf("[Bench]: skip draining since the %s backend has no mntr\n", self.Backend)
+		}
+	}
+	drained := time.Since(drainStart)
+	settled := time.Since(start)
+	sf.WriteString(fmt.Sprintf("%s,%d,%d,%d,%d,%d\n", btype.String(), run, self.PhaseDelay.Nanoseconds(),
+		drained.Nanoseconds(), settled.Nanoseconds(), outstanding))
+	log.Printf("[Bench]: settled for %s before %s run %d\n", settled, btype.String(), run)
+}
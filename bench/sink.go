@@ -0,0 +1,162 @@
+package bench
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// METRIC_SINKS lists the exporters the requests can be reported to while
+// the benchmark runs.
+var METRIC_SINKS = []string{"none", "statsd"}
+
+// MetricSink receives every completed request as it happens. RecordOp is
+// called from the request loop and must not block.
+type MetricSink interface {
+	RecordOp(client, optype string, latency time.Duration, err error)
+	Close() error
+}
+
+// checkMetricSink makes sure kind is one of METRIC_SINKS.
+func checkMetricSink(kind string) error {
+	for _, k := range METRIC_SINKS {
+		if k == kind {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown metric sink '%s', must be one of %s\n", kind, strings.Join(METRIC_SINKS, "|"))
+}
+
+// newMetricSink returns the sink of a kind of METRIC_SINKS, sending to addr.
+func newMetricSink(kind, addr string) (MetricSink, error) {
+	if kind == "statsd" {
+		return newStatsdSink(addr)
+	}
+	if err := checkMetricSink(kind); err != nil {
+		return nil, err
+	}
+	return nopSink{}, nil
+}
+
+// teeSink reports the requests to several sinks.
+type teeSink []MetricSink
+
+func (self teeSink) RecordOp(client, optype string, latency time.Duration, err error) {
+	for _, sink := range self {
+		sink.RecordOp(client, optype, latency, err)
+	}
+}
+
+func (self teeSink) Close() error {
+	var first error
+	for _, sink := range self {
+		if err := sink.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+type nopSink struct{}
+
+func (nopSink) RecordOp(client, optype string, latency time.Duration, err error) {}
+
+func (nopSink) Close() error { return nil }
+
+const (
+	statsdPacketBytes   = 1432 // fits an Ethernet MTU with IP and UDP headers
+	statsdFlushInterval = 100 * time.Millisecond
+)
+
+// statsdSink sends the requests to a statsd agent over UDP as the counters
+// zkbench.<client>.<optype>.ops and .errors and the timer .latency in ms.
+// RecordOp only queues the lines; a goroutine packs them into packets.
+// Lines that do not fit into the queue are dropped and counted rather
+// than slowing down the requests.
+type statsdSink struct {
+	conn    net.Conn
+	lines   chan string
+	done    chan struct{}
+	dropped int64
+}
+
+func newStatsdSink(addr string) (*statsdSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	self := &statsdSink{
+		conn:  conn,
+		lines: make(chan string, 65536),
+		done:  make(chan struct{}),
+	}
+	go self.loop()
+	return self, nil
+}
+
+// statsdName replaces the characters statsd treats specially in a name.
+func statsdName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', ':', '|', '@', '#', ' ', '/':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+func (self *statsdSink) RecordOp(client, optype string, latency time.Duration, err error) {
+	prefix := "zkbench." + statsdName(client) + "." + statsdName(optype)
+	line := prefix + ".ops:1|c\n"
+	if err != nil {
+		line += prefix + ".errors:1|c\n"
+	} else {
+		line += fmt.Sprintf("%s.latency:%f|ms\n", prefix, float64(latency.Nanoseconds())/1e6)
+	}
+	select {
+	case self.lines <- line:
+	default:
+		atomic.AddInt64(&self.dropped, 1)
+	}
+}
+
+func (self *statsdSink) loop() {
+	defer close(self.done)
+	ticker := time.NewTicker(statsdFlushInterval)
+	defer ticker.Stop()
+	var packet []byte
+	flush := func() {
+		if len(packet) > 0 {
+			self.conn.Write(packet) // best effort like statsd itself
+			packet = packet[:0]
+		}
+	}
+	for {
+		select {
+		case line, ok := <-self.lines:
+			if !ok {
+				flush()
+				return
+			}
+			if len(packet)+len(line) > statsdPacketBytes {
+				flush()
+			}
+			packet = append(packet, line...)
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Close sends the queued lines and closes the connection.
+func (self *statsdSink) Close() error {
+	close(self.lines)
+	<-self.done
+	if dropped := atomic.LoadInt64(&self.dropped); dropped > 0 {
+		log.Printf("[Bench]: dropped %d requests the statsd sink could not keep up with\n", dropped)
+	}
+	return self.conn.Close()
+}
@@ -1,50 +1,555 @@
 package bench
 
 import (
+	"fmt"
+	"math"
+	"sort"
 	"time"
 )
 
 type BenchLatency struct {
 	Start   time.Time
-	Latency time.Duration
+	Latency time.Duration // -1 for a failed request
+	// ErrLatency is the time until a failed request failed, 0 for a
+	// successful one
+	ErrLatency time.Duration
+	Server     string // the server the request was sent to
+	// Queued is how late the request started after its scheduled start
+	// with a client_rate, 0 without
+	Queued time.Duration
+	// TimedOut marks a failed request that exceeded the OpTimeout
+	TimedOut bool
+	// Key is the key of the request relative to the namespace and
+	// ValueBytes the data it wrote or read, only set with RawKeys
+	Key        string
+	ValueBytes int64
+	// Warmup marks a request excluded from the stats by measure_after or
+	// skip_first_ops
+	Warmup bool
+}
+
+// Corrected returns the latency from the scheduled start of the request to
+// its completion, which unlike the service latency includes the time it
+// waited behind earlier requests. Failed requests keep a latency of -1.
+func (self BenchLatency) Corrected() time.Duration {
+	if self.Latency < 0 {
+		return self.Latency
+	}
+	return self.Latency + self.Queued
+}
+
+// Elapsed returns the time the request took, whether it succeeded or
+// failed.
+func (self BenchLatency) Elapsed() time.Duration {
+	if self.Latency < 0 {
+		return self.ErrLatency
+	}
+	return self.Latency
+}
+
+// correctedLatencies returns a copy of lats with the corrected latencies.
+func correctedLatencies(lats []BenchLatency) []BenchLatency {
+	corrected := make([]BenchLatency, len(lats))
+	for i, l := range lats {
+		corrected[i] = l
+		corrected[i].Latency = l.Corrected()
+	}
+	return corrected
 }
 
 type BenchStat struct {
-	Ops          int64
-	Errors       int64
-	OpType       string
-	StartTime    time.Time
-	EndTime      time.Time
-	Latencies    []BenchLatency
-	MinLatency   time.Duration
-	MaxLatency   time.Duration
-	AvgLatency   time.Duration
-	NinetyNinethLatency  int64
-	TotalLatency time.Duration
-	Throughput   float64
+	Ops      int64
+	Errors   int64
+	Timeouts int64 // of the Errors, requests that exceeded the OpTimeout
+	// Disconnects and Expirations count the session events of the clients
+	// during the stat
+	Disconnects int64
+	Expirations int64
+	// IntendedOps is the number of requests the rate limit called for over
+	// the time span of the stat, 0 without a rate limit
+	IntendedOps int64
+	// BenchType, SubType and Run identify the bench run of the stat. SubType
+	// is the request type of a MIXED run, 0 for other runs and for a stat
+	// merged over several request types
+	BenchType BenchType
+	SubType   BenchType
+	Run       int
+	// WarmupOps is the number of requests excluded from the stats as
+	// warm-up, only streamed to the raw output
+	WarmupOps           int64
+	OpType              string // label for logs, see Label
+	StartTime           time.Time
+	EndTime             time.Time
+	Latencies           []BenchLatency
+	MinLatency          time.Duration
+	MaxLatency          time.Duration
+	AvgLatency          time.Duration
+	NinetyNinethLatency int64
+	TotalLatency        time.Duration
+	Throughput          float64 // requests per second of wall-clock time
+	// ServiceTimeThroughput is the requests per second of summed latency,
+	// i.e. the rate of a client waiting for one request at a time
+	ServiceTimeThroughput float64
+	// the latencies of the failed requests, kept apart from the ones of the
+	// successful requests above; ErrP99Latency is set by ComputePercentiles
+	ErrMinLatency   time.Duration
+	ErrMaxLatency   time.Duration
+	ErrAvgLatency   time.Duration
+	ErrTotalLatency time.Duration
+	ErrP99Latency   time.Duration
+	// the latency percentiles set by ComputePercentiles
+	P50Latency  time.Duration
+	P90Latency  time.Duration
+	P95Latency  time.Duration
+	P99Latency  time.Duration
+	P999Latency time.Duration
+	// StdDevLatency is the sample standard deviation of the successful
+	// latencies, kept up to date from the running mean and sum of squared
+	// deviations (in ns) as requests are added
+	StdDevLatency time.Duration
+	latencyMean   float64
+	latencyM2     float64
+	// digest sketches the successful latencies with latency_mode tdigest,
+	// nil otherwise
+	digest *tdigest
+	// running replaces the latencies of a stat that does not keep them,
+	// nil otherwise
+	running *runningStats
+	// estimated bytes on the wire, i.e. payload plus protocol overhead
+	BytesSent     int64
+	BytesReceived int64
+	// ThinkTime is the total pause between the requests
+	ThinkTime time.Duration
+}
+
+// statLabel returns the human-readable name of a bench run, e.g.
+// MIXED.READ.3 or WRITE.1.
+func statLabel(btype, subtype BenchType, run int) string {
+	if subtype == 0 {
+		return fmt.Sprintf("%s.%d", btype.String(), run)
+	}
+	return fmt.Sprintf("%s.%s.%d", btype.String(), subtype.String(), run)
+}
+
+// Label returns the human-readable name of the bench run of the stat.
+func (self *BenchStat) Label() string {
+	return statLabel(self.BenchType, self.SubType, self.Run)
 }
 
+// Merge adds the requests of other, e.g. of a child client, to the stat.
+// Counts, bytes, think time and total latency add up, the latencies are
+// concatenated, and the time span covers both stats. The min and max
+// latency are the extremes over the successful requests of both; a stat
+// without successful requests has no min, so its zero does not win. The
+// average latency and throughputs are recomputed from the merged values,
+// while the percentiles have to be recomputed with ComputePercentiles.
+// The digests are merged too, unless one of the stats has successful
+// requests but no digest, and so are the running stats of stats that do
+// not keep their latencies.
 func (self *BenchStat) Merge(other *BenchStat) {
+	selfOps := self.Ops
+	selfOK := self.Ops - self.Errors
+	otherOK := other.Ops - other.Errors
+	if self.BenchType != other.BenchType {
+		self.BenchType = 0 // e.g. CREATE and FILL of SETUP
+	}
+	if self.SubType != other.SubType {
+		self.SubType = 0
+	}
+	self.mergeErrLatencies(other)
 	self.Ops += other.Ops
 	self.Errors += other.Errors
-	// other starts earlier than me
-	if self.StartTime.After(other.StartTime) {
+	self.Timeouts += other.Timeouts
+	self.Disconnects += other.Disconnects
+	self.Expirations += other.Expirations
+	self.IntendedOps += other.IntendedOps
+	self.WarmupOps += other.WarmupOps
+	self.BytesSent += other.BytesSent
+	self.BytesReceived += other.BytesReceived
+	self.ThinkTime += other.ThinkTime
+	// other starts earlier than me, or I have not started
+	if self.StartTime.IsZero() || (!other.StartTime.IsZero() && self.StartTime.After(other.StartTime)) {
 		self.StartTime = other.StartTime
 	}
 	// other ends later than me
 	if other.EndTime.After(self.EndTime) {
 		self.EndTime = other.EndTime
 	}
-	// concatenate two slices
-	self.Latencies = append(self.Latencies, other.Latencies...)
-	if self.MinLatency > other.MinLatency {
+	// concatenate two slices, but the slots of unissued requests
+	self.Latencies = appendIssued(self.Latencies, other.Latencies)
+	if otherOK > 0 && (selfOK == 0 || self.MinLatency > other.MinLatency) {
 		self.MinLatency = other.MinLatency
 	}
 	if self.MaxLatency < other.MaxLatency {
 		self.MaxLatency = other.MaxLatency
 	}
 	self.TotalLatency += other.TotalLatency
-	// recalculate average latency
-	self.AvgLatency = self.TotalLatency / time.Duration(self.Ops)
-	self.Throughput = float64(self.Ops) / self.TotalLatency.Seconds()
+	self.mergeMoments(selfOK, otherOK, other)
+	if (selfOK > 0 && self.digest == nil) || (otherOK > 0 && other.digest == nil) {
+		self.digest = nil
+	} else {
+		self.digest = mergeTDigests(self.digest, other.digest)
+	}
+	if (selfOps > 0 && self.running == nil) || (other.Ops > 0 && other.running == nil) {
+		self.running = nil
+	} else {
+		self.running = mergeRunningStats(self.running, other.running)
+	}
+	self.computeAvgLatency()
+	self.computeThroughput()
+}
+
+// mergeErrLatencies merges the latencies of the failed requests of other,
+// before the errors are added up.
+func (self *BenchStat) mergeErrLatencies(other *BenchStat) {
+	if other.Errors > 0 && (self.Errors == 0 || self.ErrMinLatency > other.ErrMinLatency) {
+		self.ErrMinLatency = other.ErrMinLatency
+	}
+	if self.ErrMaxLatency < other.ErrMaxLatency {
+		self.ErrMaxLatency = other.ErrMaxLatency
+	}
+	self.ErrTotalLatency += other.ErrTotalLatency
+	self.ErrAvgLatency = 0
+	if errors := self.Errors + other.Errors; errors > 0 {
+		self.ErrAvgLatency = self.ErrTotalLatency / time.Duration(errors)
+	}
+}
+
+// observeError adds the latency d of a failed request that has already
+// been counted in Errors.
+func (self *BenchStat) observeError(d time.Duration) {
+	if self.Errors == 1 || d < self.ErrMinLatency {
+		self.ErrMinLatency = d
+	}
+	if self.Errors == 1 || d > self.ErrMaxLatency {
+		self.ErrMaxLatency = d
+	}
+	self.ErrTotalLatency += d
+	self.ErrAvgLatency = self.ErrTotalLatency / time.Duration(self.Errors)
+}
+
+// excludeWarmup removes the warm-up requests from the latencies.
+func (self *BenchStat) excludeWarmup() {
+	measured := self.Latencies[:0]
+	for _, l := range self.Latencies {
+		if !l.Warmup {
+			measured = append(measured, l)
+		}
+	}
+	self.Latencies = measured
+}
+
+// appendIssued appends the issued requests of lats to to. A request that
+// was never issued, e.g. past the end of a group, leaves a zero slot
+// without a start in the preallocated latencies.
+func appendIssued(to, lats []BenchLatency) []BenchLatency {
+	for _, l := range lats {
+		if !l.Start.IsZero() {
+			to = append(to, l)
+		}
+	}
+	return to
+}
+
+// dropUnissued removes the slots of unissued requests from the latencies,
+// so that they do not count as successes without latency.
+func (self *BenchStat) dropUnissued() {
+	self.Latencies = appendIssued(self.Latencies[:0], self.Latencies)
+}
+
+// observe adds the latency of a successful request to the running mean
+// and sum of squared deviations with Welford's algorithm. ok is the number
+// of successful requests including this one.
+func (self *BenchStat) observe(ok int64, d time.Duration) {
+	x := float64(d.Nanoseconds())
+	delta := x - self.latencyMean
+	self.latencyMean += delta / float64(ok)
+	self.latencyM2 += delta * (x - self.latencyMean)
+	self.computeStdDev(ok)
+}
+
+// mergeMoments combines the running moments of other into mine, given the
+// number of successful requests of both before merging.
+func (self *BenchStat) mergeMoments(selfOK, otherOK int64, other *BenchStat) {
+	n := selfOK + otherOK
+	if otherOK == 0 || n == 0 {
+		return
+	}
+	delta := other.latencyMean - self.latencyMean
+	self.latencyMean += delta * float64(otherOK) / float64(n)
+	self.latencyM2 += other.latencyM2 + delta*delta*float64(selfOK)*float64(otherOK)/float64(n)
+	self.computeStdDev(n)
+}
+
+func (self *BenchStat) computeStdDev(ok int64) {
+	self.StdDevLatency = 0
+	if ok > 1 {
+		self.StdDevLatency = time.Duration(math.Sqrt(self.latencyM2 / float64(ok-1)))
+	}
+}
+
+// CVLatency returns the coefficient of variation of the successful
+// latencies, i.e. their standard deviation over their mean.
+func (self *BenchStat) CVLatency() float64 {
+	if self.latencyMean == 0 {
+		return 0
+	}
+	return float64(self.StdDevLatency.Nanoseconds()) / self.latencyMean
+}
+
+// computeAvgLatency sets the average latency of the successful requests,
+// the only ones whose latencies are in TotalLatency.
+func (self *BenchStat) computeAvgLatency() {
+	self.AvgLatency = 0
+	if ok := self.Ops - self.Errors; ok > 0 {
+		self.AvgLatency = self.TotalLatency / time.Duration(ok)
+	}
+}
+
+// computeThroughput sets the throughputs from the requests, the time span
+// and the summed latency of the stat. For parallel requests the latencies
+// overlap, so only the wall-clock throughput reflects the actual rate.
+func (self *BenchStat) computeThroughput() {
+	self.Throughput = 0
+	if elapsed := self.EndTime.Sub(self.StartTime); elapsed > 0 {
+		self.Throughput = float64(self.Ops) / elapsed.Seconds()
+	}
+	self.ServiceTimeThroughput = 0
+	if self.TotalLatency > 0 {
+		self.ServiceTimeThroughput = float64(self.Ops) / self.TotalLatency.Seconds()
+	}
+}
+
+// addBytes accounts the payload bytes moved by ops requests plus the
+// protocol overhead of each request and response.
+func (self *BenchStat) addBytes(sent, received, ops, overhead int64) {
+	self.BytesSent += sent + ops*overhead
+	self.BytesReceived += received + ops*overhead
+}
+
+// add records one completed request to server that started at begin and
+// took d. Failed requests are kept with a latency of -1 like in
+// processRequests. Concurrent requests complete out of order, so the stat
+// starts at the earliest begin rather than that of the first one added.
+func (self *BenchStat) add(server string, begin time.Time, d time.Duration, err error) {
+	if self.Ops == 0 || begin.Before(self.StartTime) {
+		self.StartTime = begin
+	}
+	self.Ops++
+	if err != nil {
+		self.Errors++
+		timedOut := err == ErrOpTimeout
+		if timedOut {
+			self.Timeouts++
+		}
+		self.Latencies = append(self.Latencies, BenchLatency{Start: begin, Latency: -1, ErrLatency: d, Server: server,
+			TimedOut: timedOut})
+		self.observeError(d)
+	} else {
+		self.Latencies = append(self.Latencies, BenchLatency{Start: begin, Latency: d, Server: server})
+		if self.Ops-self.Errors == 1 || d < self.MinLatency {
+			self.MinLatency = d
+		}
+		if d > self.MaxLatency {
+			self.MaxLatency = d
+		}
+		self.TotalLatency += d
+		self.observe(self.Ops-self.Errors, d)
+		self.digest.add(d)
+	}
+	if end := begin.Add(d); end.After(self.EndTime) {
+		self.EndTime = end
+	}
+}
+
+// finish computes the derived stats once all requests have been added.
+func (self *BenchStat) finish() {
+	if self.Ops == 0 {
+		return
+	}
+	self.ComputePercentiles()
+	self.computeAvgLatency()
+	self.computeThroughput()
+}
+
+// Jitter returns the mean absolute difference between the latencies of
+// consecutive successful requests in the order they were issued.
+func (self *BenchStat) Jitter() time.Duration {
+	if self.running != nil {
+		return self.running.meanJitter()
+	}
+	var lats []BenchLatency
+	for _, l := range self.Latencies {
+		if l.Latency >= 0 && !l.Start.IsZero() {
+			lats = append(lats, l)
+		}
+	}
+	if len(lats) < 2 {
+		return 0
+	}
+	sort.SliceStable(lats, func(i, j int) bool { return lats[i].Start.Before(lats[j].Start) })
+	var total time.Duration
+	for i := 1; i < len(lats); i++ {
+		d := lats[i].Latency - lats[i-1].Latency
+		if d < 0 {
+			d = -d
+		}
+		total += d
+	}
+	return total / time.Duration(len(lats)-1)
+}
+
+// errLatencies returns the failed requests among lats with the time until
+// they failed as their latency, to compute percentiles over them.
+func errLatencies(lats []BenchLatency) []BenchLatency {
+	var failed []BenchLatency
+	for _, l := range lats {
+		if l.Latency < 0 {
+			l.Latency = l.ErrLatency
+			failed = append(failed, l)
+		}
+	}
+	return failed
+}
+
+// latencyPercentiles returns the nearest-rank percentiles ps, each in
+// (0, 1], of the successful requests among lats. Failed requests, whose
+// latency is -1, are skipped; without successful requests all are 0.
+func latencyPercentiles(lats []BenchLatency, ps []float64) []time.Duration {
+	// a plain int64 copy is a fraction of the size of the records
+	values := make(int64Slice, 0, len(lats))
+	for _, l := range lats {
+		if l.Latency >= 0 {
+			values = append(values, int64(l.Latency))
+		}
+	}
+	scores := make([]time.Duration, len(ps))
+	if len(values) == 0 {
+		return scores
+	}
+	sort.Sort(values)
+	for i, p := range ps {
+		rank := int(math.Ceil(p * float64(len(values))))
+		if rank < 1 {
+			rank = 1
+		} else if rank > len(values) {
+			rank = len(values)
+		}
+		scores[i] = time.Duration(values[rank-1])
+	}
+	return scores
+}
+
+// BenchBucket is the stat of the requests that started in one interval.
+type BenchBucket struct {
+	Ops        int64
+	Errors     int64
+	AvgLatency time.Duration // of the successful requests
+	P99Latency time.Duration
+}
+
+// latencyBuckets splits lats into n buckets of interval by the start of
+// the requests since origin. Requests outside of the buckets are counted
+// in the first or last one.
+func latencyBuckets(lats []BenchLatency, origin time.Time, interval time.Duration, n int) []BenchBucket {
+	split := make([][]BenchLatency, n)
+	for _, latency := range lats {
+		i := int(latency.Start.Sub(origin) / interval)
+		if i < 0 {
+			i = 0
+		} else if i >= n {
+			i = n - 1
+		}
+		split[i] = append(split[i], latency)
+	}
+	buckets := make([]BenchBucket, n)
+	for i, bucket := range split {
+		var total time.Duration
+		for _, latency := range bucket {
+			if latency.Latency < 0 {
+				buckets[i].Errors++
+			} else {
+				total += latency.Latency
+			}
+		}
+		buckets[i].Ops = int64(len(bucket))
+		if ok := buckets[i].Ops - buckets[i].Errors; ok > 0 {
+			buckets[i].AvgLatency = total / time.Duration(ok)
+		}
+		buckets[i].P99Latency = latencyPercentiles(bucket, []float64{.99})[0]
+	}
+	return buckets
+}
+
+// Buckets returns the stats of the requests in every interval since the
+// start of the stat. They are taken from the latencies, so the buckets of
+// a merged stat cover the requests of all merged stats.
+func (self *BenchStat) Buckets(interval time.Duration) []BenchBucket {
+	if self.Ops == 0 || interval <= 0 {
+		return nil
+	}
+	return latencyBuckets(self.Latencies, self.StartTime, interval, int(self.EndTime.Sub(self.StartTime)/interval)+1)
+}
+
+// MajorityServer returns the server most requests of the stat went to,
+// the first in order on a tie, or "" without requests.
+func (self *BenchStat) MajorityServer() string {
+	if self.running != nil {
+		return self.running.majorityServer()
+	}
+	counts := make(map[string]int)
+	for _, l := range self.Latencies {
+		counts[l.Server]++
+	}
+	var majority string
+	for server, n := range counts {
+		if n > counts[majority] || (n == counts[majority] && server < majority) {
+			majority = server
+		}
+	}
+	return majority
+}
+
+// percentiles returns the percentiles ps, each in (0, 1], of the
+// successful requests, estimated from the digest if the stat has one.
+func (self *BenchStat) percentiles(ps []float64) []time.Duration {
+	if self.digest == nil {
+		return latencyPercentiles(self.Latencies, ps)
+	}
+	scores := make([]time.Duration, len(ps))
+	for i, p := range ps {
+		scores[i] = self.digest.quantile(p)
+	}
+	return scores
+}
+
+// correctedPercentiles returns the percentiles ps, each in (0, 1], of the
+// corrected latencies of the successful requests.
+func (self *BenchStat) correctedPercentiles(ps []float64) []time.Duration {
+	if self.running == nil || self.running.corrected == nil {
+		return latencyPercentiles(correctedLatencies(self.Latencies), ps)
+	}
+	scores := make([]time.Duration, len(ps))
+	for i, p := range ps {
+		scores[i] = self.running.corrected.quantile(p)
+	}
+	return scores
+}
+
+// ComputePercentiles sets the p50, p90, p95, p99 and p99.9 latency of the
+// successful requests from the collected latencies, or the digest, so it
+// is also correct for a stat merged from several others.
+// NinetyNinethLatency is updated to match. Returns the p50, p90 and p99
+// latency.
+func (self *BenchStat) ComputePercentiles() (p50, p90, p99 time.Duration) {
+	scores := self.percentiles([]float64{.5, .9, .95, .99, .999})
+	self.P50Latency, self.P90Latency, self.P95Latency = scores[0], scores[1], scores[2]
+	self.P99Latency, self.P999Latency = scores[3], scores[4]
+	self.NinetyNinethLatency = self.P99Latency.Nanoseconds()
+	if self.running != nil {
+		self.ErrP99Latency = self.running.errors.quantile(.99)
+	} else {
+		self.ErrP99Latency = latencyPercentiles(errLatencies(self.Latencies), []float64{.99})[0]
+	}
+	return self.P50Latency, self.P90Latency, self.P99Latency
 }
@@ -0,0 +1,266 @@
+package bench
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// latencies returns the records of requests with the latencies ds, -1
+// for a failed request.
+func latencies(ds ...time.Duration) []BenchLatency {
+	lats := make([]BenchLatency, len(ds))
+	for i, d := range ds {
+		lats[i].Latency = d
+	}
+	return lats
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	tests := []struct {
+		name string
+		lats []BenchLatency
+		ps   []float64
+		want []time.Duration
+	}{
+		{"empty", nil, []float64{.5, .99}, []time.Duration{0, 0}},
+		{"failed only", latencies(-1, -1), []float64{.5}, []time.Duration{0}},
+		{"single", latencies(7), []float64{.01, .5, 1}, []time.Duration{7, 7, 7}},
+		{"nearest rank", latencies(10, 9, 8, 7, 6, 5, 4, 3, 2, 1), []float64{.1, .5, .9, .95, .99, 1},
+			[]time.Duration{1, 5, 9, 10, 10, 10}},
+		{"rank rounds up", latencies(1, 2, 3, 4), []float64{.26, .5, .51, .75}, []time.Duration{2, 2, 3, 3}},
+		{"failed skipped", latencies(-1, 4, -1, 2, 3, 1, -1), []float64{.25, .5, 1}, []time.Duration{1, 2, 4}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := latencyPercentiles(test.lats, test.ps)
+			for i := range test.want {
+				if got[i] != test.want[i] {
+					t.Errorf("p%g = %d, want %d", test.ps[i]*100, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+// statOf returns a finished stat of requests that start every
+// millisecond from begin with the latencies ds, -1 for a failed request.
+func statOf(begin time.Time, ds ...time.Duration) *BenchStat {
+	stat := new(BenchStat)
+	failed := errors.New("failed")
+	for i, d := range ds {
+		start := begin.Add(time.Duration(i) * time.Millisecond)
+		if d < 0 {
+			stat.add("s", start, 500*time.Microsecond, failed)
+		} else {
+			stat.add("s", start, d, nil)
+		}
+	}
+	stat.finish()
+	return stat
+}
+
+func TestMerge(t *testing.T) {
+	begin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ms := time.Millisecond
+	tests := []struct {
+		name       string
+		a, b       *BenchStat
+		ops        int64
+		errors     int64
+		min, max   time.Duration
+		avg        time.Duration
+		throughput float64
+		start, end time.Duration // after begin
+	}{
+		{
+			name: "both empty",
+			a:    new(BenchStat),
+			b:    new(BenchStat),
+		},
+		{
+			name: "into empty",
+			a:    new(BenchStat),
+			b:    statOf(begin, 1*ms, 3*ms),
+			ops:  2, min: 1 * ms, max: 3 * ms, avg: 2 * ms,
+			throughput: 2 / (4 * ms).Seconds(), end: 4 * ms,
+		},
+		{
+			name: "empty",
+			a:    statOf(begin, 1*ms, 3*ms),
+			b:    new(BenchStat),
+			ops:  2, min: 1 * ms, max: 3 * ms, avg: 2 * ms,
+			throughput: 2 / (4 * ms).Seconds(), end: 4 * ms,
+		},
+		{
+			name: "parallel children",
+			a:    statOf(begin, 1*ms, 3*ms),
+			b:    statOf(begin.Add(ms), 2*ms, 6*ms),
+			ops:  4, min: 1 * ms, max: 6 * ms, avg: 3 * ms,
+			// from the start of a to the end of the last request of b
+			throughput: 4 / (8 * ms).Seconds(), end: 8 * ms,
+		},
+		{
+			name: "into all errors",
+			a:    statOf(begin, -1, -1),
+			b:    statOf(begin, 2*ms, 4*ms),
+			ops:  4, errors: 2, min: 2 * ms, max: 4 * ms, avg: 3 * ms,
+			throughput: 4 / (5 * ms).Seconds(), end: 5 * ms,
+		},
+		{
+			name: "all errors",
+			a:    statOf(begin, 2*ms, 4*ms),
+			b:    statOf(begin, -1, -1),
+			ops:  4, errors: 2, min: 2 * ms, max: 4 * ms, avg: 3 * ms,
+			throughput: 4 / (5 * ms).Seconds(), end: 5 * ms,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged := *test.a
+			merged.Merge(test.b)
+			if merged.Ops != test.ops || merged.Errors != test.errors {
+				t.Errorf("ops %d errors %d, want %d and %d", merged.Ops, merged.Errors, test.ops, test.errors)
+			}
+			if merged.MinLatency != test.min || merged.MaxLatency != test.max {
+				t.Errorf("min %s max %s, want %s and %s", merged.MinLatency, merged.MaxLatency, test.min, test.max)
+			}
+			if merged.AvgLatency != test.avg {
+				t.Errorf("average %s, want %s", merged.AvgLatency, test.avg)
+			}
+			if math.Abs(merged.Throughput-test.throughput) > 1e-6*test.throughput {
+				t.Errorf("throughput %f, want %f", merged.Throughput, test.throughput)
+			}
+			if test.ops == 0 {
+				if !merged.StartTime.IsZero() || !merged.EndTime.IsZero() {
+					t.Errorf("start %s end %s of no requests", merged.StartTime, merged.EndTime)
+				}
+			} else if merged.StartTime != begin.Add(test.start) || merged.EndTime != begin.Add(test.end) {
+				t.Errorf("start %s end %s, want %s and %s", merged.StartTime, merged.EndTime, begin.Add(test.start), begin.Add(test.end))
+			}
+			if n := int64(len(merged.Latencies)); n != test.ops {
+				t.Errorf("%d latencies, want %d", n, test.ops)
+			}
+		})
+	}
+}
+
+func TestMergeErrLatencies(t *testing.T) {
+	begin := time.Now()
+	merged := *statOf(begin, -1, time.Millisecond)
+	merged.Merge(statOf(begin, -1, -1))
+	if merged.ErrAvgLatency != 500*time.Microsecond || merged.ErrMaxLatency != 500*time.Microsecond {
+		t.Errorf("error average %s max %s, want 500µs", merged.ErrAvgLatency, merged.ErrMaxLatency)
+	}
+	merged.ComputePercentiles()
+	if merged.ErrP99Latency != 500*time.Microsecond {
+		t.Errorf("error p99 %s, want 500µs", merged.ErrP99Latency)
+	}
+	if merged.P99Latency != time.Millisecond {
+		t.Errorf("p99 %s, want 1ms", merged.P99Latency)
+	}
+}
+
+func TestAddOutOfOrder(t *testing.T) {
+	begin := time.Now()
+	ms := time.Millisecond
+	var stat BenchStat
+	// a slow request completes after a later fast one
+	stat.add("s", begin.Add(2*ms), ms, nil)
+	stat.add("s", begin, 5*ms, nil)
+	stat.finish()
+	if !stat.StartTime.Equal(begin) || !stat.EndTime.Equal(begin.Add(5*ms)) {
+		t.Errorf("span %s to %s, want 0 to 5ms", stat.StartTime.Sub(begin), stat.EndTime.Sub(begin))
+	}
+	if stat.Throughput != 2/(5*ms).Seconds() {
+		t.Errorf("throughput %f, want %f", stat.Throughput, 2/(5*ms).Seconds())
+	}
+}
+
+func TestMergeUnissued(t *testing.T) {
+	begin := time.Now()
+	ms := time.Millisecond
+	// the zero slots of requests a stat never issued
+	other := statOf(begin, 3*ms)
+	other.Latencies = append(other.Latencies, BenchLatency{}, BenchLatency{})
+	merged := statOf(begin, ms, 2*ms)
+	merged.Latencies = append(merged.Latencies, BenchLatency{})
+	merged.dropUnissued()
+	merged.Merge(other)
+	if n := int64(len(merged.Latencies)); n != merged.Ops {
+		t.Fatalf("%d latencies of %d operations", n, merged.Ops)
+	}
+	merged.ComputePercentiles()
+	if merged.P50Latency != 2*ms || merged.P90Latency != 3*ms {
+		t.Errorf("p50 %s p90 %s, want 2ms and 3ms", merged.P50Latency, merged.P90Latency)
+	}
+}
+
+func TestStdDevLatency(t *testing.T) {
+	uniform := make([]time.Duration, 1000)
+	for i := range uniform {
+		uniform[i] = time.Duration(i+1) * time.Microsecond
+	}
+	n := float64(len(uniform))
+	tests := []struct {
+		name   string
+		ds     []time.Duration
+		stddev float64 // of the sample, in ns
+		mean   float64
+	}{
+		{"constant", []time.Duration{3e6, 3e6, 3e6, 3e6, 3e6}, 0, 3e6},
+		{"two-point", []time.Duration{1e6, 3e6, 1e6, 3e6}, math.Sqrt(4.0/3) * 1e6, 2e6},
+		{"uniform", uniform, math.Sqrt(n*(n+1)/12) * 1e3, (n + 1) / 2 * 1e3},
+	}
+	begin := time.Now()
+	for _, test := range tests {
+		half := len(test.ds) / 2
+		// a failed request in between does not count
+		first := append(append([]time.Duration{}, test.ds[:half]...), -1)
+		stats := map[string]*BenchStat{
+			"whole":  statOf(begin, test.ds...),
+			"merged": statOf(begin, first...),
+		}
+		stats["merged"].Merge(statOf(begin, test.ds[half:]...))
+		for how, stat := range stats {
+			t.Run(test.name+" "+how, func(t *testing.T) {
+				if got := float64(stat.StdDevLatency); math.Abs(got-test.stddev) > 1 {
+					t.Errorf("stddev %s, want %s", stat.StdDevLatency, time.Duration(test.stddev))
+				}
+				if got, want := stat.CVLatency(), test.stddev/test.mean; math.Abs(got-want) > 1e-6 {
+					t.Errorf("cv %f, want %f", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestMergeBenchRun(t *testing.T) {
+	type benchRun struct {
+		btype, subtype BenchType
+		run            int
+	}
+	tests := []struct {
+		name   string
+		a, b   benchRun
+		merged benchRun
+		label  string
+	}{
+		{"same", benchRun{MIXED, READ, 3}, benchRun{MIXED, READ, 3}, benchRun{MIXED, READ, 3}, "MIXED.READ.3"},
+		{"sub types", benchRun{MIXED, READ, 3}, benchRun{MIXED, WRITE, 3}, benchRun{MIXED, 0, 3}, "MIXED.3"},
+		{"bench types", benchRun{CREATE, 0, 1}, benchRun{FILL, 0, 1}, benchRun{0, 0, 1}, "UNKNOWN.1"},
+	}
+	begin := time.Now()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := statOf(begin, time.Millisecond), statOf(begin, time.Millisecond)
+			a.BenchType, a.SubType, a.Run = test.a.btype, test.a.subtype, test.a.run
+			b.BenchType, b.SubType, b.Run = test.b.btype, test.b.subtype, test.b.run
+			a.Merge(b)
+			if got := (benchRun{a.BenchType, a.SubType, a.Run}); got != test.merged || a.Label() != test.label {
+				t.Errorf("merged %v labeled %s, want %v and %s", got, a.Label(), test.merged, test.label)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package bench
+
+import (
+	"encoding/json"
+)
+
+// RunStatus is the outcome of a benchmark in a form orchestration tools can
+// consume. It covers the measured READ, WRITE and MIXED runs or, if there
+// are none, all runs.
+type RunStatus struct {
+	Status         string  `json:"status"` // pass or fail
+	Operations     int64   `json:"operations"`
+	Errors         int64   `json:"errors"`
+	ErrorRate      float64 `json:"error_rate"`
+	PeakThroughput float64 `json:"peak_throughput"` // of the best run, req/s
+	P99Latency     int64   `json:"p99_latency"`     // of the worst run, ns
+}
+
+// NewRunStatus summarizes results. The benchmark fails if no request was
+// sent or more than maxErrorRate of them failed.
+func NewRunStatus(results []RunResult, maxErrorRate float64) *RunStatus {
+	measured := results[:0:0]
+	for _, result := range results {
+		if result.Type&(READ|WRITE|MIXED) != 0 {
+			measured = append(measured, result)
+		}
+	}
+	if len(measured) == 0 {
+		measured = results
+	}
+	status := &RunStatus{Status: "fail"}
+	for _, result := range measured {
+		status.Operations += result.Stat.Ops
+		status.Errors += result.Stat.Errors
+		if result.Throughput > status.PeakThroughput {
+			status.PeakThroughput = result.Throughput
+		}
+		if result.Stat.NinetyNinethLatency > status.P99Latency {
+			status.P99Latency = result.Stat.NinetyNinethLatency
+		}
+	}
+	if status.Operations > 0 {
+		status.ErrorRate = float64(status.Errors) / float64(status.Operations)
+		if status.ErrorRate <= maxErrorRate {
+			status.Status = "pass"
+		}
+	}
+	return status
+}
+
+// String returns the status as a single line of JSON.
+func (self *RunStatus) String() string {
+	data, _ := json.Marshal(self)
+	return string(data)
+}
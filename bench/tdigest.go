@@ -0,0 +1,182 @@
+package bench
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LATENCY_MODES lists how the percentiles of the summary are computed:
+// exact from all latencies, or estimated from a t-digest of every stat
+// that is merged along with the stats.
+var LATENCY_MODES = []string{"exact", "tdigest"}
+
+// TDIGEST_COMPRESSION keeps a t-digest to about 300 centroids, enough for
+// p50 to p99.9 within 1% of the exact values for latency distributions.
+const TDIGEST_COMPRESSION = 500
+
+// checkLatencyMode makes sure mode is one of LATENCY_MODES.
+func checkLatencyMode(mode string) error {
+	for _, m := range LATENCY_MODES {
+		if m == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown latency mode '%s', must be one of %s\n", mode, strings.Join(LATENCY_MODES, "|"))
+}
+
+type centroid struct {
+	mean  float64
+	count float64
+}
+
+// tdigest is a merging t-digest (Dunning and Ertl), a sketch of the
+// distribution of the latencies in ns whose quantiles are accurate in the
+// tails. Values are buffered and merged into the centroids in batches;
+// digests merge like stats, so the quantiles of merged stats need none of
+// their samples.
+type tdigest struct {
+	compression float64
+	centroids   []centroid // sorted by mean
+	buffer      []centroid // not merged yet
+	count       float64    // of the centroids and the buffer
+	min         float64
+	max         float64
+}
+
+func newTDigest(compression float64) *tdigest {
+	return &tdigest{compression: compression}
+}
+
+// add records a latency, nil-safe for stats without a digest.
+func (self *tdigest) add(d time.Duration) {
+	if self == nil {
+		return
+	}
+	x := float64(d.Nanoseconds())
+	if self.count == 0 || x < self.min {
+		self.min = x
+	}
+	if self.count == 0 || x > self.max {
+		self.max = x
+	}
+	self.buffer = append(self.buffer, centroid{x, 1})
+	self.count++
+	if len(self.buffer) >= 5*int(self.compression) {
+		self.compress()
+	}
+}
+
+// scale is the k1 scale function, which allows larger centroids in the
+// middle of the distribution than in the tails.
+func (self *tdigest) scale(q float64) float64 {
+	return self.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+// compress merges the buffer into the centroids.
+func (self *tdigest) compress() {
+	if len(self.buffer) == 0 {
+		return
+	}
+	all := append(self.centroids, self.buffer...)
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+	merged := make([]centroid, 0, len(self.centroids)+1)
+	cur := all[0]
+	var before float64 // count of the centroids before cur
+	for _, c := range all[1:] {
+		q0 := before / self.count
+		q2 := (before + cur.count + c.count) / self.count
+		if self.scale(q2)-self.scale(q0) <= 1 {
+			cur.count += c.count
+			cur.mean += (c.mean - cur.mean) * c.count / cur.count
+		} else {
+			merged = append(merged, cur)
+			before += cur.count
+			cur = c
+		}
+	}
+	self.centroids = append(merged, cur)
+	self.buffer = nil
+}
+
+// mergeTDigests returns a new digest of the values of both, which are
+// left as they are since they may be shared by copies of a stat.
+func mergeTDigests(a, b *tdigest) *tdigest {
+	var merged *tdigest
+	for _, d := range []*tdigest{a, b} {
+		if d == nil || d.count == 0 {
+			continue
+		}
+		if merged == nil {
+			merged = newTDigest(d.compression)
+			merged.min, merged.max = d.min, d.max
+		}
+		merged.min = math.Min(merged.min, d.min)
+		merged.max = math.Max(merged.max, d.max)
+		merged.buffer = append(merged.buffer, d.centroids...)
+		merged.buffer = append(merged.buffer, d.buffer...)
+		merged.count += d.count
+	}
+	if merged == nil {
+		return newTDigest(TDIGEST_COMPRESSION)
+	}
+	merged.compress()
+	return merged
+}
+
+// quantile estimates the q quantile, in (0, 1], interpolating between the
+// centroids around it; single values are returned as they are.
+func (self *tdigest) quantile(q float64) time.Duration {
+	self.compress()
+	cs := self.centroids
+	if len(cs) == 0 {
+		return 0
+	}
+	if len(cs) == 1 {
+		return time.Duration(math.Round(cs[0].mean))
+	}
+	index := q * self.count
+	if index < 1 {
+		return time.Duration(math.Round(self.min))
+	}
+	if index > self.count-1 {
+		return time.Duration(math.Round(self.max))
+	}
+	// the first and last centroid are interpolated towards the extremes
+	if first := cs[0]; first.count > 1 && index < first.count/2 {
+		return time.Duration(math.Round(self.min + (index-1)/(first.count/2-1)*(first.mean-self.min)))
+	}
+	if last := cs[len(cs)-1]; last.count > 1 && self.count-index <= last.count/2 {
+		return time.Duration(math.Round(self.max - (self.count-index-1)/(last.count/2-1)*(self.max-last.mean)))
+	}
+	var x float64
+	before := cs[0].count / 2 // count up to the middle of centroid i
+	for i := 0; i < len(cs)-1; i++ {
+		dw := (cs[i].count + cs[i+1].count) / 2
+		if before+dw <= index {
+			before += dw
+			continue
+		}
+		var left, right float64
+		if cs[i].count == 1 {
+			if index-before < 0.5 {
+				x = cs[i].mean
+				break
+			}
+			left = 0.5
+		}
+		if cs[i+1].count == 1 {
+			if before+dw-index <= 0.5 {
+				x = cs[i+1].mean
+				break
+			}
+			right = 0.5
+		}
+		z1, z2 := index-before-left, before+dw-index-right
+		x = (cs[i].mean*z2 + cs[i+1].mean*z1) / (z1 + z2)
+		break
+	}
+	return time.Duration(math.Round(x))
+}
@@ -0,0 +1,110 @@
+package bench
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// latencySample returns n latencies of a distribution drawn with a fixed
+// seed, as the records of successful requests.
+func latencySample(n int, draw func(rd *rand.Rand) float64) []BenchLatency {
+	rd := rand.New(rand.NewSource(1))
+	lats := make([]BenchLatency, n)
+	for i := range lats {
+		lats[i].Latency = time.Duration(draw(rd))
+	}
+	return lats
+}
+
+func TestTDigestQuantiles(t *testing.T) {
+	ps := []float64{.5, .9, .95, .99, .999}
+	tests := []struct {
+		name    string
+		digests int // the sample is split over that many merged digests
+		draw    func(rd *rand.Rand) float64
+	}{
+		{"uniform", 1, func(rd *rand.Rand) float64 { return 1e6 + rd.Float64()*9e6 }},
+		{"exponential", 1, func(rd *rand.Rand) float64 { return 5e5 + rd.ExpFloat64()*1e6 }},
+		{"lognormal", 1, func(rd *rand.Rand) float64 { return math.Exp(14 + rd.NormFloat64()*.5) }},
+		{"lognormal merged", 8, func(rd *rand.Rand) float64 { return math.Exp(14 + rd.NormFloat64()*.5) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lats := latencySample(100000, test.draw)
+			var digest *tdigest
+			for i := 0; i < test.digests; i++ {
+				part := newTDigest(TDIGEST_COMPRESSION)
+				for j := i; j < len(lats); j += test.digests {
+					part.add(lats[j].Latency)
+				}
+				digest = mergeTDigests(digest, part)
+			}
+			exact := latencyPercentiles(lats, ps)
+			for i, p := range ps {
+				estimate := digest.quantile(p)
+				if e := math.Abs(float64(estimate-exact[i])) / float64(exact[i]); e > .01 {
+					t.Errorf("p%g: estimated %s, exact %s, off by %.2f%%", p*100, estimate, exact[i], e*100)
+				}
+			}
+		})
+	}
+}
+
+func TestTDigestEdges(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []time.Duration
+		q      float64
+		want   time.Duration
+	}{
+		{"empty", nil, .99, 0},
+		{"single", []time.Duration{42}, .5, 42},
+		{"min", []time.Duration{3, 1, 2}, .01, 1},
+		{"max", []time.Duration{3, 1, 2}, 1, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			digest := newTDigest(TDIGEST_COMPRESSION)
+			for _, v := range test.values {
+				digest.add(v)
+			}
+			if got := digest.quantile(test.q); got != test.want {
+				t.Errorf("quantile(%g) = %s, want %s", test.q, got, test.want)
+			}
+		})
+	}
+}
+
+// TestLeanStatPercentiles checks that stats without latencies, as in the
+// lean tdigest mode, merge into percentiles close to the exact ones.
+func TestLeanStatPercentiles(t *testing.T) {
+	lats := latencySample(50000, func(rd *rand.Rand) float64 { return math.Exp(13 + rd.NormFloat64()*.7) })
+	var all *BenchStat
+	for c := 0; c < 4; c++ {
+		stat := &BenchStat{digest: newTDigest(TDIGEST_COMPRESSION), running: newRunningStats(time.Now(), false)}
+		for i := c; i < len(lats); i += 4 {
+			stat.add("s", time.Now(), lats[i].Latency, nil)
+			stat.running.add(lats[i])
+		}
+		stat.Latencies = nil
+		if all == nil {
+			copied := *stat
+			all = &copied
+		} else {
+			all.Merge(stat)
+		}
+	}
+	if all.Latencies != nil {
+		t.Fatalf("merged stat has %d latencies, want none", len(all.Latencies))
+	}
+	all.ComputePercentiles()
+	exact := latencyPercentiles(lats, []float64{.5, .9, .95, .99, .999})
+	got := []time.Duration{all.P50Latency, all.P90Latency, all.P95Latency, all.P99Latency, all.P999Latency}
+	for i := range exact {
+		if e := math.Abs(float64(got[i]-exact[i])) / float64(exact[i]); e > .01 {
+			t.Errorf("percentile %d: estimated %s, exact %s, off by %.2f%%", i, got[i], exact[i], e*100)
+		}
+	}
+}
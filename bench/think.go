@@ -0,0 +1,41 @@
+package bench
+
+import (
+	"fmt"
+	mrand "math/rand"
+	"time"
+)
+
+// THINK_DISTS lists the distributions of the think time between requests.
+var THINK_DISTS = []string{"constant", "uniform", "exponential"}
+
+func checkThinkDist(dist string) error {
+	for _, d := range THINK_DISTS {
+		if d == dist {
+			return nil
+		}
+	}
+	return fmt.Errorf("Parameter 'think_time_dist' must be one of %v\n", THINK_DISTS)
+}
+
+// thinkTime draws the pause before the next request of a closed loop. All
+// distributions have the mean ThinkTime: uniform spans [0, 2*ThinkTime] and
+// exponential gives Poisson arrivals per client.
+func (self *Benchmark) thinkTime(rd *mrand.Rand) time.Duration {
+	switch self.ThinkDist {
+	case "uniform":
+		return time.Duration(rd.Int63n(2*int64(self.ThinkTime) + 1))
+	case "exponential":
+		return time.Duration(rd.ExpFloat64() * float64(self.ThinkTime))
+	}
+	return self.ThinkTime
+}
+
+// thinkCol returns the mean think time per request that a stat achieved.
+func thinkCol(stat *BenchStat) string {
+	var mean time.Duration
+	if stat.Ops > 0 {
+		mean = stat.ThinkTime / time.Duration(stat.Ops)
+	}
+	return fmt.Sprintf(",%d", mean.Nanoseconds())
+}
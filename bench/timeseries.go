@@ -0,0 +1,94 @@
+package bench
+
+import (
+	"fmt"
+	"time"
+)
+
+// dumpTimeSeries appends the requests of a bench run bucketed by the
+// second they started in, counted from groupStartTime, to the timeseries
+// file. Every client gets a row for every second up to the end of the run,
+// so seconds without requests show up as gaps. With AggregateOnly the
+// clients are merged into client 0.
+func (self *Benchmark) dumpTimeSeries(btype BenchType, run int, groupStartTime time.Time) {
+	var end time.Time
+	stats := make(map[int][]BenchLatency)
+	var ids []int
+	for _, client := range self.clients {
+		if client.Stat == nil {
+			continue
+		}
+		if client.Stat.EndTime.After(end) {
+			end = client.Stat.EndTime
+		}
+		id := client.Id
+		if self.AggregateOnly {
+			id = 0
+		}
+		if _, ok := stats[id]; !ok {
+			ids = append(ids, id)
+		}
+		stats[id] = append(stats[id], client.Stat.Latencies...)
+	}
+	if len(ids) == 0 || !end.After(groupStartTime) {
+		return
+	}
+	tf, err := self.openOutput("timeseries.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer tf.Close()
+	if info, err := tf.Stat(); err == nil && info.Size() == 0 {
+		tf.WriteString("client_id,bench_type,run,second_offset,ops,errors,avg_latency_ns,p99_latency_ns\n")
+	}
+	seconds := int(end.Sub(groupStartTime).Seconds()) + 1
+	for _, id := range ids {
+		for second, bucket := range latencyBuckets(stats[id], groupStartTime, time.Second, seconds) {
+			tf.WriteString(fmt.Sprintf("%d,%s,%d,%d,%d,%d,%d,%d\n", id, btype.String(), run, second, bucket.Ops, bucket.Errors,
+				bucket.AvgLatency.Nanoseconds(), bucket.P99Latency.Nanoseconds()))
+		}
+	}
+}
+
+// dumpBuckets appends the stats of every BucketInterval since the start of
+// the stat of every client in a bench run to the buckets file. With
+// AggregateOnly, the merged stat of the clients is written as client 0.
+func (self *Benchmark) dumpBuckets(btype BenchType, run int) {
+	ids := make([]int, 0, len(self.clients))
+	stats := make([]*BenchStat, 0, len(self.clients))
+	for _, client := range self.clients {
+		if client.Stat != nil {
+			ids = append(ids, client.Id)
+			stats = append(stats, client.Stat)
+		}
+	}
+	if self.AggregateOnly {
+		all := make([]*BenchStat, len(self.clients))
+		for i, client := range self.clients {
+			all[i] = client.Stat
+		}
+		merged, _ := self.aggregateStats(all)
+		if merged == nil {
+			return
+		}
+		ids, stats = []int{0}, []*BenchStat{merged}
+	}
+	if len(stats) == 0 {
+		return
+	}
+	bf, err := self.openOutput("buckets.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer bf.Close()
+	if info, err := bf.Stat(); err == nil && info.Size() == 0 {
+		bf.WriteString("client_id,bench_type,run,bucket_start_offset,ops,errors,avg_ns,p99_ns\n")
+	}
+	for i, stat := range stats {
+		for k, bucket := range stat.Buckets(self.BucketInterval) {
+			bf.WriteString(fmt.Sprintf("%d,%s,%d,%d,%d,%d,%d,%d\n", ids[i], btype.String(), run,
+				(time.Duration(k) * self.BucketInterval).Nanoseconds(), bucket.Ops, bucket.Errors,
+				bucket.AvgLatency.Nanoseconds(), bucket.P99Latency.Nanoseconds()))
+		}
+	}
+}
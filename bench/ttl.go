@@ -0,0 +1,293 @@
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	mrand "math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	ttlKey = "ttl"
+	// the server removes expired TTL nodes on its container check, which
+	// runs every znode.container.checkIntervalMs, 60s by default
+	ttlExpiryWait = 3 * time.Minute
+
+	opCreateTTL    = 21
+	opCloseSession = -11
+	modeTTL        = 5 // CreateMode.PERSISTENT_WITH_TTL
+	errCodeUnimpl  = -6
+	errCodeNoNode  = -101
+	errCodeExists  = -110
+)
+
+// errTTLUnsupported is returned by createTTL if the server rejects TTL
+// nodes, which it does unless started with zookeeper.extendedTypesEnabled.
+var errTTLUnsupported = errors.New("TTL nodes are not enabled on the server (zookeeper.extendedTypesEnabled=true)")
+
+// ttlConn is a minimal ZooKeeper session for the TTL benchmark. go-zookeeper
+// has no create request for TTL nodes, so it speaks just enough of the
+// protocol itself: the session handshake, createTTL and closeSession.
+type ttlConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	xid  int32
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.BigEndian, int32(len(s)))
+	buf.WriteString(s)
+}
+
+func writeBuffer(buf *bytes.Buffer, b []byte) {
+	if b == nil {
+		binary.Write(buf, binary.BigEndian, int32(-1))
+		return
+	}
+	binary.Write(buf, binary.BigEndian, int32(len(b)))
+	buf.Write(b)
+}
+
+// dialTTL opens a session to endpoint that lasts as long as timeout
+// without requests.
+func dialTTL(endpoint string, timeout time.Duration) (*ttlConn, error) {
+	conn, err := net.DialTimeout("tcp", endpoint, ConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	self := &ttlConn{conn: conn, r: bufio.NewReader(conn)}
+	var req bytes.Buffer
+	binary.Write(&req, binary.BigEndian, int32(0))                        // protocol version
+	binary.Write(&req, binary.BigEndian, int64(0))                        // last zxid seen
+	binary.Write(&req, binary.BigEndian, int32(timeout/time.Millisecond)) // session timeout
+	binary.Write(&req, binary.BigEndian, int64(0))                        // session id
+	writeBuffer(&req, make([]byte, 16))                                   // password
+	conn.SetDeadline(time.Now().Add(ConnectTimeout))
+	if err := self.write(req.Bytes()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := self.read()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if len(resp) < 16 || binary.BigEndian.Uint64(resp[8:16]) == 0 {
+		conn.Close()
+		return nil, fmt.Errorf("no session from %s", endpoint)
+	}
+	conn.SetDeadline(time.Time{})
+	return self, nil
+}
+
+func (self *ttlConn) write(packet []byte) error {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, int32(len(packet)))
+	buf.Write(packet)
+	_, err := self.conn.Write(buf.Bytes())
+	return err
+}
+
+func (self *ttlConn) read() ([]byte, error) {
+	var n int32
+	if err := binary.Read(self.r, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	if n < 0 || n > 1<<20 {
+		return nil, fmt.Errorf("invalid packet length %d", n)
+	}
+	packet := make([]byte, n)
+	_, err := io.ReadFull(self.r, packet)
+	return packet, err
+}
+
+// call sends a request and returns the error code of its reply.
+func (self *ttlConn) call(op int32, body []byte) (int32, error) {
+	self.xid++
+	var req bytes.Buffer
+	binary.Write(&req, binary.BigEndian, self.xid)
+	binary.Write(&req, binary.BigEndian, op)
+	req.Write(body)
+	self.conn.SetDeadline(time.Now().Add(ConnectTimeout))
+	defer self.conn.SetDeadline(time.Time{})
+	if err := self.write(req.Bytes()); err != nil {
+		return 0, err
+	}
+	for {
+		resp, err := self.read()
+		if err != nil {
+			return 0, err
+		}
+		// reply header: xid, zxid, error code
+		if len(resp) < 16 {
+			return 0, fmt.Errorf("short reply of %d bytes", len(resp))
+		}
+		if int32(binary.BigEndian.Uint32(resp[0:4])) == self.xid {
+			return int32(binary.BigEndian.Uint32(resp[12:16])), nil
+		}
+	}
+}
+
+// createTTL creates a persistent znode that the server removes once it
+// had no children and was not modified for ttl.
+func (self *ttlConn) createTTL(p string, data []byte, ttl time.Duration) error {
+	var body bytes.Buffer
+	writeString(&body, p)
+	writeBuffer(&body, data)
+	binary.Write(&body, binary.BigEndian, int32(len(zkCreateACL)))
+	for _, acl := range zkCreateACL {
+		binary.Write(&body, binary.BigEndian, acl.Perms)
+		writeString(&body, acl.Scheme)
+		writeString(&body, acl.ID)
+	}
+	binary.Write(&body, binary.BigEndian, int32(modeTTL))
+	binary.Write(&body, binary.BigEndian, int64(ttl/time.Millisecond))
+	code, err := self.call(opCreateTTL, body.Bytes())
+	switch {
+	case err != nil:
+		return err
+	case code == 0:
+		return nil
+	case code == errCodeUnimpl:
+		return errTTLUnsupported
+	case code == errCodeExists:
+		return zk.ErrNodeExists
+	case code == errCodeNoNode:
+		return zk.ErrNoNode
+	default:
+		return fmt.Errorf("zk error code %d", code)
+	}
+}
+
+func (self *ttlConn) Close() {
+	self.call(opCloseSession, nil)
+	self.conn.Close()
+}
+
+// runTTL benchmarks creating TTL nodes against creating persistent ones on
+// the first server. With TTLVerify, it then waits for the server to expire
+// the TTL nodes and reports how long after their creation they vanished.
+func (self *Benchmark) runTTL() {
+	tf, err := self.openOutput("ttl.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer tf.Close()
+	if info, err := tf.Stat(); err == nil && info.Size() == 0 {
+		tf.WriteString("samples,ttl,supported,errors,average_persistent_latency,average_latency,99th_latency,max_latency,expired,average_expiry_time\n")
+	}
+	if self.Backend != "zookeeper" {
+		log.Printf("[Bench]: skip TTL nodes since the %s backend has none\n", self.Backend)
+		return
+	}
+	root := self.root_client
+	if root == nil {
+		return
+	}
+	parent := self.Namespace + "/" + ttlKey
+	if _, err := root.CreateIfNotExist(ttlKey, nil); err != nil {
+		root.Log("error in creating TTL parent: %v", err)
+		return
+	}
+	defer func() {
+		conn := root.currentConn()
+		if conn == nil {
+			return
+		}
+		children, _, _ := conn.Children(parent)
+		for _, child := range children {
+			conn.Delete(parent+"/"+child, -1)
+		}
+		conn.Delete(parent, -1)
+	}()
+	tc, err := dialTTL(self.Endpoints[0], 30*time.Second)
+	if err != nil {
+		log.Printf("[Bench]: failed to open TTL session to %s: %v\n", self.Endpoints[0], err)
+		return
+	}
+	defer tc.Close()
+	val := randBytes(mrand.NewSource(time.Now().UnixNano()), self.ValueSizeBytes)
+
+	var stat, persistent BenchStat
+	created := make(map[string]time.Time)
+	log.Printf("[Bench]: start TTL nodes for %d samples\n", self.TTLSamples)
+	for i := 0; i < self.TTLSamples; i++ {
+		p := fmt.Sprintf("%s/p.%d", parent, i)
+		conn := root.currentConn()
+		err := zk.ErrNoServer
+		begin := time.Now()
+		if conn != nil {
+			_, err = conn.Create(p, val, zkCreateFlags, zkCreateACL)
+		}
+		persistent.add(root.ServerAddr(), begin, time.Since(begin), err)
+		if err == nil {
+			conn.Delete(p, -1)
+		}
+
+		name := fmt.Sprintf("t.%d", i)
+		begin = time.Now()
+		err = tc.createTTL(parent+"/"+name, val, self.TTL)
+		if err == errTTLUnsupported {
+			log.Printf("[Bench]: server %s does not support TTL nodes: %v\n", self.Endpoints[0], err)
+			tf.WriteString(fmt.Sprintf("%d,%d,false,0,0,0,0,0,0,0\n", self.TTLSamples, self.TTL.Nanoseconds()))
+			return
+		}
+		stat.add(self.Endpoints[0], begin, time.Since(begin), err)
+		if err != nil {
+			log.Printf("[Bench]: error in creating TTL node %s: %v\n", name, err)
+		} else {
+			created[name] = begin
+		}
+	}
+	stat.finish()
+	persistent.finish()
+
+	expired := 0
+	var expiry time.Duration
+	if self.TTLVerify && len(created) > 0 {
+		log.Printf("[Bench]: wait for %d TTL nodes to expire\n", len(created))
+		for deadline := time.Now().Add(self.TTL + ttlExpiryWait); len(created) > 0 && time.Now().Before(deadline); time.Sleep(500 * time.Millisecond) {
+			conn := root.currentConn()
+			if conn == nil {
+				continue
+			}
+			children, _, err := conn.Children(parent)
+			if err != nil {
+				continue
+			}
+			live := make(map[string]bool)
+			for _, child := range children {
+				if strings.HasPrefix(child, "t.") {
+					live[child] = true
+				}
+			}
+			for name, begin := range created {
+				if !live[name] {
+					expired++
+					expiry += time.Since(begin)
+					delete(created, name)
+				}
+			}
+		}
+		if len(created) > 0 {
+			log.Printf("[Bench]: WARNING: %d TTL nodes did not expire in time\n", len(created))
+		}
+	}
+	var avgExpiry time.Duration
+	if expired > 0 {
+		avgExpiry = expiry / time.Duration(expired)
+	}
+	tf.WriteString(fmt.Sprintf("%d,%d,true,%d,%d,%d,%d,%d,%d,%d\n", stat.Ops, self.TTL.Nanoseconds(), stat.Errors,
+		persistent.AvgLatency.Nanoseconds(), stat.AvgLatency.Nanoseconds(), stat.NinetyNinethLatency,
+		stat.MaxLatency.Nanoseconds(), expired, avgExpiry.Nanoseconds()))
+	log.Printf("[Bench]: done TTL nodes: avg create %s vs %s persistent\n", stat.AvgLatency, persistent.AvgLatency)
+}
@@ -0,0 +1,187 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// runAdaptiveWarmup warms up every client until its read latency settles
+// rather than for a fixed NRequests/10 requests. A client is warm once the
+// coefficient of variation (stddev/mean) of its last WarmupWindow latencies
+// drops to WarmupCV, or gives up after WarmupMax requests. The number of
+// requests each client needed is appended to the warmup file.
+func (self *Benchmark) runAdaptiveWarmup(statf *os.File) {
+	ctx := self.context()
+	wf, err := self.openOutput("warmup.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer wf.Close()
+	if info, err := wf.Stat(); err == nil && info.Size() == 0 {
+		wf.WriteString("client_id,requests,converged,final_cv\n")
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	groupStartTime := time.Now()
+	for _, client := range self.clients {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			client.Log("start adaptive warm-up")
+			stat := &BenchStat{OpType: "WARM_UP.1", BenchType: WARM_UP, Run: 1}
+			sent, received := client.BytesTransferred()
+			window := make([]float64, 0, self.WarmupWindow)
+			converged := false
+			cv := math.NaN()
+			var n, ok int64
+			for n < self.WarmupMax && !converged {
+				begin := time.Now()
+				_, _, err := client.Read(ctx, "")
+				d := time.Since(begin)
+				stat.add(client.ServerAddr(), begin, d, err)
+				if self.rawstream != nil {
+					self.rawstream.Write(client.Id, WARM_UP, 1, n, stat.Latencies[n])
+				}
+				n++
+				if err != nil {
+					client.Log("error in processing warm-up request: %v", err)
+					if err == zk.ErrNoServer {
+						client.Reconnect()
+					}
+					continue
+				}
+				client.ResetBackoff()
+				if len(window) < cap(window) {
+					window = append(window, float64(d))
+				} else {
+					window[ok%int64(cap(window))] = float64(d)
+				}
+				ok++
+				if len(window) == cap(window) {
+					cv = variation(window)
+					converged = cv <= self.WarmupCV
+				}
+			}
+			stat.finish()
+			s, r := client.BytesTransferred()
+			stat.addBytes(s-sent, r-received, stat.Ops, self.ProtocolOverhead)
+			client.Stat = stat
+			if converged {
+				client.Log("warm-up converged after %d requests", n)
+			} else {
+				client.Log("warm-up did not converge after %d requests, cv %f", n, cv)
+			}
+			mutex.Lock()
+			wf.WriteString(fmt.Sprintf("%d,%d,%t,%f\n", client.Id, n, converged, cv))
+			mutex.Unlock()
+		}(client)
+	}
+	wg.Wait()
+	log.Printf("[Bench]: done adaptive warm-up\n")
+	self.dumpStats(WARM_UP, 1, groupStartTime, statf)
+}
+
+// variation returns the coefficient of variation of values.
+func variation(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if mean == 0 {
+		return 0
+	}
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sq/float64(len(values))) / mean
+}
+
+// childWarmupOps is the number of reads sent on every warmed child session.
+const childWarmupOps = 10
+
+// childCounts returns how many child sessions MIXED opens at each level
+// below a client: two, one per request type, each with Parallelism children
+// of its own, or, with asymmetric pools or a phased mix, only the latter.
+func (self *Benchmark) childCounts() []int {
+	var counts []int
+	if self.ReadPoolFraction == 0 && len(self.PhasedMix) == 0 {
+		counts = append(counts, 2)
+	}
+	if self.Parallelism > 1 {
+		counts = append(counts, self.Parallelism)
+	}
+	return counts
+}
+
+// warmChildren opens the child sessions of counts below client, sends a few
+// reads on each and keeps them for later AddChildren calls.
+func warmChildren(ctx context.Context, client *Client, counts []int, stat *BenchStat) {
+	if len(counts) == 0 {
+		return
+	}
+	client.KeepChildren = true
+	client.AddChildren(counts[0])
+	for _, child := range client.Children {
+		child.KeepChildren = true
+		for i := 0; i < childWarmupOps; i++ {
+			begin := time.Now()
+			_, _, err := child.Read(ctx, "")
+			stat.add(child.ServerAddr(), begin, time.Since(begin), err)
+		}
+		warmChildren(ctx, child, counts[1:], stat)
+	}
+	client.CloseChildren()
+}
+
+// runChildWarmup establishes the child sessions MIXED uses ahead of the
+// measured runs, so their connection setup does not land in the MIXED
+// latencies. The time every client took is appended to the child warmup
+// file.
+func (self *Benchmark) runChildWarmup() {
+	counts := self.childCounts()
+	if self.Type&MIXED == 0 || len(counts) == 0 {
+		return
+	}
+	cf, err := self.openOutput("child_warmup.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer cf.Close()
+	if info, err := cf.Stat(); err == nil && info.Size() == 0 {
+		cf.WriteString("client_id,sessions,warmup_time,operations,errors,average_latency\n")
+	}
+	sessions := 0
+	for i, per := 1, 1; i <= len(counts); i++ {
+		per *= counts[i-1]
+		sessions += per
+	}
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for _, client := range self.clients {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			var stat BenchStat
+			start := time.Now()
+			warmChildren(self.context(), client, counts, &stat)
+			elapsed := time.Since(start)
+			stat.finish()
+			mutex.Lock()
+			cf.WriteString(fmt.Sprintf("%d,%d,%d,%d,%d,%d\n", client.Id, sessions, elapsed.Nanoseconds(),
+				stat.Ops, stat.Errors, stat.AvgLatency.Nanoseconds()))
+			mutex.Unlock()
+			client.Log("warmed up %d child sessions in %s", sessions, elapsed)
+		}(client)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,139 @@
+package bench
+
+import (
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const watchedKey = "watched"
+
+// parseCounts parses a comma-separated list of non-negative counts.
+func parseCounts(spec string) ([]int, error) {
+	var counts []int
+	for _, s := range strings.Split(spec, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("Invalid count '%s': must be a non-negative integer\n", s)
+		}
+		counts = append(counts, n)
+	}
+	return counts, nil
+}
+
+// runWatchFanout measures how the number of watches on a znode affects
+// the latency of writes to it. Watches are one-shot and deduplicated per
+// session, so every watch is set by its own helper session and re-armed
+// before each write. Besides the write latency, the notify latency is the
+// time from issuing a write until the last watcher got its notification.
+func (self *Benchmark) runWatchFanout() {
+	ctx := self.context()
+	wf, err := self.openOutput("watches.dat")
+	if err != nil {
+		panic(err)
+	}
+	defer wf.Close()
+	if info, err := wf.Stat(); err == nil && info.Size() == 0 {
+		wf.WriteString("watch_count,writes,errors,average_latency,99th_latency,max_latency,average_notify_latency,missed_notifications\n")
+	}
+	if self.Backend == "etcd" {
+		log.Printf("[Bench]: skip watch fan-out since the etcd backend does not support watches\n")
+		return
+	}
+	if len(self.clients) == 0 || self.root_client == nil {
+		return
+	}
+	writer := self.clients[0]
+	p := self.Namespace + "/" + watchedKey
+	val := randBytes(mrand.NewSource(time.Now().UnixNano()), self.ValueSizeBytes)
+	if _, err := self.root_client.CreateIfNotExist(watchedKey, val); err != nil {
+		self.root_client.Log("error in creating watched znode: %v", err)
+		return
+	}
+
+	max := 0
+	for _, n := range self.WatchCounts {
+		if n > max {
+			max = n
+		}
+	}
+	helpers := make([]*Client, 0, max)
+	defer func() {
+		for _, helper := range helpers {
+			helper.Conn.Close()
+		}
+	}()
+	for i := 0; i < max; i++ {
+		s := i % len(self.Servers)
+		helper, err := NewClient(-1, fmt.Sprintf("watcher%d", i+1), self.Servers[s], ensemble(self.Endpoints, s), self.Namespace)
+		if err != nil {
+			log.Printf("[Bench]: failed to create watcher session %d: %v\n", i+1, err)
+			break
+		}
+		helpers = append(helpers, helper)
+	}
+
+	for _, count := range self.WatchCounts {
+		if count > len(helpers) {
+			count = len(helpers)
+		}
+		var stat BenchStat
+		var notify time.Duration
+		missed := 0
+		log.Printf("[Bench]: start write latency with %d watches\n", count)
+		for i := 0; i < self.WatchSamples; i++ {
+			var armed, fired sync.WaitGroup
+			var mutex sync.Mutex
+			var last time.Time
+			armed.Add(count)
+			fired.Add(count)
+			for _, helper := range helpers[:count] {
+				go func(helper *Client) {
+					defer fired.Done()
+					_, _, ch, err := helper.GetW(ctx, p)
+					armed.Done()
+					if err != nil {
+						return
+					}
+					select {
+					case <-ch:
+						now := time.Now()
+						mutex.Lock()
+						if now.After(last) {
+							last = now
+						}
+						mutex.Unlock()
+					case <-time.After(5 * time.Second):
+						mutex.Lock()
+						missed++
+						mutex.Unlock()
+					}
+				}(helper)
+			}
+			armed.Wait()
+			begin := time.Now()
+			err := writer.Write(ctx, p, val)
+			d := time.Since(begin)
+			stat.add(writer.ServerAddr(), begin, d, err)
+			if err != nil {
+				writer.Log("error in writing watched znode: %v", err)
+			}
+			fired.Wait()
+			if count > 0 && !last.IsZero() {
+				notify += last.Sub(begin)
+			}
+		}
+		stat.finish()
+		var avgNotify time.Duration
+		if count > 0 && self.WatchSamples > 0 {
+			avgNotify = notify / time.Duration(self.WatchSamples)
+		}
+		wf.WriteString(fmt.Sprintf("%d,%d,%d,%d,%d,%d,%d,%d\n", count, stat.Ops, stat.Errors, stat.AvgLatency.Nanoseconds(),
+			stat.NinetyNinethLatency, stat.MaxLatency.Nanoseconds(), avgNotify.Nanoseconds(), missed))
+		log.Printf("[Bench]: done write latency with %d watches: avg %s, notify %s\n", count, stat.AvgLatency, avgNotify)
+	}
+}
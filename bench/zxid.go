@@ -0,0 +1,65 @@
+package bench
+
+import (
+	"bufio"
+	"fmt"
+	mrand "math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// zxidSampler records the znode stat returned by a random sample of reads.
+// Comparing the mzxid seen by clients connected to different servers over
+// time shows out-of-order visibility and how far a follower lags behind.
+type zxidSampler struct {
+	mutex sync.Mutex
+	w     *bufio.Writer
+	f     *os.File
+	rate  float64
+	rd    *mrand.Rand
+}
+
+// newZxidSampler opens the samples file, replacing the samples of an
+// earlier run unless fresh is false, i.e. for later non-stop iterations.
+func newZxidSampler(path string, rate float64, fresh bool) (*zxidSampler, error) {
+	flags := os.O_APPEND | os.O_CREATE | os.O_RDWR
+	if fresh {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		w.WriteString("client_id,server,bench_type,run,key,time,czxid,mzxid,version\n")
+	}
+	return &zxidSampler{w: w, f: f, rate: rate, rd: mrand.New(mrand.NewSource(time.Now().UnixNano()))}, nil
+}
+
+func (self *zxidSampler) sample(c *Client, btype BenchType, run int, key string, stat *zk.Stat) {
+	if stat == nil {
+		return
+	}
+	now := time.Now()
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.rd.Float64() >= self.rate {
+		return
+	}
+	self.w.WriteString(fmt.Sprintf("%d,%s,%s,%d,%s,%s,%d,%d,%d\n", c.Id, c.Server, btype.String(), run,
+		c.FullPath(key), now.UTC().Format("2006-01-02T15:04:05.000000Z07:00"), stat.Czxid, stat.Mzxid, stat.Version))
+}
+
+func (self *zxidSampler) Close() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if err := self.w.Flush(); err != nil {
+		self.f.Close()
+		return err
+	}
+	return self.f.Close()
+}
@@ -46,13 +46,13 @@ func ParseConfig(file string) (*Config, error) {
 		if len(key) == 0 || len(val) == 0 {
 			return nil, fmt.Errorf("Empty key or value at line %d", lineno)
 		}
+		if len(prefix) > 0 {
+			key = prefix + "." + key
+		}
 		_, ok := kvs[key]
 		if ok {
 			return nil, fmt.Errorf("Key redefined at line %d", lineno)
 		}
-		if len(prefix) > 0 {
-			key = prefix + "." + key
-		}
 		kvs[key] = val
 	}
 	return &Config{KVs: kvs, File: file}, nil
@@ -68,6 +68,12 @@ func (self *Config) GetKeys(prefix string) []string {
 	return keys
 }
 
+// Has reports whether key is set in the config.
+func (self *Config) Has(key string) bool {
+	_, ok := self.KVs[key]
+	return ok
+}
+
 func (self *Config) GetInt(key string) (int, error) {
 	val, ok := self.KVs[key]
 	if !ok {
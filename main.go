@@ -3,53 +3,301 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	zkb "github.com/OrderLab/zkbench/bench"
+	zkr "github.com/OrderLab/zkbench/bench/report"
 )
 
 var (
-	conf      = flag.String("conf", "bench.conf", "Benchmark configuration file")
-	outprefix = flag.String("outprefix", "zkresult", "Benchmark stat filename prefix")
-	nonstop   = flag.Bool("nonstop", false, "Run the benchmarks non-stop")
-	purge     = flag.Bool("purge", false, "Purge all prior test data")
-	rawstat   = flag.Bool("rawstat", false, "Log the raw benchmark stats")
+	conf        = flag.String("conf", "bench.conf", "Benchmark configuration file")
+	outprefix   = flag.String("outprefix", "zkresult", "Benchmark stat filename prefix")
+	nonstop     = flag.Bool("nonstop", false, "Run the benchmarks non-stop")
+	purge       = flag.Bool("purge", false, "Purge all prior test data")
+	rawstat     = flag.Bool("rawstat", false, "Stream the raw benchmark stats to disk as requests complete")
+	rawgz       = flag.Bool("rawgz", false, "Gzip the raw stats, same as raw_compress = true")
+	zkverbose   = flag.Bool("zk-verbose", false, "Show go-zookeeper's internal connection logs")
+	selftest    = flag.Bool("selftest", false, "Run against an in-memory ZooKeeper instead of the configured servers")
+	initretries = flag.Int("init-retries", 0, "Retry a partially failed benchmark init this many times")
+	btype       = flag.String("type", "", "Override the bench type of the config, e.g. r or cru")
+	nosetup     = flag.Bool("no-setup", false, "Benchmark the existing children of the namespace without creating or removing data")
+	sanitize    = flag.String("sanitize", "", "Write sanitized copies of the results with this prefix, e.g. zkresult-2006-01-02-15_04_05-, and exit")
+	statusfile  = flag.String("status-file", "", "Also write the final JSON status line to this file")
+	record      = flag.String("record", "", "Record the key of every request to this file")
+	replay      = flag.String("replay", "", "Replay the keys recorded with -record instead of generating them")
+	aggregate   = flag.Bool("aggregate-only", false, "Write one summary row per bench run aggregated over all clients and no raw stats")
+	format      = flag.String("format", "csv", "Format of the summary and raw results: csv or json")
+	metricsaddr = flag.String("metrics-addr", "", "Serve live Prometheus metrics on this address, e.g. :9100, same as metrics_addr")
+	report      = flag.String("report", "", "Render the results with this prefix, e.g. zkresult-2006-01-02-15_04_05-, into an HTML report and exit")
+	compare     = flag.String("compare", "", "Compare the results of two prefixes A,B, write B's compare.dat and exit with 2 on a regression")
+	threshold   = flag.Float64("compare-threshold", 5, "Percent by which a metric must get worse to be a regression in -compare")
+	markers     = flag.Bool("markers", false, "Record phase markers signalled with SIGUSR1 (start) and SIGUSR2 (end)")
+	tui         = flag.Bool("tui", false, "Show a live dashboard of the requests on the terminal instead of the logs")
 )
 
+// dashboard is the live view of -tui, nil without a terminal
+var dashboard *zkb.Dashboard
+
+// manifests are amended with the exit status of the process
+var manifests []string
+
 type logWriter struct {
+	out io.Writer
 }
 
 func (writer logWriter) Write(bytes []byte) (int, error) {
-	return fmt.Print(time.Now().UTC().Format("2006-01-02T15:04:05.999Z") + string(bytes))
+	return fmt.Fprint(writer.out, time.Now().UTC().Format("2006-01-02T15:04:05.999Z")+string(bytes))
 }
 
 func main() {
 	flag.Parse()
+	if err := zkb.CheckFormat(*format); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v", err)
+		os.Exit(1)
+	}
+	if *report != "" {
+		path, err := zkr.Write(*report)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Fail to render report: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, "Report written to", path)
+		return
+	}
+	if *compare != "" {
+		compareRuns(*compare, *threshold)
+		return
+	}
 	config, err := zkb.ParseConfig(*conf)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Fail to parse config: %v\n", err)
 		os.Exit(1)
 	}
-	fmt.Println(zkb.TypeStr(config.Type))
+	printConfig(config)
+	if *btype != "" {
+		config.Type, err = zkb.ParseType(*btype)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -type: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *sanitize != "" {
+		if err := zkb.Sanitize(*sanitize, config); err != nil {
+			fmt.Fprintf(os.Stderr, "Fail to sanitize results: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintln(os.Stderr, zkb.TypeStr(config.Type))
 
 	log.SetFlags(0)
-	log.SetOutput(new(logWriter))
+	log.SetOutput(logWriter{os.Stderr})
+	zkb.ZKVerbose = *zkverbose
+
+	if *selftest {
+		config.Backend = "memory"
+	}
+	current := time.Now()
+	prefix := *outprefix + "-" + current.Format("2006-01-02-15_04_05") + "-"
+	// set once the benchmark is done, exits after the deferred writes below
+	exitCode := 0
+	defer func() {
+		for _, path := range manifests {
+			if err := zkb.SetManifestExitCode(path, exitCode); err != nil {
+				fmt.Fprintf(os.Stderr, "Fail to amend run manifest: %v\n", err)
+			}
+		}
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+	if !*purge {
+		meta := zkb.NewRunMeta(*conf, config)
+		defer func() {
+			if err := meta.Write(prefix + "meta.json"); err != nil {
+				fmt.Fprintf(os.Stderr, "Fail to write run metadata: %v\n", err)
+			}
+		}()
+	}
+	if *tui && !*purge {
+		if zkb.IsTerminal(os.Stderr) {
+			dashboard = zkb.NewDashboard(os.Stderr)
+			log.SetOutput(logWriter{dashboard}) // shown below the stats
+			defer stopDashboard()
+		} else {
+			log.Printf("stderr is not a terminal, logging the progress instead of -tui\n")
+		}
+	}
+	if *markers {
+		ml, err := zkb.OpenMarkerLog(prefix + "markers.dat")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Fail to open marker log: %v\n", err)
+			os.Exit(1)
+		}
+		defer ml.Close()
+		watchMarkers(ml)
+	}
+	if len(config.Profiles) == 0 {
+		b := runBenchmark(config, prefix)
+		stopDashboard()
+		if !*purge {
+			reportStatus(b.Results(), config.MaxErrorRate)
+			exitCode = checkAssertions(b.Results(), config)
+		}
+		return
+	}
 
+	// benchmark every profile in turn, then compare them
+	if *nonstop {
+		fmt.Fprintf(os.Stderr, "Cannot run profiles non-stop\n")
+		os.Exit(1)
+	}
+	results := make([][]zkb.RunResult, len(config.Profiles))
+	for i, profile := range config.Profiles {
+		fmt.Fprintf(os.Stderr, "Benchmarking profile %s\n", profile.Name)
+		pconfig := *config
+		pconfig.Servers = profile.Servers
+		pconfig.Endpoints = profile.Endpoints
+		results[i] = runBenchmark(&pconfig, prefix+profile.Name+"-").Results()
+	}
+	stopDashboard()
+	if !*purge {
+		err = zkb.WriteProfileReport(prefix+"profiles.dat", config.Profiles, results)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Fail to write profile report: %v\n", err)
+			os.Exit(1)
+		}
+		var all []zkb.RunResult
+		for _, r := range results {
+			all = append(all, r...)
+		}
+		reportStatus(all, config.MaxErrorRate)
+		exitCode = checkAssertions(all, config)
+	}
+}
+
+// stopDashboard restores the terminal of -tui for the final output.
+func stopDashboard() {
+	if dashboard != nil {
+		dashboard.Close()
+		dashboard = nil
+		log.SetOutput(logWriter{os.Stderr})
+	}
+}
+
+// printConfig prints the settings of config worth checking before a run to
+// stderr, stdout only carries the status.
+func printConfig(config *zkb.BenchConfig) {
+	fmt.Fprintf(os.Stderr, "read percent %f\n", config.ReadPercent)
+	fmt.Fprintf(os.Stderr, "write percent %f\n", config.WritePercent)
+	fmt.Fprintf(os.Stderr, "regenerate values %t\n", config.RegenerateValues)
+	if len(config.KeyList) > 0 {
+		fmt.Fprintf(os.Stderr, "loaded %d keys\n", len(config.KeyList))
+	}
+	if config.ClientRate > 0 {
+		fmt.Fprintf(os.Stderr, "client rate %f req/s\n", config.ClientRate)
+	}
+	if len(config.Scenario) > 0 {
+		fmt.Fprintf(os.Stderr, "loaded %d scenario phases\n", len(config.Scenario))
+	}
+	for _, profile := range config.Profiles {
+		fmt.Fprintf(os.Stderr, "profile %s: %s\n", profile.Name, strings.Join(profile.Endpoints, ","))
+	}
+	for i, phase := range config.PhasedMix {
+		fmt.Fprintf(os.Stderr, "mix phase %d: %s with read ratio %f\n", i+1, phase.Duration, phase.ReadRatio)
+	}
+	for i, server := range config.Servers {
+		fmt.Fprintln(os.Stderr, server+"="+config.Endpoints[i])
+	}
+}
+
+// reportStatus prints the outcome as a single line of JSON to stdout, which
+// carries nothing else so that it can be piped, and to the -status-file.
+func reportStatus(results []zkb.RunResult, maxErrorRate float64) {
+	status := zkb.NewRunStatus(results, maxErrorRate).String()
+	fmt.Println(status)
+	if *statusfile != "" {
+		if err := os.WriteFile(*statusfile, []byte(status+"\n"), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Fail to write status file: %v\n", err)
+		}
+	}
+}
+
+// checkAssertions prints the violations of the SLA assertions of config
+// and returns the exit code, 3 if there are any.
+func checkAssertions(results []zkb.RunResult, config *zkb.BenchConfig) int {
+	violations := zkb.CheckAssertions(results, config)
+	for _, v := range violations {
+		fmt.Fprintf(os.Stderr, "SLA violation: %s\n", v)
+	}
+	if len(violations) > 0 {
+		return 3
+	}
+	return 0
+}
+
+// compareRuns compares the results of the prefixes "A,B", prints the
+// deltas and writes them to B's compare.dat. It exits with 1 if the runs
+// cannot be compared and with 2 if B regressed.
+func compareRuns(prefixes string, threshold float64) {
+	ab := strings.Split(prefixes, ",")
+	if len(ab) != 2 || ab[0] == "" || ab[1] == "" {
+		fmt.Fprintf(os.Stderr, "-compare takes two prefixes A,B\n")
+		os.Exit(1)
+	}
+	deltas, err := zkr.Compare(ab[0], ab[1], threshold)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fail to compare results: %v\n", err)
+		os.Exit(1)
+	}
+	if err := zkr.PrintManifests(os.Stderr, ab[0], ab[1]); err != nil {
+		fmt.Fprintf(os.Stderr, "Fail to read manifests: %v\n", err)
+	}
+	zkr.PrintComparison(os.Stderr, deltas)
+	if err := zkr.WriteComparison(ab[1]+"compare.dat", deltas); err != nil {
+		fmt.Fprintf(os.Stderr, "Fail to write comparison: %v\n", err)
+		os.Exit(1)
+	}
+	if n := zkr.Regressions(deltas); n > 0 {
+		fmt.Fprintf(os.Stderr, "%d metrics regressed by more than %g%%\n", n, threshold)
+		os.Exit(2)
+	}
+}
+
+func runBenchmark(config *zkb.BenchConfig, prefix string) *zkb.Benchmark {
 	b := new(zkb.Benchmark)
 	b.BenchConfig = *config
+	if *rawgz {
+		b.RawCompress = true
+	}
+	if *metricsaddr != "" {
+		b.MetricsAddr = *metricsaddr
+	}
+	b.InitRetries = *initretries
+	b.NoSetup = *nosetup
+	b.RecordPath = *record
+	b.ReplayPath = *replay
+	b.AggregateOnly = *aggregate
+	b.Format = *format
+	b.Dashboard = dashboard
 	b.Init()
 	if *purge {
-		fmt.Println("Start purging test data")
+		fmt.Fprintln(os.Stderr, "Start purging test data")
 		b.Done()
-		fmt.Println("Done")
-		return
+		fmt.Fprintln(os.Stderr, "Done")
+		return b
+	}
+	if err := b.SmokeTest(); err != nil {
+		log.Fatal("Error:", err)
 	}
-	b.SmokeTest()
-	current := time.Now()
-	prefix := *outprefix + "-" + current.Format("2006-01-02-15_04_05") + "-"
 	var iter int64 = 1
+	manifests = append(manifests, prefix+"manifest.json")
 	for {
 		b.Run(prefix, *rawstat, *nonstop, iter)
 		if !*nonstop {
@@ -61,4 +309,22 @@ func main() {
 	if b.Cleanup {
 		b.Done()
 	}
+	return b
+}
+
+// watchMarkers marks the start and end of an externally triggered phase,
+// e.g. a reconfig, when the process receives SIGUSR1 and SIGUSR2.
+func watchMarkers(ml *zkb.MarkerLog) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGUSR1 {
+				ml.Mark("phase_start")
+			} else {
+				ml.Mark("phase_end")
+			}
+		}
+	}()
+	log.Printf("send SIGUSR1/SIGUSR2 to pid %d to mark the start/end of a phase\n", os.Getpid())
 }
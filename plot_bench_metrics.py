@@ -29,6 +29,9 @@ def main():
             # Only process READ, WRITE, and MIXED
             if btype not in ['READ', 'WRITE', 'MIXED']:
                 continue
+            # The ALL rows merge the clients, which are summed up below
+            if row['client_id'] == 'ALL':
+                continue
             
             # Parse timestamp (default 8-digit microseconds from Go)
             ts_str = row['group_start_time'].replace('Z', '+00:00')